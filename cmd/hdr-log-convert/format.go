@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+)
+
+// seconds is a time in seconds, written with the millisecond resolution of
+// the log format.
+type seconds float64
+
+// A rowWriter writes rows of values in some output format. A row holds string,
+// int64, float64 and seconds values, in the order of the columns the writer
+// was created with.
+type rowWriter interface {
+	Row(values []any) error
+	Flush() error
+}
+
+// formats maps the supported output format names to their constructors.
+var formats = map[string]func(w io.Writer, columns []string) rowWriter{
+	"csv":   newCSVWriter,
+	"jsonl": newJSONLinesWriter,
+	"table": newTableWriter,
+}
+
+// formatValue formats a value for the text based formats.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case seconds:
+		return strconv.FormatFloat(float64(v), 'f', 3, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	panic("unsupported value type")
+}
+
+// csvWriter writes a header line and comma separated rows.
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) rowWriter {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	// A failed write is kept by the underlying buffer and reported by Flush.
+	c.w.Write(columns)
+	return c
+}
+
+func (c *csvWriter) Row(values []any) error {
+	c.fields = c.fields[:0]
+	for _, v := range values {
+		c.fields = append(c.fields, formatValue(v))
+	}
+	return c.w.Write(c.fields)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonLinesWriter writes every row as a JSON object on a line of its own,
+// keyed by column name.
+type jsonLinesWriter struct {
+	w       *bufio.Writer
+	columns []string
+	buf     []byte
+}
+
+func newJSONLinesWriter(w io.Writer, columns []string) rowWriter {
+	return &jsonLinesWriter{w: bufio.NewWriter(w), columns: columns}
+}
+
+func (j *jsonLinesWriter) Row(values []any) error {
+	b := append(j.buf[:0], '{')
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, j.columns[i])
+		b = append(b, ':')
+		switch v := v.(type) {
+		case string:
+			name, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			b = append(b, name...)
+		case float64:
+			// JSON has no NaN or infinities.
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				b = append(b, "null"...)
+			} else {
+				b = append(b, formatValue(v)...)
+			}
+		default:
+			b = append(b, formatValue(v)...)
+		}
+	}
+	b = append(b, '}', '\n')
+	j.buf = b
+	_, err := j.w.Write(b)
+	return err
+}
+
+func (j *jsonLinesWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// tableWriter writes the header and rows as a text table with aligned
+// columns. Rows are buffered until Flush, as the column widths depend on all
+// of them.
+type tableWriter struct {
+	w *tabwriter.Writer
+}
+
+func newTableWriter(w io.Writer, columns []string) rowWriter {
+	t := &tableWriter{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)}
+	t.write(columns)
+	return t
+}
+
+func (t *tableWriter) write(fields []string) {
+	for _, field := range fields {
+		io.WriteString(t.w, field)
+		io.WriteString(t.w, "\t")
+	}
+	io.WriteString(t.w, "\n")
+}
+
+func (t *tableWriter) Row(values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = formatValue(v)
+	}
+	t.write(fields)
+	return nil
+}
+
+func (t *tableWriter) Flush() error {
+	return t.w.Flush()
+}
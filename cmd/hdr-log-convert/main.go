@@ -0,0 +1,323 @@
+// Command hdr-log-convert converts a histogram log into a table with one row
+// per interval, as CSV, JSON Lines or an aligned text table, for loading into
+// tools such as pandas or DuckDB. Every row holds the interval's start and end
+// time, tag, count, min, max, mean, standard deviation and a configurable list
+// of percentiles:
+//
+//	hdr-log-convert -i service.hlog -o service.csv
+//	hdr-log-convert -i service.hlog -format jsonl -percentiles 50,99,99.9
+//
+// With -expand each interval is instead expanded into one row per recorded
+// bucket of its distribution, or per step of its percentile ladder.
+//
+// With -timeseries the intervals are instead merged per tag into aligned
+// steps, as done by hdrhistogram.TimeSeries, and every row holds a step's
+// count, throughput, max and percentiles, optionally smoothed over the last
+// few steps. Steps without data are written with NaN, or null, values, and a
+// long gap in the log as a single such row spanning it:
+//
+//	hdr-log-convert -i service.hlog -timeseries -step 10s -smooth 6
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// config holds the command line options.
+type config struct {
+	input       string
+	output      string
+	format      string
+	percentiles []float64
+	expand      string
+	ticks       int
+	ratio       float64
+	tags        []string
+	start       float64
+	end         float64
+	relative    bool
+	timeSeries  bool
+	step        time.Duration
+	smoothing   int
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg := config{percentiles: []float64{50, 90, 99, 99.9, 99.99}}
+	fs := flag.NewFlagSet("hdr-log-convert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.input, "i", "", "input log `file`, read from stdin if not given")
+	fs.StringVar(&cfg.output, "o", "", "output `file`, written to stdout if not given")
+	fs.StringVar(&cfg.format, "format", "csv", "output `format`: csv, jsonl or table")
+	fs.Func("percentiles", "comma separated `list` of percentiles to report per interval (default 50,90,99,99.9,99.99)", func(s string) (err error) {
+		cfg.percentiles, err = parsePercentiles(s)
+		return
+	})
+	fs.StringVar(&cfg.expand, "expand", "", "expand each interval into one row per `kind` of step: buckets or percentiles")
+	fs.IntVar(&cfg.ticks, "percentilesOutputTicksPerHalf", 5, "`ticks` per half distance of the percentile ladder with -expand percentiles")
+	fs.Float64Var(&cfg.ratio, "outputValueUnitRatio", 1.0, "`ratio` values are divided by for output")
+	fs.Func("tag", "only convert intervals with these comma separated `tags`; use an empty entry for untagged intervals", func(s string) error {
+		cfg.tags = strings.Split(s, ",")
+		return nil
+	})
+	fs.Float64Var(&cfg.start, "start", 0, "start of the time range to convert, in `seconds` relative to the log StartTime")
+	fs.Float64Var(&cfg.end, "end", math.MaxFloat64, "end of the time range to convert, in `seconds` relative to the log StartTime")
+	fs.BoolVar(&cfg.relative, "relative", false, "report times in seconds relative to the log StartTime rather than since the epoch")
+	fs.BoolVar(&cfg.timeSeries, "timeseries", false, "write one row per tag and aligned step rather than per interval")
+	fs.DurationVar(&cfg.step, "step", 0, "length of the `step`s of -timeseries, the length of the first interval if not given")
+	fs.IntVar(&cfg.smoothing, "smooth", 1, "with -timeseries, accumulate every row over the last `steps` steps")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	if err := cfg.check(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := convert(&cfg, stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "hdr-log-convert: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (cfg *config) check() error {
+	if _, ok := formats[cfg.format]; !ok {
+		return fmt.Errorf("unknown format %q", cfg.format)
+	}
+	switch cfg.expand {
+	case "", "buckets", "percentiles":
+	default:
+		return fmt.Errorf("unknown expansion %q", cfg.expand)
+	}
+	if cfg.timeSeries && cfg.expand != "" {
+		return errors.New("-expand can't be used with -timeseries")
+	}
+	if cfg.step < 0 || cfg.smoothing <= 0 {
+		return errors.New("step must not be negative and smooth must be positive")
+	}
+	if cfg.ratio <= 0 || cfg.ticks <= 0 {
+		return errors.New("outputValueUnitRatio and percentilesOutputTicksPerHalf must be positive")
+	}
+	return nil
+}
+
+// parsePercentiles parses a comma separated list of percentiles.
+func parsePercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid percentile %q", field)
+		}
+		if p < 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %v out of range [0, 100]", p)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// percentileColumn names the column of percentile p, such as p99.9.
+func percentileColumn(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// columns returns the names of the columns written for cfg.
+func (cfg *config) columns() []string {
+	columns := []string{"start", "end", "tag"}
+	if cfg.timeSeries {
+		columns = append(columns, "count", "throughput", "max")
+		for _, p := range cfg.percentiles {
+			columns = append(columns, percentileColumn(p))
+		}
+		return columns
+	}
+	switch cfg.expand {
+	case "buckets":
+		return append(columns, "from", "to", "count")
+	case "percentiles":
+		return append(columns, "percentile", "value", "count")
+	}
+	columns = append(columns, "count", "min", "max", "mean", "stddev")
+	for _, p := range cfg.percentiles {
+		columns = append(columns, percentileColumn(p))
+	}
+	return columns
+}
+
+// convert reads the configured log and writes its rows.
+func convert(cfg *config, stdin io.Reader, stdout io.Writer) (err error) {
+	in := stdin
+	if cfg.input != "" {
+		var file *os.File
+		if file, err = os.Open(cfg.input); err != nil {
+			return
+		}
+		defer file.Close()
+		in = file
+	}
+	out := stdout
+	if cfg.output != "" {
+		var file *os.File
+		if file, err = os.Create(cfg.output); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = file
+	}
+
+	reader := hdrhistogram.NewHistogramLogReader(in)
+	if cfg.tags != nil {
+		reader.SetTagFilter(&hdrhistogram.TagFilter{Include: cfg.tags})
+	}
+	rows := formats[cfg.format](out, cfg.columns())
+	if cfg.timeSeries {
+		if err = writeTimeSeries(rows, cfg, reader); err != nil {
+			return
+		}
+		return rows.Flush()
+	}
+	for {
+		var h *hdrhistogram.Histogram
+		h, err = reader.NextIntervalHistogramWithRange(cfg.start, cfg.end, false)
+		if err != nil {
+			return
+		}
+		if h == nil {
+			break
+		}
+		start, end := float64(h.StartTimeMs())/1000.0, float64(h.EndTimeMs())/1000.0
+		if cfg.relative {
+			start -= reader.StartTimeSec()
+			end -= reader.StartTimeSec()
+		}
+		if err = writeInterval(rows, cfg, []any{seconds(start), seconds(end), h.Tag()}, h); err != nil {
+			return
+		}
+	}
+	return rows.Flush()
+}
+
+// writeInterval writes the rows of interval h, each starting with prefix.
+func writeInterval(rows rowWriter, cfg *config, prefix []any, h *hdrhistogram.Histogram) error {
+	scaled := func(v float64) float64 {
+		return v / cfg.ratio
+	}
+	switch cfg.expand {
+	case "buckets":
+		for _, bar := range h.Distribution() {
+			if bar.Count == 0 {
+				continue
+			}
+			row := append(prefix[:3:3], scaled(float64(bar.From)), scaled(float64(bar.To)), bar.Count)
+			if err := rows.Row(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "percentiles":
+		if h.TotalCount() == 0 {
+			return nil
+		}
+		for _, bracket := range h.CumulativeDistributionWithTicks(int32(cfg.ticks)) {
+			row := append(prefix[:3:3], bracket.Quantile, scaled(float64(bracket.ValueAt)), bracket.Count)
+			if err := rows.Row(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	row := append(prefix[:3:3],
+		h.TotalCount(),
+		scaled(float64(h.Min())),
+		scaled(float64(h.Max())),
+		scaled(h.Mean()),
+		scaled(h.StdDev()))
+	for _, p := range cfg.percentiles {
+		row = append(row, scaled(float64(h.ValueAtPercentile(p))))
+	}
+	return rows.Row(row)
+}
+
+// writeTimeSeries writes the time series of the log read by reader, one row
+// per step and tag, with the untagged series first and the rest in order of
+// tag.
+func writeTimeSeries(rows rowWriter, cfg *config, reader *hdrhistogram.HistogramLogReader) error {
+	end := cfg.end
+	if end == math.MaxFloat64 {
+		end = 0
+	}
+	series, err := hdrhistogram.TimeSeries(reader, cfg.percentiles, &hdrhistogram.TimeSeriesOptions{
+		Step:              cfg.step,
+		Smoothing:         cfg.smoothing,
+		RangeStartTimeSec: cfg.start,
+		RangeEndTimeSec:   end,
+	})
+	if err != nil {
+		return err
+	}
+	tags := make([]string, 0, len(series))
+	for tag := range series {
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	slices.Sort(tags)
+	scaled := func(v int64) float64 {
+		return float64(v) / cfg.ratio
+	}
+	// All the series have the same steps.
+	for i := range series[tags[0]] {
+		for _, tag := range tags {
+			p := series[tag][i]
+			start, end := float64(p.StartTimeMs)/1000.0, float64(p.EndTimeMs)/1000.0
+			if cfg.relative {
+				start -= reader.StartTimeSec()
+				end -= reader.StartTimeSec()
+			}
+			row := []any{seconds(start), seconds(end), tag, p.Count, p.Throughput}
+			if p.Empty() {
+				for range len(cfg.percentiles) + 1 {
+					row = append(row, math.NaN())
+				}
+			} else {
+				row = append(row, scaled(p.Max))
+				for _, v := range p.Values {
+					row = append(row, scaled(v))
+				}
+			}
+			if err := rows.Row(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const taggedLog = "../../test/tagged-Log.logV2.hlog"
+
+func runConvert(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(""), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// loggedIntervals returns the intervals of the log, read with the library.
+func loggedIntervals(t *testing.T, path string) []*hdrhistogram.Histogram {
+	t.Helper()
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	reader := hdrhistogram.NewHistogramLogReader(f)
+	var intervals []*hdrhistogram.Histogram
+	for {
+		h, err := reader.NextIntervalHistogram()
+		assert.Nil(t, err)
+		if h == nil {
+			return intervals
+		}
+		intervals = append(intervals, h)
+	}
+}
+
+func TestRun_csv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "log.csv")
+	_, stderr, code := runConvert(t, "-i", taggedLog, "-o", out, "-percentiles", "50,99.9")
+	assert.Equal(t, 0, code, stderr)
+	f, err := os.Open(out)
+	assert.Nil(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"start", "end", "tag", "count", "min", "max", "mean", "stddev", "p50", "p99.9"}, records[0])
+
+	intervals := loggedIntervals(t, taggedLog)
+	assert.Equal(t, len(intervals), len(records)-1)
+	for i, h := range intervals {
+		record := records[i+1]
+		assert.Equal(t, strconv.FormatFloat(float64(h.StartTimeMs())/1000, 'f', 3, 64), record[0])
+		assert.Equal(t, strconv.FormatFloat(float64(h.EndTimeMs())/1000, 'f', 3, 64), record[1])
+		assert.Equal(t, h.Tag(), record[2])
+		assert.Equal(t, strconv.FormatInt(h.TotalCount(), 10), record[3])
+		assert.Equal(t, strconv.FormatInt(h.Max(), 10), record[5])
+		assert.Equal(t, strconv.FormatInt(h.ValueAtPercentile(99.9), 10), record[9])
+	}
+}
+
+func TestRun_jsonLines(t *testing.T) {
+	stdout, stderr, code := runConvert(t, "-i", taggedLog, "-format", "jsonl", "-tag", "A", "-relative", "-end", "5", "-outputValueUnitRatio", "1000")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	assert.Equal(t, 5, len(lines))
+	for _, line := range lines {
+		var row map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &row), line)
+		assert.Equal(t, "A", row["tag"])
+		assert.Less(t, row["start"].(float64), 6.0)
+		assert.Greater(t, row["p99.99"].(float64), 0.0)
+	}
+	var first map[string]any
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, 0.127, first["start"])
+	assert.Equal(t, 741.0, first["count"])
+	assert.Equal(t, 2768.895, first["max"])
+}
+
+func TestRun_table(t *testing.T) {
+	stdout, stderr, code := runConvert(t, "-i", taggedLog, "-format", "table", "-tag", ",", "-end", "2")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, []string{"start", "end", "tag", "count", "min", "max", "mean", "stddev", "p50", "p90", "p99", "p99.9", "p99.99"}, strings.Fields(lines[0]))
+	// columns are right aligned
+	assert.Equal(t, len(lines[0]), len(lines[1]))
+	assert.Equal(t, len(lines[1]), len(lines[2]))
+}
+
+func TestRun_expand(t *testing.T) {
+	first := loggedIntervals(t, taggedLog)[0]
+
+	stdout, stderr, code := runConvert(t, "-i", taggedLog, "-expand", "buckets", "-tag", ",", "-end", "0.5")
+	assert.Equal(t, 0, code, stderr)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"start", "end", "tag", "from", "to", "count"}, records[0])
+	var total int64
+	for _, record := range records[1:] {
+		count, err := strconv.ParseInt(record[5], 10, 64)
+		assert.Nil(t, err)
+		assert.Greater(t, count, int64(0))
+		total += count
+	}
+	assert.Equal(t, first.TotalCount(), total)
+
+	stdout, stderr, code = runConvert(t, "-i", taggedLog, "-expand", "percentiles", "-percentilesOutputTicksPerHalf", "1", "-tag", ",", "-end", "0.5")
+	assert.Equal(t, 0, code, stderr)
+	records, err = csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"start", "end", "tag", "percentile", "value", "count"}, records[0])
+	ladder := first.CumulativeDistributionWithTicks(1)
+	assert.Equal(t, len(ladder), len(records)-1)
+	last := records[len(records)-1]
+	assert.Equal(t, "100", last[3])
+	assert.Equal(t, strconv.FormatInt(first.Max(), 10), last[4])
+	assert.Equal(t, strconv.FormatInt(first.TotalCount(), 10), last[5])
+}
+
+func TestRun_timeSeries(t *testing.T) {
+	stdout, stderr, code := runConvert(t, "-i", taggedLog, "-timeseries", "-step", "5s", "-smooth", "2", "-percentiles", "50,99", "-relative")
+	assert.Equal(t, 0, code, stderr)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"start", "end", "tag", "count", "throughput", "max", "p50", "p99"}, records[0])
+	// every step has a row per tag, untagged first
+	records = records[1:]
+	assert.Equal(t, 0, len(records)%2)
+	for i, record := range records {
+		assert.Equal(t, []string{"", "A"}[i%2], record[2])
+		assert.Equal(t, records[i-i%2][0], record[0])
+		if record[3] == "0" {
+			assert.Equal(t, []string{"NaN", "NaN", "NaN"}, record[5:])
+		}
+	}
+
+	stdout, stderr, code = runConvert(t, "-i", taggedLog, "-timeseries", "-format", "jsonl", "-tag", "A")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	intervals := loggedIntervals(t, taggedLog)
+	var total int64
+	for _, line := range lines {
+		var row map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &row))
+		assert.Equal(t, "A", row["tag"])
+		total += int64(row["count"].(float64))
+	}
+	var expected int64
+	for _, h := range intervals {
+		if h.Tag() == "A" {
+			expected += h.TotalCount()
+		}
+	}
+	assert.Equal(t, expected, total)
+}
+
+func TestRun_errors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-expand", "everything"},
+		{"-percentiles", "50,x"},
+		{"-percentiles", "101"},
+		{"-outputValueUnitRatio", "-1"},
+		{"-timeseries", "-expand", "buckets"},
+		{"-timeseries", "-smooth", "0"},
+		{"extra"},
+	} {
+		_, _, code := runConvert(t, args...)
+		assert.Equal(t, 2, code, args)
+	}
+	_, stderr, code := runConvert(t, "-i", filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "missing.hlog")
+
+	// an empty log still gets its header
+	stdout, _, code := runConvert(t)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "start,end,tag,count,min,max,mean,stddev,p50,p90,p99,p99.9,p99.99\n", stdout)
+}
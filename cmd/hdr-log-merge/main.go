@@ -0,0 +1,96 @@
+// Command hdr-log-merge merges histogram logs, such as those written by the
+// same load test on several hosts, into one log. Intervals are placed into
+// windows aligned across all the logs and merged per tag, as done by
+// hdrhistogram.MergeHistogramLogs:
+//
+//	hdr-log-merge -window 10s -o merged.hlog host1.hlog host2.hlog.gz
+//
+// The merged log is written to stdout unless -o is given, and is gzip
+// compressed when the output file name ends in .gz.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	var output string
+	var options hdrhistogram.LogMergeOptions
+	fs := flag.NewFlagSet("hdr-log-merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hdr-log-merge [flags] file ...")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&output, "o", "", "output `file`, written to stdout if not given")
+	fs.DurationVar(&options.Window, "window", time.Second, "length of the aligned `window`s intervals are merged into")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if options.Window < time.Millisecond {
+		fmt.Fprintln(stderr, "window must be at least a millisecond")
+		return 2
+	}
+	if err := merge(fs.Args(), output, stdout, &options); err != nil {
+		fmt.Fprintf(stderr, "hdr-log-merge: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// merge merges the logs in files into output, or stdout if output is empty.
+func merge(files []string, output string, stdout io.Writer, options *hdrhistogram.LogMergeOptions) (err error) {
+	logs := make([]*hdrhistogram.HistogramLogReader, 0, len(files))
+	for _, file := range files {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		defer f.Close()
+		logs = append(logs, hdrhistogram.NewHistogramLogReader(f))
+	}
+
+	out := stdout
+	if output != "" {
+		var f *os.File
+		if f, err = os.Create(output); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+	var writer *hdrhistogram.HistogramLogWriter
+	if strings.HasSuffix(output, ".gz") {
+		writer = hdrhistogram.NewGzipHistogramLogWriter(out, -1)
+	} else {
+		writer = hdrhistogram.NewBufferedHistogramLogWriter(out, 0)
+	}
+	if err = hdrhistogram.MergeHistogramLogs(writer, logs, options); err != nil {
+		return
+	}
+	return writer.Close()
+}
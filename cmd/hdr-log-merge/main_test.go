@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const taggedLog = "../../test/tagged-Log.logV2.hlog"
+
+func runMerge(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+func accumulate(t *testing.T, log *hdrhistogram.HistogramLogReader) map[string]*hdrhistogram.Histogram {
+	t.Helper()
+	accumulated, err := log.AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	return accumulated
+}
+
+func TestRun(t *testing.T) {
+	dat, err := os.ReadFile(taggedLog)
+	assert.Nil(t, err)
+	expected := accumulate(t, hdrhistogram.NewHistogramLogReader(bytes.NewReader(dat)))
+
+	for _, name := range []string{"merged.hlog", "merged.hlog.gz"} {
+		out := filepath.Join(t.TempDir(), name)
+		_, stderr, code := runMerge(t, "-window", "5s", "-o", out, taggedLog, taggedLog)
+		assert.Equal(t, 0, code, stderr)
+
+		dat, err := os.ReadFile(out)
+		assert.Nil(t, err)
+		findings, err := hdrhistogram.VerifyHistogramLog(bytes.NewReader(dat), nil)
+		assert.Nil(t, err)
+		assert.Empty(t, findings)
+		merged := accumulate(t, hdrhistogram.NewHistogramLogReader(bytes.NewReader(dat)))
+		for tag, h := range expected {
+			assert.Equal(t, 2*h.TotalCount(), merged[tag].TotalCount(), name)
+		}
+	}
+
+	stdout, stderr, code := runMerge(t, "-window", "1m", taggedLog)
+	assert.Equal(t, 0, code, stderr)
+	intervals, err := hdrhistogram.NewHistogramLogReader(bytes.NewBufferString(stdout)).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[""].TotalCount(), intervals[""].TotalCount())
+}
+
+func TestRun_errors(t *testing.T) {
+	_, _, code := runMerge(t)
+	assert.Equal(t, 2, code)
+	_, _, code = runMerge(t, "-window", "1us", taggedLog)
+	assert.Equal(t, 2, code)
+	_, stderr, code := runMerge(t, filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "missing.hlog")
+}
@@ -0,0 +1,375 @@
+// Command hdr-log-processor is a Go port of the Java HistogramLogProcessor. It
+// reads a histogram log, such as one written by HistogramLogWriter or
+// jHiccup, and reports the percentile distribution of all its intervals
+// accumulated together. Given an output file name it also writes a summary of
+// every interval, with the distribution going to a .hgrm file beside it.
+//
+// The flags and the layout of both outputs follow the Java tool, so their
+// results can be diffed:
+//
+//	hdr-log-processor -i hiccup.hlog -o hiccup
+//	hdr-log-processor -i hiccup.hlog -start 60 -end 120 -csv
+//	hdr-log-processor -i tagged.hlog -listtags
+//	hdr-log-processor -i tagged.hlog -allTags -o tagged
+//
+// With -allTags every tag is reported separately: on stdout as a section per
+// tag, or with -o in files named after the output file and the tag, such as
+// tagged.A and tagged.A.hgrm.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// config holds the command line options.
+type config struct {
+	input            string
+	output           string
+	csv              bool
+	tag              string
+	allTags          bool
+	listTags         bool
+	start            float64
+	end              float64
+	ratio            float64
+	ticks            int
+	expectedInterval float64
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var cfg config
+	fs := flag.NewFlagSet("hdr-log-processor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.input, "i", "", "input log `file`, read from stdin if not given")
+	fs.StringVar(&cfg.output, "o", "", "output `file` for the interval summary; the distribution goes to file.hgrm")
+	fs.BoolVar(&cfg.csv, "csv", false, "use CSV format for output")
+	fs.StringVar(&cfg.tag, "tag", "", "only process intervals with this `tag`, rather than untagged ones")
+	fs.BoolVar(&cfg.allTags, "allTags", false, "process the intervals of every tag, reporting each tag separately")
+	fs.BoolVar(&cfg.listTags, "listtags", false, "list the tags found in the log and exit")
+	fs.Float64Var(&cfg.start, "start", 0, "start of the time range to process, in `seconds` relative to the log StartTime")
+	fs.Float64Var(&cfg.end, "end", math.MaxFloat64, "end of the time range to process, in `seconds` relative to the log StartTime")
+	fs.Float64Var(&cfg.ratio, "outputValueUnitRatio", 1000000.0, "`ratio` values are divided by for output")
+	fs.IntVar(&cfg.ticks, "percentilesOutputTicksPerHalf", 5, "`ticks` per half distance in the percentile distribution")
+	fs.Float64Var(&cfg.expectedInterval, "correctLogWithKnownCoordinatedOmission", 0, "correct intervals for coordinated omission with this expected `interval`, in output units")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	if cfg.ratio <= 0 || cfg.ticks <= 0 {
+		fmt.Fprintln(stderr, "outputValueUnitRatio and percentilesOutputTicksPerHalf must be positive")
+		return 2
+	}
+	if err := process(&cfg, stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "hdr-log-processor: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// process reads the configured log and writes the reports.
+func process(cfg *config, stdin io.Reader, stdout io.Writer) (err error) {
+	in := stdin
+	if cfg.input != "" {
+		var file *os.File
+		if file, err = os.Open(cfg.input); err != nil {
+			return
+		}
+		defer file.Close()
+		in = file
+	}
+	reader := hdrhistogram.NewHistogramLogReader(in)
+	if cfg.listTags {
+		return listTags(reader, stdout)
+	}
+	switch {
+	case cfg.allTags:
+		reader.SetTagFilter(nil)
+	case cfg.tag != "":
+		reader.SetTagFilter(&hdrhistogram.TagFilter{Include: []string{cfg.tag}})
+	default:
+		reader.SetTagFilter(&hdrhistogram.TagFilter{UntaggedOnly: true})
+	}
+
+	// Every tag is reported on its own, as a single one is without -allTags.
+	outputs := make(map[string]*tagOutput)
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			closeFile(file, &err)
+		}
+	}()
+	output := func(tag string) (o *tagOutput, err error) {
+		if o = outputs[tag]; o == nil {
+			o, err = newTagOutput(cfg, tag, stdout, &files)
+			outputs[tag] = o
+		}
+		return
+	}
+
+	for {
+		var interval *hdrhistogram.Histogram
+		interval, err = reader.NextIntervalHistogramWithRange(cfg.start, cfg.end, false)
+		if err != nil {
+			return
+		}
+		if interval == nil {
+			break
+		}
+		if cfg.expectedInterval > 0 {
+			if interval, err = correctedCopy(interval, int64(cfg.expectedInterval*cfg.ratio)); err != nil {
+				return
+			}
+		}
+		var o *tagOutput
+		if o, err = output(interval.Tag()); err != nil {
+			return
+		}
+		o.accumulated = hdrhistogram.AccumulateInterval(o.accumulated, interval)
+
+		if !o.started && reader.StartTimeSec() != 0.0 {
+			o.started = true
+			outputStartTime(o.distributionLog, reader.StartTimeSec())
+			if o.intervalLog != nil {
+				outputStartTime(o.intervalLog, reader.StartTimeSec())
+			}
+		}
+		if o.intervalLog != nil {
+			if !o.legendWritten {
+				o.legendWritten = true
+				outputIntervalLegend(o.intervalLog, cfg.csv)
+			}
+			outputInterval(o.intervalLog, cfg, float64(interval.EndTimeMs())/1000.0-reader.StartTimeSec(), interval, o.accumulated)
+		}
+	}
+	if len(outputs) == 0 {
+		// As the Java tool does, report an empty distribution.
+		tag := ""
+		if !cfg.allTags {
+			tag = cfg.tag
+		}
+		if _, err = output(tag); err != nil {
+			return
+		}
+	}
+
+	tags := make([]string, 0, len(outputs))
+	for tag := range outputs {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if err = outputs[tag].finish(cfg, tag, stdout); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// tagOutput holds the accumulated distribution and the outputs of one tag.
+type tagOutput struct {
+	accumulated   *hdrhistogram.Histogram
+	intervalLog   *bufio.Writer
+	started       bool
+	legendWritten bool
+	// distributionLog writes to the .hgrm file of the tag, to section when
+	// the tags of -allTags share stdout, or else to stdout.
+	distributionLog *bufio.Writer
+	section         *bytes.Buffer
+}
+
+// newTagOutput returns the outputs of tag, creating its files with -o. With
+// -allTags, the files of a tag are named after the -o file with the tag
+// appended, as file.tag and file.tag.hgrm, while untagged intervals keep the
+// plain names.
+func newTagOutput(cfg *config, tag string, stdout io.Writer, files *[]*os.File) (*tagOutput, error) {
+	o := &tagOutput{}
+	if cfg.output == "" {
+		if cfg.allTags {
+			o.section = &bytes.Buffer{}
+			o.distributionLog = bufio.NewWriter(o.section)
+		} else {
+			o.distributionLog = bufio.NewWriter(stdout)
+		}
+		return o, nil
+	}
+	name := cfg.output
+	if cfg.allTags && tag != "" {
+		name += "." + tag
+	}
+	intervalFile, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	*files = append(*files, intervalFile)
+	distributionFile, err := os.Create(name + ".hgrm")
+	if err != nil {
+		return nil, err
+	}
+	*files = append(*files, distributionFile)
+	o.intervalLog = bufio.NewWriter(intervalFile)
+	o.distributionLog = bufio.NewWriter(distributionFile)
+	outputTimeRange(o.intervalLog, cfg, "Interval percentiles")
+	outputTimeRange(o.distributionLog, cfg, "Overall percentile distribution")
+	return o, nil
+}
+
+// finish writes the accumulated distribution of tag and flushes its outputs.
+// The sections of -allTags on stdout are headed by their tag.
+func (o *tagOutput) finish(cfg *config, tag string, stdout io.Writer) error {
+	accumulated := o.accumulated
+	if accumulated == nil {
+		accumulated = hdrhistogram.New(1, 2, 3)
+	}
+	if err := accumulated.OutputPercentileDistribution(o.distributionLog, int32(cfg.ticks), cfg.ratio, cfg.csv); err != nil {
+		return err
+	}
+	if o.intervalLog != nil {
+		if err := o.intervalLog.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := o.distributionLog.Flush(); err != nil {
+		return err
+	}
+	if o.section == nil {
+		return nil
+	}
+	header := "#[Tag: " + tag + "]\n"
+	if tag == "" {
+		header = "#[NO TAG (default)]\n"
+	}
+	if _, err := io.WriteString(stdout, header); err != nil {
+		return err
+	}
+	_, err := o.section.WriteTo(stdout)
+	return err
+}
+
+// closeFile closes file, reporting its error through err unless one is set.
+func closeFile(file *os.File, err *error) {
+	if cerr := file.Close(); *err == nil {
+		*err = cerr
+	}
+}
+
+// listTags prints the tags found in the log, in the Java tool's format.
+func listTags(reader *hdrhistogram.HistogramLogReader, stdout io.Writer) error {
+	tags, err := reader.ListTags()
+	if err != nil {
+		return err
+	}
+	sort.Strings(tags)
+	w := bufio.NewWriter(stdout)
+	fmt.Fprintln(w, "Tags found in input file:")
+	for _, tag := range tags {
+		if tag == "" {
+			fmt.Fprintln(w, "[NO TAG (default)]")
+		}
+	}
+	for _, tag := range tags {
+		if tag != "" {
+			fmt.Fprintln(w, tag)
+		}
+	}
+	return w.Flush()
+}
+
+// correctedCopy returns a copy of h corrected for coordinated omission, as the
+// Java copyCorrectedForCoordinatedOmission does: every recorded value larger
+// than expectedInterval is backfilled with the values that would have been
+// recorded while it stalled.
+func correctedCopy(h *hdrhistogram.Histogram, expectedInterval int64) (*hdrhistogram.Histogram, error) {
+	corrected := hdrhistogram.New(h.LowestTrackableValue(), h.HighestTrackableValue(), int(h.SignificantFigures()))
+	corrected.SetStartTimeMs(h.StartTimeMs())
+	corrected.SetEndTimeMs(h.EndTimeMs())
+	corrected.SetTag(h.Tag())
+	for _, bar := range h.Distribution() {
+		if bar.Count == 0 {
+			continue
+		}
+		if err := corrected.RecordValues(bar.To, bar.Count); err != nil {
+			return nil, err
+		}
+		if expectedInterval <= 0 {
+			continue
+		}
+		for missing := bar.To - expectedInterval; missing >= expectedInterval; missing -= expectedInterval {
+			if err := corrected.RecordValues(missing, bar.Count); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return corrected, nil
+}
+
+func outputTimeRange(w io.Writer, cfg *config, title string) {
+	fmt.Fprintf(w, "#[%s between %.3f and", title, cfg.start)
+	if cfg.end < math.MaxFloat64 {
+		fmt.Fprintf(w, " %.3f", cfg.end)
+	} else {
+		fmt.Fprint(w, " <Infinite>")
+	}
+	fmt.Fprint(w, " seconds (relative to StartTime)]\n")
+}
+
+// outputStartTime writes the StartTime line, with the date formatted as Java's
+// Date.toString does.
+func outputStartTime(w io.Writer, startTimeSec float64) {
+	date := time.UnixMilli(int64(startTimeSec * 1000)).Format("Mon Jan 02 15:04:05 MST 2006")
+	fmt.Fprintf(w, "#[StartTime: %.3f (seconds since epoch), %s]\n", startTimeSec, date)
+}
+
+func outputIntervalLegend(w io.Writer, csv bool) {
+	if csv {
+		fmt.Fprintln(w, `"Timestamp","Int_Count","Int_50%","Int_90%","Int_Max","Total_Count","Total_50%","Total_90%","Total_99%","Total_99.9%","Total_99.99%","Total_Max"`)
+	} else {
+		fmt.Fprintln(w, "Time: IntervalPercentiles:count ( 50% 90% Max ) TotalPercentiles:count ( 50% 90% 99% 99.9% 99.99% Max )")
+	}
+}
+
+// outputInterval writes the summary line of an interval ending at timestamp,
+// in seconds since the log start time, and of the accumulation up to it.
+func outputInterval(w io.Writer, cfg *config, timestamp float64, interval, accumulated *hdrhistogram.Histogram) {
+	format := "%4.3f: I:%d ( %7.3f %7.3f %7.3f ) T:%d ( %7.3f %7.3f %7.3f %7.3f %7.3f %7.3f )\n"
+	if cfg.csv {
+		format = "%.3f,%d,%.3f,%.3f,%.3f,%d,%.3f,%.3f,%.3f,%.3f,%.3f,%.3f\n"
+	}
+	scaled := func(v int64) float64 {
+		return float64(v) / cfg.ratio
+	}
+	fmt.Fprintf(w, format, timestamp,
+		interval.TotalCount(),
+		scaled(interval.ValueAtPercentile(50.0)),
+		scaled(interval.ValueAtPercentile(90.0)),
+		scaled(interval.Max()),
+		accumulated.TotalCount(),
+		scaled(accumulated.ValueAtPercentile(50.0)),
+		scaled(accumulated.ValueAtPercentile(90.0)),
+		scaled(accumulated.ValueAtPercentile(99.0)),
+		scaled(accumulated.ValueAtPercentile(99.9)),
+		scaled(accumulated.ValueAtPercentile(99.99)),
+		scaled(accumulated.Max()))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	hiccupLog = "../../test/jHiccup-2.0.7S.logV2.hlog"
+	taggedLog = "../../test/tagged-Log.logV2.hlog"
+)
+
+func runProcessor(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(""), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// accumulatedLog returns the distribution the command should report for tag,
+// as accumulated by the library.
+func accumulatedLog(t *testing.T, path, tag string) *hdrhistogram.Histogram {
+	t.Helper()
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	accumulated, err := hdrhistogram.NewHistogramLogReader(f).AccumulateByTag(0, math.MaxFloat64, true)
+	assert.Nil(t, err)
+	return accumulated[tag]
+}
+
+func TestRun_distribution(t *testing.T) {
+	stdout, stderr, code := runProcessor(t, "-i", hiccupLog)
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(stdout, "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "#[StartTime: 1441812279.474 (seconds since epoch), "))
+	assert.Equal(t, "       Value     Percentile TotalCount 1/(1-Percentile)", lines[1])
+
+	var expected bytes.Buffer
+	acc := accumulatedLog(t, hiccupLog, "")
+	assert.Nil(t, acc.OutputPercentileDistribution(&expected, 5, 1000000.0, false))
+	assert.Equal(t, expected.String(), strings.Join(lines[1:], "\n"))
+}
+
+func TestRun_intervalSummary(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hiccup")
+	_, stderr, code := runProcessor(t, "-i", hiccupLog, "-o", out, "-start", "10", "-end", "20")
+	assert.Equal(t, 0, code, stderr)
+
+	dat, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(dat), "\n"), "\n")
+	assert.Equal(t, "#[Interval percentiles between 10.000 and 20.000 seconds (relative to StartTime)]", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "#[StartTime: 1441812279.474 "))
+	assert.Equal(t, "Time: IntervalPercentiles:count ( 50% 90% Max ) TotalPercentiles:count ( 50% 90% 99% 99.9% 99.99% Max )", lines[2])
+	// the intervals starting from 10 to 20 seconds in, each about a second long
+	assert.Equal(t, 10, len(lines)-3)
+	assert.True(t, strings.HasPrefix(lines[3], "11.132: I:192 (   0.328   0.393   0.426 ) T:192 "))
+
+	dat, err = os.ReadFile(out + ".hgrm")
+	assert.Nil(t, err)
+	hgrm := string(dat)
+	assert.True(t, strings.HasPrefix(hgrm, "#[Overall percentile distribution between 10.000 and 20.000 seconds (relative to StartTime)]\n#[StartTime: "))
+	assert.Contains(t, hgrm, "#[Buckets = ")
+}
+
+func TestRun_csv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hiccup")
+	_, stderr, code := runProcessor(t, "-i", hiccupLog, "-o", out, "-csv", "-end", "3", "-outputValueUnitRatio", "1000")
+	assert.Equal(t, 0, code, stderr)
+
+	dat, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(dat), "\n"), "\n")
+	assert.Equal(t, "#[Interval percentiles between 0.000 and 3.000 seconds (relative to StartTime)]", lines[0])
+	assert.Equal(t, `"Timestamp","Int_Count","Int_50%","Int_90%","Int_Max","Total_Count","Total_50%","Total_90%","Total_99%","Total_99.9%","Total_99.99%","Total_Max"`, lines[2])
+	for _, line := range lines[3:] {
+		assert.Equal(t, 12, len(strings.Split(line, ",")), line)
+	}
+
+	dat, err = os.ReadFile(out + ".hgrm")
+	assert.Nil(t, err)
+	lines = strings.Split(strings.TrimSuffix(string(dat), "\n"), "\n")
+	assert.Equal(t, `"Value","Percentile","TotalCount","1/(1-Percentile)"`, lines[2])
+	last := lines[len(lines)-1]
+	assert.Contains(t, last, ",1.000000000000,")
+	assert.True(t, strings.HasSuffix(last, ",Infinity"))
+}
+
+func TestRun_tags(t *testing.T) {
+	stdout, stderr, code := runProcessor(t, "-i", taggedLog, "-listtags")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "Tags found in input file:\n[NO TAG (default)]\nA\n", stdout)
+
+	// the tagged log duplicates its untagged intervals with Tag=A
+	untagged, _, code := runProcessor(t, "-i", taggedLog)
+	assert.Equal(t, 0, code)
+	tagged, _, code := runProcessor(t, "-i", taggedLog, "-tag", "A")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, untagged, tagged)
+
+	// -allTags reports every tag separately, as -tag does one
+	all, _, code := runProcessor(t, "-i", taggedLog, "-allTags")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "#[NO TAG (default)]\n"+untagged+"#[Tag: A]\n"+tagged, all)
+	acc := accumulatedLog(t, taggedLog, "A")
+	assert.Equal(t, 2, strings.Count(all, fmt.Sprintf("Total count    = %12d]", acc.TotalCount())))
+
+	dir := t.TempDir()
+	_, stderr, code = runProcessor(t, "-i", taggedLog, "-allTags", "-o", filepath.Join(dir, "all"))
+	assert.Equal(t, 0, code, stderr)
+	_, stderr, code = runProcessor(t, "-i", taggedLog, "-tag", "A", "-o", filepath.Join(dir, "a"))
+	assert.Equal(t, 0, code, stderr)
+	for _, suffix := range []string{"", ".hgrm"} {
+		want, err := os.ReadFile(filepath.Join(dir, "a"+suffix))
+		assert.Nil(t, err)
+		got, err := os.ReadFile(filepath.Join(dir, "all.A"+suffix))
+		assert.Nil(t, err)
+		assert.Equal(t, string(want), string(got))
+		_, err = os.Stat(filepath.Join(dir, "all"+suffix))
+		assert.Nil(t, err)
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	_, stderr, code := runProcessor(t, "-i", filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "missing.hlog")
+
+	_, _, code = runProcessor(t, "-nosuchflag")
+	assert.Equal(t, 2, code)
+	_, _, code = runProcessor(t, "extra")
+	assert.Equal(t, 2, code)
+	_, _, code = runProcessor(t, "-outputValueUnitRatio", "0")
+	assert.Equal(t, 2, code)
+
+	// an empty log reports an empty distribution, as the Java tool does
+	stdout, _, code := runProcessor(t)
+	assert.Equal(t, 0, code)
+	assert.True(t, strings.HasPrefix(stdout, "       Value     Percentile TotalCount 1/(1-Percentile)\n\n#[Mean    = "))
+}
+
+func TestCorrectedCopy(t *testing.T) {
+	h := hdrhistogram.New(1, 10000, 3)
+	assert.Nil(t, h.RecordValue(10))
+	assert.Nil(t, h.RecordValue(1000))
+	corrected, err := correctedCopy(h, 100)
+	assert.Nil(t, err)
+	// 1000 backfills 900, 800, ..., 100
+	assert.Equal(t, int64(11), corrected.TotalCount())
+	assert.Equal(t, int64(100), corrected.ValueAtPercentile(20))
+	assert.Equal(t, h.Max(), corrected.Max())
+
+	stdout, _, code := runProcessor(t, "-i", hiccupLog, "-correctLogWithKnownCoordinatedOmission", "1")
+	assert.Equal(t, 0, code)
+	plain, _, _ := runProcessor(t, "-i", hiccupLog)
+	assert.NotEqual(t, plain, stdout)
+}
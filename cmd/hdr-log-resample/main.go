@@ -0,0 +1,108 @@
+// Command hdr-log-resample rewrites a histogram log at a coarser resolution,
+// merging its intervals per tag into aligned windows, as done by
+// hdrhistogram.ResampleHistogramLog. It keeps month long logs small while
+// keeping their accumulated distributions exact:
+//
+//	hdr-log-resample -window 1m -i service.hlog -o service-1m.hlog.gz
+//	hdr-log-resample -window 10s -significantDigits 2 < service.hlog > small.hlog
+//
+// The log is read from stdin and written to stdout unless -i and -o are
+// given, and is gzip compressed when the output file name ends in .gz. The
+// interval max column is written in milliseconds of nanosecond values, unless
+// -valueUnit and -maxValueUnit give the units the log was written with.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var input, output string
+	logOptions := hdrhistogram.DefaultHistogramLogOptions()
+	options := hdrhistogram.LogMergeOptions{LogOptions: logOptions}
+	fs := flag.NewFlagSet("hdr-log-resample", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&input, "i", "", "input log `file`, read from stdin if not given")
+	fs.StringVar(&output, "o", "", "output `file`, written to stdout if not given")
+	fs.DurationVar(&options.Window, "window", time.Minute, "length of the aligned `window`s intervals are merged into")
+	fs.IntVar(&options.SignificantFigures, "significantDigits", 0, "lower the precision of the histograms to this many significant `digits`")
+	fs.DurationVar(&logOptions.ValueUnit, "valueUnit", logOptions.ValueUnit, "`unit` of the values recorded in the log")
+	fs.DurationVar(&logOptions.MaxValueUnit, "maxValueUnit", logOptions.MaxValueUnit, "`unit` of the interval max column")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	if options.Window < time.Millisecond {
+		fmt.Fprintln(stderr, "window must be at least a millisecond")
+		return 2
+	}
+	if options.SignificantFigures < 0 || options.SignificantFigures > 5 {
+		fmt.Fprintln(stderr, "significantDigits must be between 0 and 5")
+		return 2
+	}
+	if logOptions.ValueUnit <= 0 || logOptions.MaxValueUnit <= 0 {
+		fmt.Fprintln(stderr, "valueUnit and maxValueUnit must be positive")
+		return 2
+	}
+	if err := resample(input, output, stdin, stdout, &options); err != nil {
+		fmt.Fprintf(stderr, "hdr-log-resample: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// resample resamples input, or stdin, into output, or stdout.
+func resample(input, output string, stdin io.Reader, stdout io.Writer, options *hdrhistogram.LogMergeOptions) (err error) {
+	in := stdin
+	if input != "" {
+		var f *os.File
+		if f, err = os.Open(input); err != nil {
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+	out := stdout
+	if output != "" {
+		var f *os.File
+		if f, err = os.Create(output); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+	var writer *hdrhistogram.HistogramLogWriter
+	if strings.HasSuffix(output, ".gz") {
+		writer = hdrhistogram.NewGzipHistogramLogWriter(out, -1)
+	} else {
+		writer = hdrhistogram.NewBufferedHistogramLogWriter(out, 0)
+	}
+	if err = hdrhistogram.ResampleHistogramLog(writer, hdrhistogram.NewHistogramLogReader(in), options); err != nil {
+		return
+	}
+	return writer.Close()
+}
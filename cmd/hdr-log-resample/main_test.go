@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const hiccupLog = "../../test/jHiccup-2.0.7S.logV2.hlog"
+
+func runResample(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+func readIntervals(t *testing.T, log []byte) []*hdrhistogram.Histogram {
+	t.Helper()
+	reader := hdrhistogram.NewHistogramLogReader(bytes.NewReader(log))
+	var intervals []*hdrhistogram.Histogram
+	for {
+		h, err := reader.NextIntervalHistogram()
+		assert.Nil(t, err)
+		if h == nil {
+			return intervals
+		}
+		intervals = append(intervals, h)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dat, err := os.ReadFile(hiccupLog)
+	assert.Nil(t, err)
+	var total int64
+	for _, h := range readIntervals(t, dat) {
+		total += h.TotalCount()
+	}
+
+	out := filepath.Join(t.TempDir(), "resampled.hlog.gz")
+	_, stderr, code := runResample(t, "", "-window", "30s", "-significantDigits", "1", "-i", hiccupLog, "-o", out)
+	assert.Equal(t, 0, code, stderr)
+	resampled, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	intervals := readIntervals(t, resampled)
+	var resampledTotal int64
+	for _, h := range intervals {
+		assert.Equal(t, int64(30000), h.EndTimeMs()-h.StartTimeMs())
+		assert.Equal(t, int64(1), h.SignificantFigures())
+		resampledTotal += h.TotalCount()
+	}
+	assert.Equal(t, total, resampledTotal)
+
+	// stdin to stdout, at the default one minute resolution
+	stdout, stderr, code := runResample(t, string(dat))
+	assert.Equal(t, 0, code, stderr)
+	for _, h := range readIntervals(t, []byte(stdout)) {
+		assert.Equal(t, int64(60000), h.EndTimeMs()-h.StartTimeMs())
+	}
+}
+
+func TestRun_valueUnits(t *testing.T) {
+	// values recorded in microseconds, with the max column in milliseconds
+	var log bytes.Buffer
+	writer := hdrhistogram.NewHistogramLogWriter(&log)
+	assert.Nil(t, writer.OutputLogHeader(1000000, 1000000))
+	options := &hdrhistogram.HistogramLogOptions{ValueUnit: time.Microsecond, MaxValueUnit: time.Millisecond}
+	for i := int64(0); i < 3; i++ {
+		h := hdrhistogram.New(1, 10000000, 3)
+		assert.Nil(t, h.RecordValue(2000*(i+1)))
+		h.SetStartTimeMs(1000000 + i*1000)
+		h.SetEndTimeMs(1000000 + (i+1)*1000)
+		assert.Nil(t, writer.OutputIntervalHistogramWithLogOptions(h, options))
+	}
+
+	stdout, stderr, code := runResample(t, log.String(), "-valueUnit", "1us")
+	assert.Equal(t, 0, code, stderr)
+	var maxes []float64
+	for entry, err := range hdrhistogram.NewHistogramLogReader(strings.NewReader(stdout)).Entries() {
+		assert.Nil(t, err)
+		maxes = append(maxes, entry.IntervalMax)
+	}
+	assert.Equal(t, 1, len(maxes))
+	assert.InDelta(t, 6.0, maxes[0], 0.01)
+}
+
+func TestRun_errors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-window", "0s"},
+		{"-significantDigits", "6"},
+		{"-significantDigits", "-1"},
+		{"-valueUnit", "0s"},
+		{"-maxValueUnit", "-1ms"},
+		{"extra"},
+	} {
+		_, _, code := runResample(t, "", args...)
+		assert.Equal(t, 2, code, args)
+	}
+	_, stderr, code := runResample(t, "", "-i", filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "missing.hlog")
+}
@@ -0,0 +1,110 @@
+// Command hdr-log-verify checks histogram logs for problems before they are
+// archived: a missing header, lines that cannot be parsed, payloads that do
+// not decode, timestamps going backwards per tag, interval max columns that
+// disagree with their histograms, changing histogram geometries and malformed
+// tags. See hdrhistogram.VerifyHistogramLog for the checks, which can also be
+// run from tests.
+//
+//	hdr-log-verify service.hlog other.hlog.gz
+//	hdr-log-verify -format json < service.hlog
+//
+// Findings are printed one per line, as text or as JSON objects. The exit code
+// is 1 if any log has an error finding, or a warning with -werror, and 2 if a
+// log cannot be read or the command line is invalid.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// fileFinding is a finding as written with -format json.
+type fileFinding struct {
+	File string `json:"file"`
+	hdrhistogram.LogFinding
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var format string
+	var werror bool
+	var options hdrhistogram.LogVerifyOptions
+	fs := flag.NewFlagSet("hdr-log-verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hdr-log-verify [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&format, "format", "text", "output `format`: text or json")
+	fs.BoolVar(&werror, "werror", false, "fail on warnings as well as errors")
+	fs.DurationVar(&options.ValueUnit, "valueUnit", time.Nanosecond, "`unit` of the values recorded in the logs")
+	fs.DurationVar(&options.MaxValueUnit, "maxValueUnit", time.Millisecond, "`unit` the interval max column was written in")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q\n", format)
+		return 2
+	}
+	if options.ValueUnit <= 0 || options.MaxValueUnit <= 0 {
+		fmt.Fprintln(stderr, "valueUnit and maxValueUnit must be positive")
+		return 2
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	code := 0
+	enc := json.NewEncoder(stdout)
+	for _, file := range files {
+		findings, err := verify(file, stdin, &options)
+		if err != nil {
+			fmt.Fprintf(stderr, "hdr-log-verify: %v\n", err)
+			code = 2
+			continue
+		}
+		for _, finding := range findings {
+			if format == "json" {
+				err = enc.Encode(fileFinding{File: file, LogFinding: finding})
+			} else {
+				_, err = fmt.Fprintf(stdout, "%s: %v\n", file, finding)
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "hdr-log-verify: %v\n", err)
+				return 2
+			}
+			if code == 0 && (finding.Severity == hdrhistogram.LogFindingError || werror) {
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// verify verifies the log in file, or stdin if file is "-".
+func verify(file string, stdin io.Reader, options *hdrhistogram.LogVerifyOptions) ([]hdrhistogram.LogFinding, error) {
+	if file == "-" {
+		return hdrhistogram.VerifyHistogramLog(stdin, options)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hdrhistogram.VerifyHistogramLog(f, options)
+}
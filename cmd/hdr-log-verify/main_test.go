@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	hiccupLog = "../../test/jHiccup-2.0.7S.logV2.hlog"
+	taggedLog = "../../test/tagged-Log.logV2.hlog"
+)
+
+func runVerify(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// brokenLog returns a copy of the tagged log with a garbled line and without
+// its legend.
+func brokenLog(t *testing.T) string {
+	dat, err := os.ReadFile(taggedLog)
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(dat), "\n")
+	lines[3] = "not a log line\n"
+	return strings.Join(lines, "")
+}
+
+func TestRun_clean(t *testing.T) {
+	stdout, stderr, code := runVerify(t, "", hiccupLog, taggedLog)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "", stdout)
+}
+
+func TestRun_text(t *testing.T) {
+	stdout, _, code := runVerify(t, brokenLog(t))
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "-: line 4: error: parse: unrecognised line\n-: warning: header: missing legend line\n", stdout)
+
+	// a log with only warnings passes, unless they are made errors
+	path := filepath.Join(t.TempDir(), "nolegend.hlog")
+	dat, err := os.ReadFile(taggedLog)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, bytes.Replace(dat, []byte(`"StartTimestamp"`), []byte(`#"StartTimestamp"`), 1), 0o644))
+	stdout, _, code = runVerify(t, "", path)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, path+": warning: header: missing legend line\n", stdout)
+	_, _, code = runVerify(t, "", "-werror", path)
+	assert.Equal(t, 1, code)
+}
+
+func TestRun_json(t *testing.T) {
+	stdout, _, code := runVerify(t, brokenLog(t), "-format", "json")
+	assert.Equal(t, 1, code)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+	var finding map[string]any
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &finding))
+	assert.Equal(t, map[string]any{
+		"file":     "-",
+		"line":     4.0,
+		"offset":   finding["offset"],
+		"check":    "parse",
+		"severity": "error",
+		"message":  "unrecognised line",
+	}, finding)
+	assert.Greater(t, finding["offset"].(float64), 0.0)
+}
+
+func TestRun_errors(t *testing.T) {
+	_, stderr, code := runVerify(t, "", filepath.Join(t.TempDir(), "missing.hlog"), hiccupLog)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "missing.hlog")
+
+	_, _, code = runVerify(t, "", "-format", "xml")
+	assert.Equal(t, 2, code)
+	_, _, code = runVerify(t, "", "-maxValueUnit", "0s")
+	assert.Equal(t, 2, code)
+}
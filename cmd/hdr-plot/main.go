@@ -0,0 +1,352 @@
+// Command hdr-plot plots the percentile distributions of histogram logs as a
+// standalone SVG image or HTML page, with the percentile on the usual inverted
+// log x axis, as done by hdrhistogram.WritePercentilePlotSVG. Every tag of
+// every log is accumulated and drawn as a curve of its own, and service level
+// objectives can be drawn over them:
+//
+//	hdr-plot -o latency.svg before.hlog after.hlog
+//	hdr-plot -o latency.html -tag checkout -slo 'SLO=90:5,99:20,99.9:50' service.hlog
+//
+// With -heatmap the intervals of all the logs are instead drawn as a latency
+// heatmap, as done by hdrhistogram.Heatmap, with time along the x axis, values
+// in log spaced rows along the y axis and colour showing their counts. Next to
+// SVG and HTML a heatmap can be written as a PNG image, or as text for a quick
+// look in a terminal, in colour unless -color never is given or NO_COLOR set:
+//
+//	hdr-plot -heatmap -o latency.png service.hlog
+//	hdr-plot -heatmap -format ansi -tag checkout service.hlog
+//
+// With -format ansi the percentile distributions are instead written as text
+// for a quick look over SSH: a sparkline and a bar chart of every curve, or a
+// side by side comparison when there are exactly two, in colour when written
+// to a terminal:
+//
+//	hdr-plot -format ansi before.hlog after.hlog
+//
+// The image is written to stdout unless -o is given, and as an HTML page or
+// PNG image when the output file name ends in .html or .png, or with -format.
+// A file named "-" is read from stdin.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// config holds the command line options.
+type config struct {
+	output          string
+	format          string
+	tags            []string
+	start           float64
+	end             float64
+	heatmap         bool
+	rowsPerDoubling int
+	color           hdrhistogram.TerminalColor
+	ascii           bool
+	options         hdrhistogram.PercentilePlotOptions
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var cfg config
+	var ticks int
+	fs := flag.NewFlagSet("hdr-plot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hdr-plot [flags] file ...")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.output, "o", "", "output `file`, written to stdout if not given")
+	fs.StringVar(&cfg.format, "format", "", "output `format`: svg, html, ansi, or with -heatmap png; by default that of the output file name's extension, or svg")
+	fs.Func("tag", "only plot intervals with these comma separated `tags`; use an empty entry for untagged intervals", func(s string) error {
+		cfg.tags = strings.Split(s, ",")
+		return nil
+	})
+	fs.Float64Var(&cfg.start, "start", 0, "start of the time range to plot, in `seconds` relative to the log StartTime")
+	fs.Float64Var(&cfg.end, "end", math.MaxFloat64, "end of the time range to plot, in `seconds` relative to the log StartTime")
+	fs.StringVar(&cfg.options.Title, "title", "", "`title` drawn above the plot")
+	fs.IntVar(&cfg.options.Width, "width", 0, "`width` of the plot in pixels, or characters with -format ansi (default 800, or 80)")
+	fs.IntVar(&cfg.options.Height, "height", 0, "`height` of the plot in pixels, or characters with -format ansi (default 500 and 400 for heatmaps, or 24)")
+	fs.Float64Var(&cfg.options.ValueUnitRatio, "outputValueUnitRatio", 1000000.0, "`ratio` values are divided by for output")
+	fs.StringVar(&cfg.options.ValueUnit, "unit", "ms", "`unit` of the values once divided by outputValueUnitRatio")
+	fs.IntVar(&ticks, "percentilesOutputTicksPerHalf", 5, "`ticks` per half distance of the curves")
+	fs.Func("slo", "draw a service level objective, given as `[label=]percentile:value,...`; may be repeated", func(s string) error {
+		slo, err := parseSLO(s)
+		if err == nil {
+			cfg.options.SLOs = append(cfg.options.SLOs, slo)
+		}
+		return err
+	})
+	fs.BoolVar(&cfg.heatmap, "heatmap", false, "draw a latency heatmap of the intervals rather than their percentile distributions")
+	fs.IntVar(&cfg.rowsPerDoubling, "rowsPerDoubling", 0, "`rows` per doubling of value of the heatmap, or with -format ansi of the bar charts (default 4, or 1)")
+	fs.Func("color", "use colour with -format ansi: `when` auto, always or never (default auto)", func(s string) error {
+		var ok bool
+		cfg.color, ok = map[string]hdrhistogram.TerminalColor{
+			"auto":   hdrhistogram.ColorAuto,
+			"always": hdrhistogram.ColorAlways,
+			"never":  hdrhistogram.ColorNever,
+		}[s]
+		if !ok {
+			return errors.New("want auto, always or never")
+		}
+		return nil
+	})
+	fs.BoolVar(&cfg.ascii, "ascii", false, "draw -format ansi text with ASCII characters only")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if cfg.format == "" {
+		switch filepath.Ext(cfg.output) {
+		case ".html", ".htm":
+			cfg.format = "html"
+		case ".png":
+			cfg.format = "png"
+		default:
+			cfg.format = "svg"
+		}
+	}
+	switch cfg.format {
+	case "svg", "html", "ansi":
+	case "png":
+		if !cfg.heatmap {
+			fmt.Fprintf(stderr, "format %q needs -heatmap\n", cfg.format)
+			return 2
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", cfg.format)
+		return 2
+	}
+	if cfg.options.Width < 0 || cfg.options.Height < 0 || cfg.options.ValueUnitRatio <= 0 || ticks <= 0 || cfg.rowsPerDoubling < 0 {
+		fmt.Fprintln(stderr, "width, height, outputValueUnitRatio, percentilesOutputTicksPerHalf and rowsPerDoubling must be positive")
+		return 2
+	}
+	cfg.options.TicksPerHalfDistance = int32(ticks)
+	if err := plot(&cfg, fs.Args(), stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "hdr-plot: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseSLO parses a service level objective given as
+// [label=]percentile:value,...
+func parseSLO(s string) (slo hdrhistogram.PlotSLO, err error) {
+	slo.Label = "SLO"
+	if label, points, ok := strings.Cut(s, "="); ok {
+		slo.Label, s = label, points
+	}
+	for _, field := range strings.Split(s, ",") {
+		percentile, value, ok := strings.Cut(strings.TrimSpace(field), ":")
+		var point hdrhistogram.PlotSLOPoint
+		if ok {
+			point.Percentile, err = strconv.ParseFloat(percentile, 64)
+		}
+		if ok && err == nil {
+			point.Value, err = strconv.ParseFloat(value, 64)
+		}
+		if !ok || err != nil {
+			return slo, fmt.Errorf("invalid SLO target %q, want percentile:value", field)
+		}
+		if point.Percentile < 0 || point.Percentile >= 100 {
+			return slo, fmt.Errorf("SLO percentile %v out of range [0, 100)", point.Percentile)
+		}
+		slo.Points = append(slo.Points, point)
+	}
+	return slo, nil
+}
+
+// plot reads the logs in files and writes their plot.
+func plot(cfg *config, files []string, stdin io.Reader, stdout io.Writer) (err error) {
+	var series []hdrhistogram.PlotSeries
+	var heatmap *hdrhistogram.Heatmap
+	var intervals int
+	if cfg.heatmap {
+		heatmap = hdrhistogram.NewHeatmap(cfg.rowsPerDoubling)
+	}
+	for _, file := range files {
+		if cfg.heatmap {
+			var n int
+			n, err = readHeatmap(cfg, file, stdin, heatmap)
+			intervals += n
+		} else {
+			var s []hdrhistogram.PlotSeries
+			s, err = readSeries(cfg, file, stdin)
+			series = append(series, s...)
+			intervals += len(s)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if intervals == 0 {
+		return errors.New("no intervals to plot")
+	}
+
+	out := stdout
+	if cfg.output != "" {
+		var f *os.File
+		if f, err = os.Create(cfg.output); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+	if cfg.heatmap {
+		options := &hdrhistogram.HeatmapOptions{
+			Title:          cfg.options.Title,
+			Width:          cfg.options.Width,
+			Height:         cfg.options.Height,
+			ValueUnitRatio: cfg.options.ValueUnitRatio,
+			ValueUnit:      cfg.options.ValueUnit,
+		}
+		switch cfg.format {
+		case "html":
+			return heatmap.WriteHTML(out, options)
+		case "png":
+			return heatmap.WritePNG(out, options)
+		case "ansi":
+			return heatmap.WriteANSI(out, &hdrhistogram.TerminalOptions{
+				Width:          cfg.options.Width,
+				Height:         cfg.options.Height,
+				Color:          cfg.color,
+				ASCII:          cfg.ascii,
+				ValueUnitRatio: cfg.options.ValueUnitRatio,
+				ValueUnit:      cfg.options.ValueUnit,
+			})
+		}
+		return heatmap.WriteSVG(out, options)
+	}
+	switch cfg.format {
+	case "html":
+		return hdrhistogram.WritePercentilePlotHTML(out, series, &cfg.options)
+	case "ansi":
+		return writeText(cfg, out, series)
+	}
+	return hdrhistogram.WritePercentilePlotSVG(out, series, &cfg.options)
+}
+
+// writeText writes the percentile distributions of series as text, comparing
+// them side by side if there are two.
+func writeText(cfg *config, out io.Writer, series []hdrhistogram.PlotSeries) error {
+	options := &hdrhistogram.TerminalOptions{
+		Width:           cfg.options.Width,
+		Color:           cfg.color,
+		ASCII:           cfg.ascii,
+		ValueUnitRatio:  cfg.options.ValueUnitRatio,
+		ValueUnit:       cfg.options.ValueUnit,
+		RowsPerDoubling: cfg.rowsPerDoubling,
+	}
+	if len(series) == 2 {
+		return hdrhistogram.WriteComparison(out, series[0], series[1], options)
+	}
+	for i, s := range series {
+		if i > 0 {
+			if _, err := io.WriteString(out, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", s.Label); err != nil {
+			return err
+		}
+		if err := s.Histogram.WriteSparkline(out, options); err != nil {
+			return err
+		}
+		if err := s.Histogram.WriteBarChart(out, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openLog opens the log in file, or stdin if it is "-", returning a reader
+// with the configured tag filter and a name for it.
+func openLog(cfg *config, file string, stdin io.Reader) (reader *hdrhistogram.HistogramLogReader, name string, closeLog func() error, err error) {
+	in, name, closeLog := stdin, "stdin", func() error { return nil }
+	if file != "-" {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		in, name, closeLog = f, filepath.Base(file), f.Close
+	}
+	reader = hdrhistogram.NewHistogramLogReader(in)
+	if cfg.tags != nil {
+		reader.SetTagFilter(&hdrhistogram.TagFilter{Include: cfg.tags})
+	}
+	return
+}
+
+// readHeatmap adds the intervals of the log in file to heatmap, returning
+// their number.
+func readHeatmap(cfg *config, file string, stdin io.Reader, heatmap *hdrhistogram.Heatmap) (n int, err error) {
+	reader, name, closeLog, err := openLog(cfg, file, stdin)
+	if err != nil {
+		return
+	}
+	defer closeLog()
+	for {
+		var h *hdrhistogram.Histogram
+		if h, err = reader.NextIntervalHistogramWithRange(cfg.start, cfg.end, false); err != nil {
+			return n, fmt.Errorf("%s: %w", name, err)
+		}
+		if h == nil {
+			return
+		}
+		heatmap.Add(h)
+		n++
+	}
+}
+
+// readSeries accumulates the log in file per tag, returning a series for each
+// tag labelled with the file name and tag.
+func readSeries(cfg *config, file string, stdin io.Reader) ([]hdrhistogram.PlotSeries, error) {
+	reader, name, closeLog, err := openLog(cfg, file, stdin)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLog()
+	accumulated, err := reader.AccumulateByTag(cfg.start, cfg.end, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	tags := make([]string, 0, len(accumulated))
+	for tag := range accumulated {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	series := make([]hdrhistogram.PlotSeries, 0, len(tags))
+	for _, tag := range tags {
+		label := name
+		if tag != "" {
+			label += " (" + tag + ")"
+		}
+		series = append(series, hdrhistogram.PlotSeries{Label: label, Histogram: accumulated[tag]})
+	}
+	return series, nil
+}
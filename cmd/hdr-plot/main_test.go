@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	taggedLog = "../../test/tagged-Log.logV2.hlog"
+	hiccupLog = "../../test/jHiccup-2.0.7S.logV2.hlog"
+)
+
+func runPlot(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// polylines returns the number of polylines of an SVG image, failing the test
+// if it isn't well formed.
+func polylines(t *testing.T, svg string) int {
+	t.Helper()
+	n := 0
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return n
+		}
+		if !assert.Nil(t, err) {
+			return n
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "polyline" {
+			n++
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	stdout, stderr, code := runPlot(t, "", "-title", "tagged", taggedLog, hiccupLog)
+	assert.Equal(t, 0, code, stderr)
+	// the untagged and A intervals of the tagged log, and the hiccup log
+	assert.Equal(t, 3, polylines(t, stdout))
+	for _, label := range []string{">tagged-Log.logV2.hlog<", ">tagged-Log.logV2.hlog (A)<", ">jHiccup-2.0.7S.logV2.hlog<", "Value (ms)"} {
+		assert.Contains(t, stdout, label)
+	}
+
+	dat, err := os.ReadFile(hiccupLog)
+	assert.Nil(t, err)
+	out := filepath.Join(t.TempDir(), "plot.html")
+	_, stderr, code = runPlot(t, string(dat), "-o", out, "-slo", "90:5,99:20", "-slo", "strict=99.9:1", "-tag", "", "-end", "30", "-")
+	assert.Equal(t, 0, code, stderr)
+	page, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(page, []byte("<!DOCTYPE html>")))
+	svg := string(page[bytes.Index(page, []byte("<svg")) : bytes.Index(page, []byte("</svg>"))+len("</svg>")])
+	assert.Equal(t, 3, polylines(t, svg))
+	assert.Contains(t, svg, ">stdin<")
+	assert.Contains(t, svg, ">strict<")
+}
+
+func TestRun_heatmap(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "heatmap.png")
+	_, stderr, code := runPlot(t, "", "-heatmap", "-width", "200", "-height", "100", "-o", out, hiccupLog)
+	assert.Equal(t, 0, code, stderr)
+	f, err := os.Open(out)
+	assert.Nil(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, img.Bounds().Dx())
+	assert.Equal(t, 100, img.Bounds().Dy())
+
+	stdout, stderr, code := runPlot(t, "", "-heatmap", "-format", "ansi", "-color", "always", "-width", "60", "-height", "12", taggedLog)
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	assert.LessOrEqual(t, len(lines), 12)
+	assert.Contains(t, stdout, "\x1b[38;2;")
+	assert.True(t, strings.HasPrefix(lines[len(lines)-1], "       ms 0s"))
+	stdout, stderr, code = runPlot(t, "", "-heatmap", "-format", "ansi", "-width", "60", "-height", "12", taggedLog)
+	assert.Equal(t, 0, code, stderr)
+	assert.NotContains(t, stdout, "\x1b")
+
+	stdout, stderr, code = runPlot(t, "", "-heatmap", "-title", "hiccups", hiccupLog)
+	assert.Equal(t, 0, code, stderr)
+	polylines(t, stdout)
+	assert.Contains(t, stdout, ">hiccups<")
+}
+
+func TestRun_text(t *testing.T) {
+	// two curves are compared side by side
+	stdout, stderr, code := runPlot(t, "", "-format", "ansi", "-width", "70", taggedLog)
+	assert.Equal(t, 0, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, strings.Repeat(" ", 7)+"tagged-Log.logV2.hlog        ms  tagged-Log.logV2.hlog (A)\n"))
+	assert.Contains(t, stdout, "tagged-Log.logV2.hlog (A)")
+	assert.Contains(t, stdout, "  percentile")
+	assert.NotContains(t, stdout, "\x1b")
+
+	// others get a sparkline and bar chart each
+	stdout, stderr, code = runPlot(t, "", "-format", "ansi", "-color", "always", "-ascii", hiccupLog)
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(stdout, "\n")
+	assert.Equal(t, "jHiccup-2.0.7S.logV2.hlog", lines[0])
+	assert.Contains(t, lines[1], "0% ")
+	assert.Contains(t, lines[2], "value (ms)")
+	assert.Contains(t, stdout, "\x1b[36m#")
+	assert.NotContains(t, stdout, "█")
+}
+
+func TestParseSLO(t *testing.T) {
+	slo, err := parseSLO("checkout=90:5, 99.9:50")
+	assert.Nil(t, err)
+	assert.Equal(t, hdrhistogram.PlotSLO{Label: "checkout", Points: []hdrhistogram.PlotSLOPoint{{Percentile: 90, Value: 5}, {Percentile: 99.9, Value: 50}}}, slo)
+	slo, err = parseSLO("99:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "SLO", slo.Label)
+	for _, s := range []string{"99", "x:1", "99:y", "100:1", "a=b"} {
+		_, err = parseSLO(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-format", "gif", taggedLog},
+		{"-format", "png", taggedLog},
+		{"-heatmap", "-rowsPerDoubling", "-1", taggedLog},
+		{"-format", "ansi", "-color", "sometimes", taggedLog},
+		{"-width", "-1", taggedLog},
+		{"-slo", "101:1", taggedLog},
+	} {
+		_, _, code := runPlot(t, "", args...)
+		assert.Equal(t, 2, code, args)
+	}
+	_, stderr, code := runPlot(t, "", filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "missing.hlog")
+	_, stderr, code = runPlot(t, "", "-tag", "none", taggedLog)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "no intervals")
+}
@@ -0,0 +1,205 @@
+package hdrhistogram
+
+// An EncodedView is a read-only view over a base64 encoded compressed
+// histogram. It answers the common summary queries by streaming the inflated
+// zig-zag payload, without ever materializing the counts array of a full
+// Histogram. This makes it well suited to scanning large archives of encoded
+// histograms where only a handful of statistics are needed from each.
+//
+// Results are identical to those of the Histogram returned by Decode for the
+// same input. An EncodedView can be reused for another payload with Reset.
+type EncodedView struct {
+	geometry   Histogram
+	payload    []byte
+	totalCount int64
+	minIdx     int64
+	maxIdx     int64
+}
+
+// NewEncodedView returns a view over the given base64 encoded compressed
+// histogram representation.
+func NewEncodedView(encoded []byte) (*EncodedView, error) {
+	v := &EncodedView{}
+	if err := v.Reset(encoded); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reset points the view at a new encoded histogram, reusing the view's
+// buffers. On error the view is left empty.
+func (v *EncodedView) Reset(encoded []byte) (err error) {
+	v.payload = v.payload[:0]
+	v.totalCount = 0
+	v.minIdx = -1
+	v.maxIdx = -1
+
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	payload, hdr, err := d.inflate(encoded)
+	if err != nil {
+		return
+	}
+	v.geometry = newGeometry(hdr.lowestTrackableValue, hdr.highestTrackableValue, int(hdr.significantFigures))
+
+	// Validate the payload and gather the totals in a single pass, so the
+	// queries below never have to deal with a corrupt payload.
+	var totalCount int64
+	minIdx, maxIdx := int64(-1), int64(-1)
+	it := v.iterator(payload)
+	for it.next() {
+		if minIdx < 0 {
+			minIdx = it.idx
+		}
+		maxIdx = it.idx
+		totalCount += it.count
+	}
+	if it.err != nil {
+		return it.err
+	}
+	v.payload = append(v.payload, payload...)
+	v.totalCount = totalCount
+	v.minIdx = minIdx
+	v.maxIdx = maxIdx
+	return
+}
+
+func (v *EncodedView) iterator(payload []byte) payloadIterator {
+	return payloadIterator{payload: payload, countsLen: int64(v.geometry.countsLen)}
+}
+
+// TotalCount returns total number of values recorded.
+func (v *EncodedView) TotalCount() int64 {
+	return v.totalCount
+}
+
+// LowestTrackableValue returns the lower bound on values of the encoded histogram.
+func (v *EncodedView) LowestTrackableValue() int64 {
+	return v.geometry.lowestDiscernibleValue
+}
+
+// HighestTrackableValue returns the upper bound on values of the encoded histogram.
+func (v *EncodedView) HighestTrackableValue() int64 {
+	return v.geometry.highestTrackableValue
+}
+
+// SignificantFigures returns the significant figures of the encoded histogram.
+func (v *EncodedView) SignificantFigures() int64 {
+	return v.geometry.significantFigures
+}
+
+// Max returns the approximate maximum recorded value.
+func (v *EncodedView) Max() int64 {
+	var max int64
+	if v.maxIdx >= 0 {
+		max = v.geometry.valueFromFlatIndex(int32(v.maxIdx))
+	}
+	return v.geometry.highestEquivalentValue(max)
+}
+
+// Min returns the approximate minimum recorded value.
+func (v *EncodedView) Min() int64 {
+	var min int64
+	if v.minIdx >= 0 {
+		min = v.geometry.valueFromFlatIndex(int32(v.minIdx))
+	}
+	return v.geometry.lowestEquivalentValue(min)
+}
+
+// Mean returns the approximate arithmetic mean of the recorded values.
+func (v *EncodedView) Mean() float64 {
+	if v.totalCount == 0 {
+		return 0
+	}
+	var mean float64
+	totalCount := float64(v.totalCount)
+	it := v.iterator(v.payload)
+	for it.next() {
+		value := v.geometry.valueFromFlatIndex(int32(it.idx))
+		mean += float64(it.count) * float64(v.geometry.medianEquivalentValue(value)) / totalCount
+	}
+	return mean
+}
+
+// ValueAtPercentile returns the largest value that (100% - percentile) of the
+// overall recorded value entries are either larger than or equivalent to.
+//
+// Returns 0 if no recorded values exist.
+func (v *EncodedView) ValueAtPercentile(percentile float64) int64 {
+	var result [1]int64
+	v.valueAtPercentilesInto([]float64{percentile}, result[:])
+	return result[0]
+}
+
+// ValueAtPercentiles, given a slice of percentiles returns a map containing for
+// each passed percentile, the largest value that (100% - percentile) of the
+// overall recorded value entries are either larger than or equivalent to. All
+// percentiles are resolved in a single pass over the payload, and, unlike
+// Histogram.ValueAtPercentiles, the given slice is not reordered.
+//
+// Returns a map of 0's if no recorded values exist.
+func (v *EncodedView) ValueAtPercentiles(percentiles []float64) map[float64]int64 {
+	result := make([]int64, len(percentiles))
+	v.valueAtPercentilesInto(percentiles, result)
+	values := make(map[float64]int64, len(percentiles))
+	for i, percentile := range percentiles {
+		values[percentile] = result[i]
+	}
+	return values
+}
+
+func (v *EncodedView) valueAtPercentilesInto(percentiles []float64, result []int64) {
+	if v.totalCount == 0 || len(percentiles) == 0 {
+		return
+	}
+	var buf [16]int64
+	targets := buf[:0]
+	if len(percentiles) > len(buf) {
+		targets = make([]int64, 0, len(percentiles))
+	}
+	var lastTarget int64
+	for _, percentile := range percentiles {
+		target := v.countAtPercentile(percentile)
+		targets = append(targets, target)
+		if target > lastTarget {
+			lastTarget = target
+		}
+	}
+	// The cumulative count only grows, so each target is crossed exactly once:
+	// when the running total reaches it while the previous total did not.
+	var total int64
+	it := v.iterator(v.payload)
+	for it.next() {
+		previous := total
+		total += it.count
+		for i, target := range targets {
+			if previous >= target || total < target {
+				continue
+			}
+			value := v.geometry.valueFromFlatIndex(int32(it.idx))
+			if percentiles[i] <= 0.0 {
+				result[i] = v.geometry.lowestEquivalentValue(value)
+			} else {
+				result[i] = v.geometry.highestEquivalentValue(value)
+			}
+		}
+		if total >= lastTarget {
+			return
+		}
+	}
+}
+
+// countAtPercentile mirrors the target count computed by
+// Histogram.ValueAtPercentile, including the clamping of the percentile.
+func (v *EncodedView) countAtPercentile(percentile float64) int64 {
+	if percentile > 100 {
+		percentile = 100
+	} else if percentile < 0 {
+		percentile = 0
+	}
+	count := int64(((percentile / 100) * float64(v.totalCount)) + 0.5)
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
@@ -0,0 +1,89 @@
+package hdrhistogram_test
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+)
+
+var viewPercentiles = []float64{0, 0.1, 25, 50, 90, 99, 99.9, 99.99, 100, 150, -5}
+
+func assertViewMatchesHistogram(t *testing.T, encoded []byte) {
+	t.Helper()
+	h, err := hdrhistogram.Decode(encoded)
+	assert.Nil(t, err)
+	v, err := hdrhistogram.NewEncodedView(encoded)
+	assert.Nil(t, err)
+
+	assert.Equal(t, h.TotalCount(), v.TotalCount())
+	assert.Equal(t, h.Max(), v.Max())
+	assert.Equal(t, h.Min(), v.Min())
+	assert.Equal(t, h.Mean(), v.Mean())
+	assert.Equal(t, h.LowestTrackableValue(), v.LowestTrackableValue())
+	assert.Equal(t, h.HighestTrackableValue(), v.HighestTrackableValue())
+	assert.Equal(t, h.SignificantFigures(), v.SignificantFigures())
+	for _, p := range viewPercentiles {
+		assert.Equal(t, h.ValueAtPercentile(p), v.ValueAtPercentile(p), "percentile %v", p)
+	}
+	percentiles := append([]float64(nil), viewPercentiles...)
+	assert.Equal(t, h.ValueAtPercentiles(append([]float64(nil), percentiles...)), v.ValueAtPercentiles(percentiles))
+	assert.Equal(t, viewPercentiles, percentiles, "ValueAtPercentiles must not reorder its input")
+}
+
+func TestEncodedView(t *testing.T) {
+	h := hdrhistogram.New(1000, 100000000, 3)
+	for i := int64(1); i <= 5000; i++ {
+		assert.Nil(t, h.RecordValue(i*i))
+	}
+	encoded, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	assertViewMatchesHistogram(t, encoded)
+
+	empty, err := hdrhistogram.New(100, 1000, 3).Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	assertViewMatchesHistogram(t, empty)
+}
+
+func TestEncodedView_logCorpus(t *testing.T) {
+	f, err := os.Open("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	lines := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "HIST") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		assertViewMatchesHistogram(t, []byte(line[strings.LastIndex(line, ",")+1:]))
+		lines++
+	}
+	assert.Equal(t, 62, lines)
+}
+
+func TestEncodedView_Reset(t *testing.T) {
+	h := hdrhistogram.New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	encoded, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+
+	v, err := hdrhistogram.NewEncodedView(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), v.Max())
+
+	// a failed Reset leaves the view empty rather than describing the previous payload
+	assert.NotNil(t, v.Reset([]byte("HISTFAAAAB542pNpmSzMwMDAxAABzFCaEUoz2X")))
+	assert.Equal(t, int64(0), v.TotalCount())
+	assert.Equal(t, int64(0), v.ValueAtPercentile(50))
+
+	assert.Nil(t, v.Reset(encoded))
+	assert.Equal(t, int64(1), v.TotalCount())
+
+	_, err = hdrhistogram.NewEncodedView([]byte("!!!notbase64!!!"))
+	assert.NotNil(t, err)
+}
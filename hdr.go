@@ -81,6 +81,15 @@ func (h *Histogram) SetStartTimeMs(startTimeMs int64) {
 // Note: the numberOfSignificantValueDigits must be [1,5]. If lower than 1 the numberOfSignificantValueDigits will be
 // forced to 1, and if higher than 5 the numberOfSignificantValueDigits will be forced to 5.
 func New(lowestDiscernibleValue, highestTrackableValue int64, numberOfSignificantValueDigits int) *Histogram {
+	h := newGeometry(lowestDiscernibleValue, highestTrackableValue, numberOfSignificantValueDigits)
+	h.counts = make([]int64, h.countsLen)
+	return &h
+}
+
+// newGeometry returns a Histogram with every layout field set as New would, but
+// without the counts array. It lets the decode paths resolve flat indexes of an
+// encoded histogram without allocating storage for it.
+func newGeometry(lowestDiscernibleValue, highestTrackableValue int64, numberOfSignificantValueDigits int) Histogram {
 	if numberOfSignificantValueDigits < 1 {
 		numberOfSignificantValueDigits = 1
 	} else if numberOfSignificantValueDigits > 5 {
@@ -123,7 +132,7 @@ func New(lowestDiscernibleValue, highestTrackableValue int64, numberOfSignifican
 	bucketCount := bucketsNeeded
 	countsLen := (bucketCount + 1) * (subBucketCount / 2)
 
-	return &Histogram{
+	return Histogram{
 		lowestDiscernibleValue:      lowestDiscernibleValue,
 		highestTrackableValue:       highestTrackableValue,
 		unitMagnitude:               int64(unitMagnitude),
@@ -135,7 +144,6 @@ func New(lowestDiscernibleValue, highestTrackableValue int64, numberOfSignifican
 		bucketCount:                 bucketCount,
 		countsLen:                   countsLen,
 		totalCount:                  0,
-		counts:                      make([]int64, countsLen),
 		startTimeMs:                 0,
 		endTimeMs:                   0,
 		tag:                         "",
@@ -925,7 +933,9 @@ func (h *Histogram) CumulativeDistributionWithTicks(ticksPerHalfDistance int32)
 	return result
 }
 
-// Output the percentiles distribution in a text format
+// Output the percentiles distribution in a text format. WritePercentiles
+// offers CSV and JSON formats, explicit percentiles and value units, and
+// returns the rows written.
 func (h *Histogram) PercentilesPrint(writer io.Writer, ticksPerHalfDistance int32, valueScale float64) (outputWriter io.Writer, err error) {
 	outputWriter = writer
 	dist := h.CumulativeDistributionWithTicks(ticksPerHalfDistance)
@@ -959,3 +969,22 @@ func (h *Histogram) PercentilesPrint(writer io.Writer, ticksPerHalfDistance int3
 	_, err = outputWriter.Write([]byte(footer))
 	return
 }
+
+// OutputPercentileDistribution writes the percentiles distribution in the
+// layout of the Java implementation's outputPercentileDistribution, as found
+// in the .hgrm files written by HistogramLogProcessor, so the output of both
+// can be diffed. Values are divided by outputValueUnitScalingRatio and printed
+// with as many decimals as the histogram has significant figures. With
+// useCsvFormat the rows are comma separated and the footer is left out. See
+// WritePercentiles for more formats and options.
+func (h *Histogram) OutputPercentileDistribution(writer io.Writer, ticksPerHalfDistance int32, outputValueUnitScalingRatio float64, useCsvFormat bool) (err error) {
+	options := &PercentilesOptions{
+		TicksPerHalfDistance: ticksPerHalfDistance,
+		ValueUnitRatio:       outputValueUnitScalingRatio,
+	}
+	if useCsvFormat {
+		options.Format = PercentilesCSV
+	}
+	_, err = h.WritePercentiles(writer, options)
+	return
+}
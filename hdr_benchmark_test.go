@@ -1,10 +1,14 @@
 package hdrhistogram_test
 
 import (
+	"bytes"
+	"context"
 	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
 	"gonum.org/v1/gonum/stat/distuv"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -120,6 +124,175 @@ func BenchmarkWindowedHistogramMerge(b *testing.B) {
 	}
 }
 
+// nolint
+func BenchmarkDecode(b *testing.B) {
+	encoded := encodedLogNormalHistogram(b)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := hdrhistogram.Decode(encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeInto and BenchmarkAddEncoded allocate no histogram, only the
+// few objects compress/flate and compress/zlib create per stream.
+//
+// nolint
+func BenchmarkDecodeInto(b *testing.B) {
+	encoded := encodedLogNormalHistogram(b)
+	dst := hdrhistogram.New(1, 1000000, 3)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := hdrhistogram.DecodeInto(dst, encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// nolint
+func BenchmarkAddEncoded(b *testing.B) {
+	encoded := encodedLogNormalHistogram(b)
+	dst := hdrhistogram.New(1, 1000000, 3)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := hdrhistogram.AddEncoded(dst, encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// nolint
+func BenchmarkEncode(b *testing.B) {
+	rand.Seed(12345)
+	h, _ := populateHistogramLogNormalDist(b, 1, 1000000, 3, 100000)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// nolint
+func BenchmarkEncodeAppend(b *testing.B) {
+	rand.Seed(12345)
+	h, _ := populateHistogramLogNormalDist(b, 1, 1000000, 3, 100000)
+	var buf []byte
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = h.EncodeAppend(buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// nolint
+func BenchmarkEncodedViewPercentiles(b *testing.B) {
+	encoded := encodedLogNormalHistogram(b)
+	percentilesOfInterest := []float64{50.0, 99.0, 100.0}
+	var v hdrhistogram.EncodedView
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := v.Reset(encoded); err != nil {
+			b.Fatal(err)
+		}
+		v.ValueAtPercentile(50.0)
+		v.ValueAtPercentiles(percentilesOfInterest)
+	}
+}
+
+// nolint
+func BenchmarkHistogramLogReader(b *testing.B) {
+	corpus := readLogCorpus(b, "./test/*.logV2.hlog")
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, dat := range corpus {
+			reader := hdrhistogram.NewHistogramLogReader(bytes.NewReader(dat))
+			for {
+				h, err := reader.NextIntervalHistogram()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if h == nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkHistogramLogReaderParse measures line parsing alone: ListTags
+// tokenizes every line without decoding the payloads, so the V0/V1 logs the
+// decoder does not support are included too.
+// nolint
+func BenchmarkHistogramLogReaderParallel(b *testing.B) {
+	corpus := readLogCorpus(b, "./test/*.logV2.hlog")
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, dat := range corpus {
+			reader := hdrhistogram.NewHistogramLogReader(bytes.NewReader(dat))
+			for _, err := range reader.ParallelEntries(context.Background(), 0) {
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkHistogramLogReaderParse(b *testing.B) {
+	corpus := readLogCorpus(b, "./test/*.hlog")
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, dat := range corpus {
+			if _, err := hdrhistogram.NewHistogramLogReader(bytes.NewReader(dat)).ListTags(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// readLogCorpus loads the logs under test/ matching pattern, and sets the
+// benchmark throughput to their total size.
+func readLogCorpus(b *testing.B, pattern string) (corpus [][]byte) {
+	files, err := filepath.Glob(pattern)
+	if err != nil || len(files) == 0 {
+		b.Fatal("no log corpus found", err)
+	}
+	var size int64
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size += int64(len(dat))
+		corpus = append(corpus, dat)
+	}
+	b.SetBytes(size)
+	return
+}
+
+func encodedLogNormalHistogram(b *testing.B) []byte {
+	rand.Seed(12345)
+	h, _ := populateHistogramLogNormalDist(b, 1, 1000000, 3, 100000)
+	encoded, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return encoded
+}
+
 func populateHistogramLogNormalDist(b *testing.B, lowestDiscernibleValue int64, highestTrackableValue int64, sigfigs int, totalDatapoints int) (*hdrhistogram.Histogram, []float64) {
 	dist := distuv.LogNormal{Mu: 0.0, Sigma: 0.5}
 	h := hdrhistogram.New(lowestDiscernibleValue, highestTrackableValue, sigfigs)
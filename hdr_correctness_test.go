@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/base64"
+	"encoding/binary"
 	"io"
 	"testing"
 )
@@ -48,11 +49,10 @@ func TestSerializedNormalizingIndexOffsetIsZero(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, _, offset, _, _, _, _, err := decodeDeCompressedHeaderFormat(raw[0:ENCODING_HEADER_SIZE])
-	if err != nil {
-		t.Fatal(err)
+	if len(raw) < ENCODING_HEADER_SIZE {
+		t.Fatalf("decompressed histogram is %d bytes, want at least %d", len(raw), ENCODING_HEADER_SIZE)
 	}
-	if offset != 0 {
+	if offset := int32(binary.BigEndian.Uint32(raw[8:12])); offset != 0 {
 		t.Fatalf("serialized normalizingIndexOffset = %d, want 0", offset)
 	}
 }
@@ -24,6 +24,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"sync"
 )
 
 const (
@@ -51,206 +53,333 @@ func (h *Histogram) Encode(version int32) (buffer []byte, err error) {
 // Decode returns a new Histogram by decoding it from a String containing
 // a base64 encoded compressed histogram representation.
 func Decode(encoded []byte) (rh *Histogram, err error) {
-	var decoded []byte
-	decoded, err = base64.StdEncoding.DecodeString(string(encoded))
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	payload, hdr, err := d.inflate(encoded)
 	if err != nil {
 		return
 	}
-	// The 8-byte header (cookie + compressed length) must be present before we
-	// slice it, otherwise a short/truncated input would panic on decoded[0:8].
-	if len(decoded) < 8 {
-		err = fmt.Errorf("encoded histogram too short: got %d bytes, need at least 8", len(decoded))
-		return
-	}
-	rbuf := bytes.NewBuffer(decoded[0:8])
-	r32 := make([]int32, 2)
-	err = binary.Read(rbuf, binary.BigEndian, &r32)
+	rh = New(hdr.lowestTrackableValue, hdr.highestTrackableValue, int(hdr.significantFigures))
+	err = fillCountsArrayFromSourceBuffer(payload, rh)
+	return
+}
+
+// DecodeInto replaces the contents of dst with the histogram decoded from a
+// base64 encoded compressed histogram representation.
+//
+// Unlike Decode, no new Histogram is allocated: the zig-zag payload is walked
+// once and its counts are written straight into dst, reusing pooled base64,
+// zlib and inflate buffers. The allocations left are made by compress/flate
+// and compress/zlib on every stream, a handful per call whatever the size of
+// the histogram. dst keeps its own geometry; an error is returned if
+// any of the encoded values fall outside of it. On error dst may hold a partial
+// decode.
+func DecodeInto(dst *Histogram, encoded []byte) (err error) {
+	dst.Reset()
+	dropped, err := AddEncoded(dst, encoded)
 	if err != nil {
 		return
 	}
-	Cookie := r32[0] & ^0xf0
-	lengthOfCompressedContents := r32[1]
-	if Cookie != V2CompressedEncodingCookieBase {
-		err = fmt.Errorf("encoding not supported, only V2 is supported. got %d want %d", Cookie, V2CompressedEncodingCookieBase)
-		return
-	}
-	decodeLengthOfCompressedContents := int32(len(decoded[8:]))
-	// A negative length (from attacker-controlled bytes) must be rejected: the
-	// slice decoded[8:8+negative] would panic on an invalid low>high bound.
-	if lengthOfCompressedContents < 0 {
-		err = fmt.Errorf("negative lengthOfCompressedContents: %d", lengthOfCompressedContents)
-		return
-	}
-	if lengthOfCompressedContents > decodeLengthOfCompressedContents {
-		err = fmt.Errorf("the compressed contents buffer is smaller than the lengthOfCompressedContents. got %d want %d", decodeLengthOfCompressedContents, lengthOfCompressedContents)
-		return
+	if dropped > 0 {
+		err = fmt.Errorf("%d encoded values are out of range of the destination histogram", dropped)
 	}
-	rh, err = decodeCompressedFormat(decoded[8:8+lengthOfCompressedContents], ENCODING_HEADER_SIZE)
 	return
 }
 
-// internal method to encode a histogram in V2 Compressed format
-func (h *Histogram) dumpV2CompressedEncoding() (outBuffer []byte, err error) {
-	// final buffer
-	buf := new(bytes.Buffer)
-	err = binary.Write(buf, binary.BigEndian, compressedEncodingCookie)
-	if err != nil {
-		return
-	}
-	toCompress, err := h.encodeIntoByteBuffer()
+// AddEncoded adds the counts of a base64 encoded compressed histogram
+// representation to dst, returning the number of recorded values which had to
+// be dropped. It is equivalent to Decode followed by dst.Merge, without
+// allocating the intermediate Histogram.
+//
+// When the encoded histogram shares dst's bucket layout (same lowest
+// discernible value magnitude and significant figures) the counts are added
+// index by index; otherwise every non-zero count is re-recorded by value.
+func AddEncoded(dst *Histogram, encoded []byte) (dropped int64, err error) {
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	payload, hdr, err := d.inflate(encoded)
 	if err != nil {
 		return
 	}
-	uncompressedBytes := toCompress.Bytes()
+	src := newGeometry(hdr.lowestTrackableValue, hdr.highestTrackableValue, int(hdr.significantFigures))
+	return addCountsFromPayload(payload, &src, dst)
+}
+
+// decodedHeader holds the fixed-size header that precedes the zig-zag payload
+// of an inflated V2 histogram.
+type decodedHeader struct {
+	payloadLength         int32
+	significantFigures    int32
+	lowestTrackableValue  int64
+	highestTrackableValue int64
+}
+
+// decoder holds the buffers needed to turn a base64 encoded compressed
+// histogram into its inflated payload. Instances are pooled so repeated decodes
+// reuse their buffers and zlib reader; only the Huffman tables of
+// compress/flate and the checksum of compress/zlib are allocated per call.
+type decoder struct {
+	raw      []byte
+	src      bytes.Reader
+	zr       io.ReadCloser
+	inflated bytes.Buffer
+}
+
+var decoderPool = sync.Pool{New: func() interface{} { return new(decoder) }}
 
-	var b bytes.Buffer
-	w, err := zlib.NewWriterLevel(&b, zlib.BestCompression)
+// inflate base64-decodes and decompresses encoded, validating both headers. The
+// returned payload aliases the decoder's buffers and is only valid until the
+// next call.
+func (d *decoder) inflate(encoded []byte) (payload []byte, hdr decodedHeader, err error) {
+	n := base64.StdEncoding.DecodedLen(len(encoded))
+	if cap(d.raw) < n {
+		d.raw = make([]byte, n)
+	}
+	n, err = base64.StdEncoding.Decode(d.raw[:n], encoded)
 	if err != nil {
 		return
 	}
-	_, err = w.Write(uncompressedBytes)
-	if err != nil {
+	decoded := d.raw[:n]
+	if len(decoded) < 8 {
+		err = fmt.Errorf("encoded histogram too short: got %d bytes, need at least 8", len(decoded))
 		return
 	}
-	err = w.Close()
-	if err != nil {
+	cookie := int32(binary.BigEndian.Uint32(decoded[0:4])) & ^0xf0
+	lengthOfCompressedContents := int32(binary.BigEndian.Uint32(decoded[4:8]))
+	if cookie != V2CompressedEncodingCookieBase {
+		err = fmt.Errorf("encoding not supported, only V2 is supported. got %d want %d", cookie, V2CompressedEncodingCookieBase)
 		return
 	}
-
-	// LengthOfCompressedContents
-	compressedContents := b.Bytes()
-	err = binary.Write(buf, binary.BigEndian, int32(len(compressedContents)))
-	if err != nil {
+	if lengthOfCompressedContents < 0 {
+		err = fmt.Errorf("negative lengthOfCompressedContents: %d", lengthOfCompressedContents)
 		return
 	}
-	err = binary.Write(buf, binary.BigEndian, compressedContents)
-	if err != nil {
+	if int(lengthOfCompressedContents) > len(decoded)-8 {
+		err = fmt.Errorf("the compressed contents buffer is smaller than the lengthOfCompressedContents. got %d want %d", len(decoded)-8, lengthOfCompressedContents)
 		return
 	}
-	outBuffer = []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
-	return
-}
-
-func (h *Histogram) encodeIntoByteBuffer() (*bytes.Buffer, error) {
-
-	countsBytes, err := h.fillBufferFromCountsArray()
-	if err != nil {
-		return nil, err
-	}
 
-	toCompress := new(bytes.Buffer)
-	err = binary.Write(toCompress, binary.BigEndian, encodingCookie) // 0-3
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, int32(len(countsBytes))) // 3-7
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, h.getNormalizingIndexOffset()) // 8-11
-	if err != nil {
-		return nil, err
+	d.src.Reset(decoded[8 : 8+lengthOfCompressedContents])
+	if d.zr == nil {
+		d.zr, err = zlib.NewReader(&d.src)
+	} else {
+		err = d.zr.(zlib.Resetter).Reset(&d.src, nil)
 	}
-	err = binary.Write(toCompress, binary.BigEndian, int32(h.significantFigures)) // 12-15
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, h.lowestDiscernibleValue) // 16-23
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, h.highestTrackableValue) // 24-31
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, h.getIntegerToDoubleValueConversionRatio()) // 32-39
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(toCompress, binary.BigEndian, countsBytes)
-	if err != nil {
-		return nil, err
-	}
-	return toCompress, err
-}
-
-func decodeCompressedFormat(compressedContents []byte, headerSize int) (rh *Histogram, err error) {
-	b := bytes.NewReader(compressedContents)
-	z, err := zlib.NewReader(b)
 	if err != nil {
 		return
 	}
-	defer func() {
-		if closeErr := z.Close(); closeErr != nil && err == nil {
-			err = closeErr
-		}
-	}()
-	decompressedSlice, err := io.ReadAll(z)
-	if err != nil {
+	d.inflated.Reset()
+	if _, err = d.inflated.ReadFrom(d.zr); err != nil {
 		return
 	}
-	decompressedSliceLen := int32(len(decompressedSlice))
-	// The fixed-size header must be fully present before it is sliced/parsed,
-	// otherwise a stream decompressing to fewer than headerSize bytes would panic.
-	if len(decompressedSlice) < headerSize {
-		err = fmt.Errorf("decompressed histogram truncated: got %d bytes, need at least %d", len(decompressedSlice), headerSize)
-		return
-	}
-	cookie, PayloadLength, _, NumberOfSignificantValueDigits, LowestTrackableValue, HighestTrackableValue, _, err := decodeDeCompressedHeaderFormat(decompressedSlice[0:headerSize])
-	if err != nil {
+	inflated := d.inflated.Bytes()
+	if len(inflated) < ENCODING_HEADER_SIZE {
+		err = fmt.Errorf("decompressed histogram truncated: got %d bytes, need at least %d", len(inflated), ENCODING_HEADER_SIZE)
 		return
 	}
+	cookie = int32(binary.BigEndian.Uint32(inflated[0:4])) & ^0xf0
 	if cookie != V2EncodingCookieBase {
 		err = fmt.Errorf("encoding not supported, only V2 is supported. got %d want %d", cookie, V2EncodingCookieBase)
 		return
 	}
-	actualPayloadLen := decompressedSliceLen - int32(headerSize)
-	if PayloadLength != actualPayloadLen {
-		err = fmt.Errorf("PayloadLength should have the same size of the actual payload. got %d want %d", actualPayloadLen, PayloadLength)
-		return
+	hdr = decodedHeader{
+		payloadLength:         int32(binary.BigEndian.Uint32(inflated[4:8])),
+		significantFigures:    int32(binary.BigEndian.Uint32(inflated[12:16])),
+		lowestTrackableValue:  int64(binary.BigEndian.Uint64(inflated[16:24])),
+		highestTrackableValue: int64(binary.BigEndian.Uint64(inflated[24:32])),
 	}
-	rh = New(LowestTrackableValue, HighestTrackableValue, int(NumberOfSignificantValueDigits))
-	payload := decompressedSlice[headerSize:]
-	err = fillCountsArrayFromSourceBuffer(payload, rh)
-	return rh, err
+	payload = inflated[ENCODING_HEADER_SIZE:]
+	if int(hdr.payloadLength) != len(payload) {
+		err = fmt.Errorf("PayloadLength should have the same size of the actual payload. got %d want %d", len(payload), hdr.payloadLength)
+	}
+	return
 }
 
-func fillCountsArrayFromSourceBuffer(payload []byte, rh *Histogram) (err error) {
-	var payloadSlicePos = 0
-	var dstIndex int64 = 0
-	var n int
-	var count int64
-	var zerosCount int64
-	for payloadSlicePos < len(payload) {
-		count, n, err = zig_zag_decode_i64(payload[payloadSlicePos:])
+// addCountsFromPayload walks a zig-zag run-length payload laid out with the
+// geometry of src and adds its counts to dst. src only needs its layout fields
+// set; its counts array is never touched.
+func addCountsFromPayload(payload []byte, src *Histogram, dst *Histogram) (dropped int64, err error) {
+	sameLayout := src.unitMagnitude == dst.unitMagnitude &&
+		src.subBucketHalfCountMagnitude == dst.subBucketHalfCountMagnitude
+	it := payloadIterator{payload: payload, countsLen: int64(src.countsLen)}
+	for it.next() {
+		switch {
+		case sameLayout && it.idx < int64(len(dst.counts)):
+			dst.setCountAtIndex(int(it.idx), it.count)
+		case sameLayout:
+			dropped += it.count
+		default:
+			if dst.RecordValues(src.valueFromFlatIndex(int32(it.idx)), it.count) != nil {
+				dropped += it.count
+			}
+		}
+	}
+	return dropped, it.err
+}
+
+// payloadIterator walks a zig-zag run-length payload, stopping at every
+// non-zero count. Indexes are validated against countsLen, so a corrupt payload
+// ends the walk with err set instead of producing out of range indexes.
+type payloadIterator struct {
+	payload   []byte
+	pos       int
+	countsLen int64
+	nextIdx   int64
+
+	idx   int64
+	count int64
+	err   error
+}
+
+func (it *payloadIterator) next() bool {
+	for it.pos < len(it.payload) {
+		count, n, err := zig_zag_decode_i64(it.payload[it.pos:])
 		if err != nil {
-			return
+			it.err = err
+			return false
 		}
-		payloadSlicePos += n
+		it.pos += n
 		if count < 0 {
-			// A zero-run must not advance the destination index past the counts
-			// array; a corrupt payload could otherwise push it out of range before
-			// the next positive write.
-			zerosCount = -count
-			if zerosCount > int64(len(rh.counts))-dstIndex {
-				return fmt.Errorf("corrupt histogram payload: zero-run of %d at index %d overflows counts array of length %d", zerosCount, dstIndex, len(rh.counts))
+			// -math.MinInt64 overflows back to itself, so the run length is
+			// checked to be positive as well as to fit.
+			zerosCount := -count
+			if zerosCount <= 0 {
+				it.err = fmt.Errorf("corrupt histogram payload: invalid zero-run of %d at index %d", count, it.nextIdx)
+				return false
 			}
-			dstIndex += zerosCount
-		} else {
-			// setCountAtIndex writes h.counts[dstIndex] unchecked (it is the record
-			// hot path); the decode path validates the untrusted index here instead.
-			if dstIndex >= int64(len(rh.counts)) {
-				return fmt.Errorf("corrupt histogram payload: index %d overflows counts array of length %d", dstIndex, len(rh.counts))
+			if zerosCount > it.countsLen-it.nextIdx {
+				it.err = fmt.Errorf("corrupt histogram payload: zero-run of %d at index %d overflows counts array of length %d", zerosCount, it.nextIdx, it.countsLen)
+				return false
 			}
-			rh.setCountAtIndex(int(dstIndex), count)
-			dstIndex += 1
+			it.nextIdx += zerosCount
+			continue
+		}
+		if it.nextIdx >= it.countsLen {
+			it.err = fmt.Errorf("corrupt histogram payload: index %d overflows counts array of length %d", it.nextIdx, it.countsLen)
+			return false
 		}
+		it.idx = it.nextIdx
+		it.nextIdx++
+		if count > 0 {
+			it.count = count
+			return true
+		}
+	}
+	return false
+}
+
+// An Encoder produces V2 compressed encodings of histograms at a fixed zlib
+// compression level. Its zlib writers and scratch buffers are pooled, so
+// encoding many histograms does not allocate beyond growing the destination
+// slice. An Encoder is safe for concurrent use.
+type Encoder struct {
+	level int
+	pool  sync.Pool
+}
+
+// NewEncoder returns an Encoder compressing at the given zlib level, which must
+// be zlib.DefaultCompression, zlib.NoCompression, zlib.HuffmanOnly or any
+// integer value between zlib.BestSpeed and zlib.BestCompression inclusive.
+func NewEncoder(level int) (*Encoder, error) {
+	if level < zlib.HuffmanOnly || level > zlib.BestCompression {
+		return nil, fmt.Errorf("invalid compression level: %d", level)
+	}
+	e := &Encoder{level: level}
+	e.pool.New = func() interface{} { return new(encoder) }
+	return e, nil
+}
+
+// defaultEncoder backs Encode and EncodeAppend. BestCompression matches the
+// output of earlier releases byte for byte.
+var defaultEncoder, _ = NewEncoder(zlib.BestCompression)
+
+// encoder holds the per-call scratch state of an Encoder.
+type encoder struct {
+	raw        []byte
+	compressed bytes.Buffer
+	zw         *zlib.Writer
+}
+
+// EncodeAppend appends the base64 encoded V2 compressed representation of h
+// to dst and returns the extended buffer.
+func (e *Encoder) EncodeAppend(dst []byte, h *Histogram) (out []byte, err error) {
+	enc := e.pool.Get().(*encoder)
+	defer e.pool.Put(enc)
+
+	enc.raw = h.appendUncompressedEncoding(enc.raw[:0])
+
+	// Reserve the cookie + compressed length header, and compress after it so
+	// the whole binary form is contiguous before base64 encoding.
+	enc.compressed.Reset()
+	var header [8]byte
+	enc.compressed.Write(header[:])
+	if enc.zw == nil {
+		enc.zw, err = zlib.NewWriterLevel(&enc.compressed, e.level)
+		if err != nil {
+			return dst, err
+		}
+	} else {
+		enc.zw.Reset(&enc.compressed)
+	}
+	if _, err = enc.zw.Write(enc.raw); err != nil {
+		return dst, err
 	}
+	if err = enc.zw.Close(); err != nil {
+		return dst, err
+	}
+
+	compressed := enc.compressed.Bytes()
+	putInt32(compressed[0:4], compressedEncodingCookie)
+	putInt32(compressed[4:8], int32(len(compressed)-8))
+	return base64.StdEncoding.AppendEncode(dst, compressed), nil
+}
+
+// EncodeAppend appends the base64 encoded V2 compressed representation of the
+// histogram to dst and returns the extended buffer. The output is identical to
+// Encode(V2CompressedEncodingCookieBase); use an Encoder to pick a different
+// compression level.
+func (h *Histogram) EncodeAppend(dst []byte) ([]byte, error) {
+	return defaultEncoder.EncodeAppend(dst, h)
+}
+
+// internal method to encode a histogram in V2 Compressed format
+func (h *Histogram) dumpV2CompressedEncoding() (outBuffer []byte, err error) {
+	return defaultEncoder.EncodeAppend(nil, h)
+}
+
+// appendUncompressedEncoding appends the uncompressed V2 encoding of the
+// histogram (the 40 byte header followed by the zig-zag counts payload) to dst.
+func (h *Histogram) appendUncompressedEncoding(dst []byte) []byte {
+	start := len(dst)
+	var header [ENCODING_HEADER_SIZE]byte
+	dst = append(dst, header[:]...)
+	dst = h.appendCountsArray(dst)
+
+	hdr := dst[start : start+ENCODING_HEADER_SIZE]
+	putInt32(hdr[0:4], encodingCookie)
+	putInt32(hdr[4:8], int32(len(dst)-start-ENCODING_HEADER_SIZE))
+	putInt32(hdr[8:12], h.getNormalizingIndexOffset())
+	putInt32(hdr[12:16], int32(h.significantFigures))
+	binary.BigEndian.PutUint64(hdr[16:24], uint64(h.lowestDiscernibleValue))
+	binary.BigEndian.PutUint64(hdr[24:32], uint64(h.highestTrackableValue))
+	binary.BigEndian.PutUint64(hdr[32:40], math.Float64bits(h.getIntegerToDoubleValueConversionRatio()))
+	return dst
+}
+
+func putInt32(b []byte, v int32) {
+	binary.BigEndian.PutUint32(b, uint32(v))
+}
+
+func fillCountsArrayFromSourceBuffer(payload []byte, rh *Histogram) (err error) {
+	// The payload iterator validates every index against rh's own geometry, so
+	// a corrupt payload is reported rather than written out of range.
+	_, err = addCountsFromPayload(payload, rh, rh)
 	return
 }
 
-func (h *Histogram) fillBufferFromCountsArray() (buffer []byte, err error) {
-	buf := new(bytes.Buffer)
+// appendCountsArray appends the zig-zag run-length encoding of the counts array
+// to dst and returns the extended buffer.
+func (h *Histogram) appendCountsArray(dst []byte) []byte {
 	// V2 encoding format uses a ZigZag LEB128-64b9B encoded long. Positive values are counts,
 	// while negative values indicate a repeat zero counts.
 	var countsLimit = int32(h.countsIndexFor(h.Max()) + 1)
@@ -269,42 +398,10 @@ func (h *Histogram) fillBufferFromCountsArray() (buffer []byte, err error) {
 			}
 		}
 		if zeros > 1 {
-			err = binary.Write(buf, binary.BigEndian, zig_zag_encode_i64(-zeros))
-			if err != nil {
-				return
-			}
+			dst = zig_zag_append_i64(dst, -zeros)
 		} else {
-			err = binary.Write(buf, binary.BigEndian, zig_zag_encode_i64(count))
-			if err != nil {
-				return
-			}
+			dst = zig_zag_append_i64(dst, count)
 		}
 	}
-	buffer = buf.Bytes()
-	return
-}
-
-func decodeDeCompressedHeaderFormat(decoded []byte) (Cookie int32, PayloadLength int32, NormalizingIndexOffSet int32, NumberOfSignificantValueDigits int32, LowestTrackableValue int64, HighestTrackableValue int64, IntegerToDoubleConversionRatio float64, err error) {
-	rbuf := bytes.NewBuffer(decoded[0:40])
-	r32 := make([]int32, 4)
-	r64 := make([]int64, 2)
-	err = binary.Read(rbuf, binary.BigEndian, &r32)
-	if err != nil {
-		return
-	}
-	err = binary.Read(rbuf, binary.BigEndian, &r64)
-	if err != nil {
-		return
-	}
-	err = binary.Read(rbuf, binary.BigEndian, &IntegerToDoubleConversionRatio)
-	if err != nil {
-		return
-	}
-	Cookie = r32[0] & ^0xf0
-	PayloadLength = r32[1]
-	NormalizingIndexOffSet = r32[2]
-	NumberOfSignificantValueDigits = r32[3]
-	LowestTrackableValue = r64[0]
-	HighestTrackableValue = r64[1]
-	return
+	return dst
 }
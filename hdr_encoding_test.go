@@ -1,6 +1,8 @@
 package hdrhistogram_test
 
 import (
+	"compress/zlib"
+
 	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -97,3 +99,112 @@ func TestHistogram_Dump_Load_Merge(t *testing.T) {
 	assert.Equal(t, int64(1), h1Decoded.LowestTrackableValue())
 	assert.Equal(t, int64(1000), h1Decoded.HighestTrackableValue())
 }
+
+func TestDecodeInto(t *testing.T) {
+	h := hdrhistogram.New(1, 100000, 3)
+	for i := 1; i <= 1000; i++ {
+		assert.Nil(t, h.RecordValue(int64(i*37)))
+	}
+	encoded, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+
+	dst := hdrhistogram.New(1, 100000, 3)
+	assert.Nil(t, dst.RecordValue(5))
+	assert.Nil(t, hdrhistogram.DecodeInto(dst, encoded))
+	assert.True(t, h.Equals(dst))
+
+	// decoding twice into the same histogram replaces, rather than adds to, its contents
+	assert.Nil(t, hdrhistogram.DecodeInto(dst, encoded))
+	assert.True(t, h.Equals(dst))
+
+	// values outside of the destination's range are reported
+	small := hdrhistogram.New(1, 1000, 3)
+	assert.NotNil(t, hdrhistogram.DecodeInto(small, encoded))
+
+	assert.NotNil(t, hdrhistogram.DecodeInto(dst, []byte("HISTFAAAAB542pNpmSzMwMDAxAABzFCaEUoz2X")))
+	assert.NotNil(t, hdrhistogram.DecodeInto(dst, []byte("!!!notbase64!!!")))
+}
+
+func TestAddEncoded(t *testing.T) {
+	h1 := hdrhistogram.New(1, 100000, 3)
+	h2 := hdrhistogram.New(1, 100000, 3)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, h1.RecordValue(int64(i)))
+		assert.Nil(t, h2.RecordValue(int64(i*1000)))
+	}
+	encoded, err := h2.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+
+	want := hdrhistogram.New(1, 100000, 3)
+	want.Merge(h1)
+	want.Merge(h2)
+
+	dropped, err := hdrhistogram.AddEncoded(h1, encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), dropped)
+	assert.True(t, want.Equals(h1))
+
+	// a destination with a different geometry is merged value by value, like Merge
+	other := hdrhistogram.New(1000, 10000, 2)
+	wantOther := hdrhistogram.New(1000, 10000, 2)
+	wantDropped := wantOther.Merge(h2)
+	dropped, err = hdrhistogram.AddEncoded(other, encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, wantDropped, dropped)
+	assert.True(t, wantOther.Equals(other))
+}
+
+// The encoder must keep producing byte-identical output, so payloads stay
+// comparable with those written by earlier releases.
+func TestHistogram_Encode_golden(t *testing.T) {
+	h := hdrhistogram.New(1, 3600000000, 3)
+	for i := int64(1); i <= 10000; i++ {
+		assert.Nil(t, h.RecordValue(i*i))
+	}
+	assert.Nil(t, h.RecordValues(1000, 1<<40))
+	want := "HISTFAAAAxZ42qyVz0tUbxTG7/vMeOc7iIgMIl9MRCRERCQkJEQmEREZREREJCQkJCREJCRcREFBixYtXLRo0aKgRYv+m/6O/oTwdvW+P84573mv8z73nOf5PGf2c+/9VSfLpg6yf69Rurlev6++Z90/ZYMGWhjEMDoYwzgmMY0ZzGEBi1jCMrpYwwZ62MYu9vEET/EMz/ECZ3iJV+i9vX6P5/EG7ww+GHw0+GRwZfDZ4IvBV4NvBj8Mfhr8MjjDAQ5xiCMc4RjHOMEJTnGKc5zjAhe4xCVeYwVddLGKVaxhDetYxwY2sIlN9NDDFrawjW3sYAe72MUe9rCPVcwVmi+1UOpBqcVSD0stlXpUarnUClYwUWiymEpTxVSaLqbS/WIqzRRTabaYSnOYxSg61oQacybU/86EGncm1IQzNxpyNGx5OMOW0xqxPJwRy2l1LA+nYzmt0WK30UYLbdJ1GiS92vy4O3SdhkivNj/uDt3XgKgWQy3Rq02xbuzts+R2CilVbYbaolebYt3Y+3oapbLbRDPV9EsD0WaAzGHSeXzzTFP6cCQxv/VOJzdTTDWSsjupkXhrJHEjmuWWb9KcIt3F33qOp5SmP5PWaJKO+a291HepodoMyADXnMTXBNNVXz9Vx96IKFSipwDT1FnKNpL5qr6pWQzaIxdVfTJqCrZka8VR/ZO6uuPLUm9ZvMm0lJGRrsWkCGyRJWDcCGAr9aajVaj4rktFQq1oAxBL8RCZ9DOPNaLyQP15e9YEmoWYxTeEuzGgmh8DQrJMbBhUjQKYIFb+0jFTsJuvEoxB1lVBrBSRzS54FEGiIBq6k/rYLeU39V4z5SFagQEICUwhWAgQiviKBLawhscwVkYVPhCc8mlI5XUWkWVjWRUJZCrFSVTqUUJ99APDhHFVnufNPL/ZxKIDM5w5Sff5MQR6+UYFJxKokIRssC3IzPLB7xmKfDwrjUF2aHCDWEkc2RR4xuCtS4FMfHYhwIC5LnbRvf8iL2cgp90xa3MrpyCnYmU5xbl/JT1YvlGBLRzx6AfbgkwAG9mPAsf+DgBkhKP1"
+	got, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	assert.Equal(t, want, string(got))
+
+	empty := hdrhistogram.New(1, 1000, 3)
+	got, err = empty.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	assert.Equal(t, "HISTFAAAACl42kTHIRUAIAwFwOP9CFi6UI4GlEQSYXLy1rkTAxBo5+0PqAEAReYDZg==", string(got))
+}
+
+func TestHistogram_EncodeAppend(t *testing.T) {
+	h := hdrhistogram.New(1, 100000, 3)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, h.RecordValue(int64(i)))
+	}
+	encoded, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+
+	line := []byte("Tag=A,")
+	line, err = h.EncodeAppend(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tag=A,"+string(encoded), string(line))
+}
+
+func TestEncoder(t *testing.T) {
+	_, err := hdrhistogram.NewEncoder(42)
+	assert.NotNil(t, err)
+
+	h := hdrhistogram.New(1, 100000, 3)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, h.RecordValue(int64(i*7)))
+	}
+	for _, level := range []int{zlib.NoCompression, zlib.BestSpeed, zlib.DefaultCompression, zlib.HuffmanOnly} {
+		e, err := hdrhistogram.NewEncoder(level)
+		assert.Nil(t, err)
+		// encode twice to exercise the pooled writer
+		for i := 0; i < 2; i++ {
+			encoded, err := e.EncodeAppend(nil, h)
+			assert.Nil(t, err)
+			decoded, err := hdrhistogram.Decode(encoded)
+			assert.Nil(t, err)
+			assert.True(t, h.Equals(decoded))
+		}
+	}
+}
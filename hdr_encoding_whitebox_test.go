@@ -1,18 +1,28 @@
 package hdrhistogram
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"math"
+	"strings"
+	"testing"
+
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
-func TestHistogram_encodeIntoByteBuffer(t *testing.T) {
+func TestHistogram_appendUncompressedEncoding(t *testing.T) {
 	hist := New(1, 9007199254740991, 2)
 	err := hist.RecordValue(42)
 	assert.Nil(t, err)
-	buffer, err := hist.encodeIntoByteBuffer()
-	assert.Nil(t, err)
-	assert.Equal(t, 42, buffer.Len())
+	buffer := hist.appendUncompressedEncoding(nil)
+	assert.Equal(t, 42, len(buffer))
+
+	// appending preserves the existing prefix
+	prefixed := hist.appendUncompressedEncoding([]byte("abc"))
+	assert.Equal(t, "abc", string(prefixed[:3]))
+	assert.Equal(t, buffer, prefixed[3:])
 }
 
 func TestHistogram_DumpLoadWhiteBox(t *testing.T) {
@@ -31,3 +41,45 @@ func TestHistogram_DumpLoadWhiteBox(t *testing.T) {
 		t.Errorf("counts differs: (-got +want)\n%s", diff)
 	}
 }
+
+// encodeRawPayload returns the base64 compressed encoding of h's header
+// followed by payload in place of h's counts.
+func encodeRawPayload(t *testing.T, h *Histogram, payload []byte) []byte {
+	raw := h.appendUncompressedEncoding(nil)[:ENCODING_HEADER_SIZE]
+	putInt32(raw[4:8], int32(len(payload)))
+	raw = append(raw, payload...)
+	var compressed bytes.Buffer
+	compressed.Write(make([]byte, 8))
+	zw := zlib.NewWriter(&compressed)
+	_, err := zw.Write(raw)
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+	b := compressed.Bytes()
+	putInt32(b[0:4], compressedEncodingCookie)
+	putInt32(b[4:8], int32(len(b)-8))
+	return []byte(base64.StdEncoding.EncodeToString(b))
+}
+
+func TestDecode_corruptZeroRun(t *testing.T) {
+	// A zero-run of math.MinInt64, whose negation overflows back to itself.
+	payload := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}
+	count, n, err := zig_zag_decode_i64(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, int64(math.MinInt64), count)
+
+	h := New(1, 1000, 3)
+	encoded := encodeRawPayload(t, h, payload)
+	_, err = Decode(encoded)
+	assert.ErrorContains(t, err, "corrupt histogram payload")
+	assert.ErrorContains(t, DecodeInto(h, encoded), "corrupt histogram payload")
+	_, err = AddEncoded(h, encoded)
+	assert.ErrorContains(t, err, "corrupt histogram payload")
+	_, err = NewEncodedView(encoded)
+	assert.NotNil(t, err)
+
+	// the log readers report the line rather than crash
+	findings, err := VerifyHistogramLog(strings.NewReader("0.000,1.000,1.000,"+string(encoded)+"\n"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, LogCheckDecode, findings[0].Check)
+}
@@ -1,10 +1,12 @@
 package hdrhistogram_test
 
 import (
+	"bytes"
 	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
 	"github.com/stretchr/testify/assert"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -690,3 +692,32 @@ func TestPercentiles_NegativeClampAcrossAPIs(t *testing.T) {
 		}
 	}
 }
+
+func TestOutputPercentileDistribution(t *testing.T) {
+	h := hdrhistogram.New(1, 100000, 2)
+	for i := int64(1); i <= 100; i++ {
+		assert.Nil(t, h.RecordValue(i*10))
+	}
+	var b bytes.Buffer
+	assert.Nil(t, h.OutputPercentileDistribution(&b, 1, 10.0, false))
+	lines := strings.Split(b.String(), "\n")
+	assert.Equal(t, "       Value     Percentile TotalCount 1/(1-Percentile)", lines[0])
+	assert.Equal(t, "", lines[1])
+	assert.Equal(t, "        1.00 0.000000000000          1           1.00", lines[2])
+	assert.Equal(t, "       50.10 0.500000000000         50           2.00", lines[3])
+	assert.Equal(t, "      100.30 1.000000000000        100", lines[10])
+	assert.Equal(t, "#[Max     =       100.30, Total count    =          100]", lines[12])
+	assert.True(t, strings.HasSuffix(b.String(), "#[Buckets =           10, SubBuckets     =          256]\n"))
+
+	b.Reset()
+	assert.Nil(t, h.OutputPercentileDistribution(&b, 1, 10.0, true))
+	lines = strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert.Equal(t, `"Value","Percentile","TotalCount","1/(1-Percentile)"`, lines[0])
+	assert.Equal(t, "1.00,0.000000000000,1,1.00", lines[1])
+	assert.Equal(t, "100.30,1.000000000000,100,Infinity", lines[len(lines)-1])
+
+	// an empty histogram has a header and footer, but no rows
+	b.Reset()
+	assert.Nil(t, hdrhistogram.New(1, 1000, 3).OutputPercentileDistribution(&b, 5, 1.0, true))
+	assert.Equal(t, "\"Value\",\"Percentile\",\"TotalCount\",\"1/(1-Percentile)\"\n", b.String())
+}
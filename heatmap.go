@@ -0,0 +1,449 @@
+package hdrhistogram
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Heatmap is a latency heatmap of interval histograms: time runs along the x
+// axis, values along the y axis in log spaced rows, and the colour of every
+// cell shows how many values of the intervals at that time fell in that row.
+// Unlike percentile lines it shows multimodal distributions, and how they
+// shift over time.
+//
+// Rows are fractions of doublings of value, so the histograms' buckets map
+// onto them without knowing the range of values ahead, and intervals can be
+// added as they are read.
+type Heatmap struct {
+	rowsPerDoubling int
+	columns         []heatmapColumn
+	// minRow and maxRow are the lowest and highest rows with counts.
+	minRow, maxRow int
+}
+
+// heatmapColumn is an interval's counts, counts[i] in row minRow + i.
+type heatmapColumn struct {
+	startTimeMs, endTimeMs int64
+	minRow                 int
+	counts                 []int64
+}
+
+// HeatmapOptions configures the output of a Heatmap. Zero fields use their
+// defaults.
+type HeatmapOptions struct {
+	// Title is drawn above the SVG image.
+	Title string
+	// Width and Height are the size of the image in pixels, 800 by 400 by
+	// default.
+	Width, Height int
+	// ValueUnitRatio is the ratio values are divided by for the labels, 1 by
+	// default, and ValueUnit their unit once divided, such as "ms".
+	ValueUnitRatio float64
+	ValueUnit      string
+}
+
+// heatmapRamp is the colour ramp of the cells, from the lowest to the highest
+// count.
+var heatmapRamp = []color.RGBA{
+	{0x44, 0x01, 0x54, 0xff},
+	{0x3b, 0x52, 0x8b, 0xff},
+	{0x21, 0x91, 0x8c, 0xff},
+	{0x5e, 0xc9, 0x62, 0xff},
+	{0xfd, 0xe7, 0x25, 0xff},
+}
+
+// The margins around the SVG heatmap area, leaving room for the labels.
+const (
+	heatmapMarginLeft   = 70
+	heatmapMarginRight  = 20
+	heatmapMarginTop    = 40
+	heatmapMarginBottom = 40
+	// heatmapLabelWidth is the width of the value labels of the ANSI output.
+	heatmapLabelWidth = 10
+)
+
+// NewHeatmap returns an empty heatmap splitting every doubling of value into
+// rowsPerDoubling rows, or 4 if it is not positive.
+func NewHeatmap(rowsPerDoubling int) *Heatmap {
+	if rowsPerDoubling <= 0 {
+		rowsPerDoubling = 4
+	}
+	return &Heatmap{rowsPerDoubling: rowsPerDoubling, minRow: math.MaxInt, maxRow: math.MinInt}
+}
+
+// Add adds an interval histogram as a column spanning its start and end time.
+// Intervals may be added in any order, and overlapping intervals add up.
+func (m *Heatmap) Add(interval *Histogram) {
+	c := heatmapColumn{startTimeMs: interval.StartTimeMs(), endTimeMs: interval.EndTimeMs()}
+	c.minRow, c.counts = logRowCounts(interval, m.rowsPerDoubling)
+	if c.counts != nil {
+		m.minRow = min(m.minRow, c.minRow)
+		m.maxRow = max(m.maxRow, c.minRow+len(c.counts)-1)
+	}
+	m.columns = append(m.columns, c)
+}
+
+// logRowCounts returns the counts of h in log spaced rows of value,
+// rowsPerDoubling per doubling, counts[i] in row minRow + i, from its lowest
+// to its highest row with counts. It returns nil counts if h is empty.
+func logRowCounts(h *Histogram, rowsPerDoubling int) (minRow int, counts []int64) {
+	for _, bar := range h.Distribution() {
+		if bar.Count == 0 {
+			continue
+		}
+		row := logRow((bar.From+bar.To)/2, rowsPerDoubling)
+		if counts == nil {
+			minRow = row
+		}
+		for minRow+len(counts) <= row {
+			counts = append(counts, 0)
+		}
+		counts[row-minRow] += bar.Count
+	}
+	return minRow, counts
+}
+
+// logRow returns the log spaced row holding value.
+func logRow(value int64, rowsPerDoubling int) int {
+	return int(math.Floor(math.Log2(float64(max(value, 1))) * float64(rowsPerDoubling)))
+}
+
+// logRowValue returns the lowest value of a log spaced row.
+func logRowValue(row, rowsPerDoubling int) float64 {
+	return math.Exp2(float64(row) / float64(rowsPerDoubling))
+}
+
+// span returns the time range of the heatmap, in milliseconds since the epoch.
+func (m *Heatmap) span() (startMs, endMs int64) {
+	startMs, endMs = math.MaxInt64, math.MinInt64
+	for _, c := range m.columns {
+		startMs = min(startMs, c.startTimeMs)
+		endMs = max(endMs, c.endTimeMs)
+	}
+	// Guard against a span of intervals without duration.
+	return startMs, max(endMs, startMs+1)
+}
+
+// grid resamples the heatmap into width by height cells, cells[x][y] with y
+// growing with value, and returns the highest count of any cell. Data
+// spanning several cells is repeated in every one of them, and data sharing a
+// cell adds up.
+func (m *Heatmap) grid(width, height int) (cells [][]int64, maxCount int64) {
+	cells = make([][]int64, width)
+	for x := range cells {
+		cells[x] = make([]int64, height)
+	}
+	if m.minRow > m.maxRow {
+		return cells, 0
+	}
+	startMs, endMs := m.span()
+	spanMs, rows := float64(endMs-startMs), m.maxRow-m.minRow+1
+	scale := func(from, to, n float64, cells int) (int, int) {
+		lo := min(int(from/n*float64(cells)), cells-1)
+		hi := max(lo+1, min(int(math.Ceil(to/n*float64(cells))), cells))
+		return lo, hi
+	}
+	for _, c := range m.columns {
+		x0, x1 := scale(float64(c.startTimeMs-startMs), float64(c.endTimeMs-startMs), spanMs, width)
+		for i, count := range c.counts {
+			if count == 0 {
+				continue
+			}
+			row := float64(c.minRow + i - m.minRow)
+			y0, y1 := scale(row, row+1, float64(rows), height)
+			for x := x0; x < x1; x++ {
+				for y := y0; y < y1; y++ {
+					cells[x][y] += count
+					maxCount = max(maxCount, cells[x][y])
+				}
+			}
+		}
+	}
+	return cells, maxCount
+}
+
+// heatmapLevel returns the level of a cell holding count, from 0 for a single
+// value to 1 for maxCount, with counts on a log scale so sparse outliers
+// remain visible next to the bulk of the values.
+func heatmapLevel(count, maxCount int64) float64 {
+	if maxCount <= 1 {
+		return 1
+	}
+	return math.Log1p(float64(count-1)) / math.Log1p(float64(maxCount-1))
+}
+
+// heatmapColor returns the colour of a cell holding count.
+func heatmapColor(count, maxCount int64) color.RGBA {
+	f := heatmapLevel(count, maxCount) * float64(len(heatmapRamp)-1)
+	i := min(int(f), len(heatmapRamp)-2)
+	f -= float64(i)
+	from, to := heatmapRamp[i], heatmapRamp[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f))
+	}
+	return color.RGBA{mix(from.R, to.R), mix(from.G, to.G), mix(from.B, to.B), 0xff}
+}
+
+// heatmapOptions returns options with the defaults filled in, using the
+// given default size.
+func heatmapOptions(options *HeatmapOptions, width, height int) (HeatmapOptions, error) {
+	var o HeatmapOptions
+	if options != nil {
+		o = *options
+	}
+	if o.Width == 0 {
+		o.Width = width
+	}
+	if o.Height == 0 {
+		o.Height = height
+	}
+	if o.ValueUnitRatio == 0 {
+		o.ValueUnitRatio = 1
+	}
+	if o.Width < 0 || o.Height < 0 || o.ValueUnitRatio < 0 {
+		return o, errors.New("heatmap size and value unit ratio must be positive")
+	}
+	return o, nil
+}
+
+// formatPlotValue formats a value with three significant digits.
+func formatPlotValue(v float64) string {
+	decimals := 0
+	if v > 0 {
+		decimals = max(0, 2-int(math.Floor(math.Log10(v))))
+	}
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// formatHeatmapTime formats a time offset from the start of the heatmap.
+func formatHeatmapTime(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= 10*time.Second {
+		d = d.Round(time.Second)
+	}
+	return d.String()
+}
+
+// plotArea returns the size of the SVG heatmap area.
+func (o *HeatmapOptions) plotArea() (width, height int, err error) {
+	width = o.Width - heatmapMarginLeft - heatmapMarginRight
+	height = o.Height - heatmapMarginTop - heatmapMarginBottom
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("heatmap size leaves no room for the plot")
+	}
+	return width, height, nil
+}
+
+// WriteSVG writes the heatmap as a standalone SVG image, with the time since
+// its first interval along the x axis and the values along the y axis.
+func (m *Heatmap) WriteSVG(w io.Writer, options *HeatmapOptions) error {
+	o, err := heatmapOptions(options, 800, 400)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	if err = m.writeSVG(b, &o); err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// WriteHTML writes the heatmap of WriteSVG wrapped in a standalone HTML page.
+func (m *Heatmap) WriteHTML(w io.Writer, options *HeatmapOptions) error {
+	o, err := heatmapOptions(options, 800, 400)
+	if err != nil {
+		return err
+	}
+	if _, _, err = o.plotArea(); err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	writeHTMLPage(b, o.Title, func(b *bufio.Writer) {
+		// The plot area was checked above, so this can't fail.
+		m.writeSVG(b, &o)
+	})
+	return b.Flush()
+}
+
+func (m *Heatmap) writeSVG(b *bufio.Writer, o *HeatmapOptions) error {
+	plotW, plotH, err := o.plotArea()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n",
+		o.Width, o.Height, o.Width, o.Height)
+	b.WriteString("<rect width=\"100%\" height=\"100%\" fill=\"white\"/>\n")
+	if o.Title != "" {
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"24\" text-anchor=\"middle\" font-size=\"16\">%s</text>\n", o.Width/2, html.EscapeString(o.Title))
+	}
+	fmt.Fprintf(b, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"#ddd\"/>\n", heatmapMarginLeft, heatmapMarginTop, plotW, plotH)
+	if m.minRow > m.maxRow {
+		b.WriteString("</svg>\n")
+		return nil
+	}
+
+	// A cell is at least two pixels wide and high, or a rect per pixel of a
+	// long log would make for a huge image.
+	rows := m.maxRow - m.minRow + 1
+	nx, ny := max(1, min(len(m.columns), plotW/2)), max(1, min(rows, plotH/2))
+	cells, maxCount := m.grid(nx, ny)
+	cellW, cellH := float64(plotW)/float64(nx), float64(plotH)/float64(ny)
+	b.WriteString("<g shape-rendering=\"crispEdges\">\n")
+	for x, column := range cells {
+		for y, count := range column {
+			if count == 0 {
+				continue
+			}
+			c := heatmapColor(count, maxCount)
+			fmt.Fprintf(b, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"#%02x%02x%02x\"><title>%d</title></rect>\n",
+				heatmapMarginLeft+float64(x)*cellW, heatmapMarginTop+float64(plotH)-float64(y+1)*cellH, cellW, cellH, c.R, c.G, c.B, count)
+		}
+	}
+	b.WriteString("</g>\n<g fill=\"#333\">\n")
+
+	// About five labels per axis.
+	step := max(1, rows/5)
+	for row := m.minRow; row <= m.maxRow+1; row += step {
+		y := heatmapMarginTop + float64(plotH) - float64(row-m.minRow)/float64(rows)*float64(plotH)
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", heatmapMarginLeft-6, y+4, formatPlotValue(logRowValue(row, m.rowsPerDoubling)/o.ValueUnitRatio))
+	}
+	startMs, endMs := m.span()
+	for i := 0; i <= 4; i++ {
+		offsetMs := (endMs - startMs) * int64(i) / 4
+		fmt.Fprintf(b, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", heatmapMarginLeft+float64(plotW)*float64(i)/4, heatmapMarginTop+plotH+18, formatHeatmapTime(offsetMs))
+	}
+	yLabel := "Value"
+	if o.ValueUnit != "" {
+		yLabel += " (" + o.ValueUnit + ")"
+	}
+	fmt.Fprintf(b, "<text transform=\"translate(16 %d) rotate(-90)\" text-anchor=\"middle\">%s</text>\n", heatmapMarginTop+plotH/2, html.EscapeString(yLabel))
+	b.WriteString("</g>\n</svg>\n")
+	return nil
+}
+
+// WritePNG writes the heatmap as a PNG image of the given size. The image
+// package can't draw text, so the image has no labels or title, and no
+// margins: every pixel is a cell.
+func (m *Heatmap) WritePNG(w io.Writer, options *HeatmapOptions) error {
+	o, err := heatmapOptions(options, 800, 400)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, o.Width, o.Height))
+	cells, maxCount := m.grid(o.Width, o.Height)
+	for x, column := range cells {
+		for y, count := range column {
+			c := color.RGBA{0xff, 0xff, 0xff, 0xff}
+			if count > 0 {
+				c = heatmapColor(count, maxCount)
+			}
+			img.SetRGBA(x, o.Height-1-y, c)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteANSI writes the heatmap as text for terminals, labelled with the
+// values of its rows, and a last line with the time range. Colour is used as
+// selected by options.Color: every line then shows two rows of cells with
+// half blocks in 24-bit colour escape sequences, or with options.ASCII a row
+// of coloured blanks. Without colour every line shows a row of cells shaded
+// by their counts. Of the options, RowsPerDoubling is left to NewHeatmap.
+func (m *Heatmap) WriteANSI(w io.Writer, options *TerminalOptions) error {
+	t, err := newTerminal(w, options)
+	if err != nil {
+		return err
+	}
+	width, lines := t.Width-heatmapLabelWidth, t.Height-1
+	if lines <= 0 {
+		return errors.New("heatmap size leaves no room for the plot")
+	}
+	b := bufio.NewWriter(w)
+	if m.minRow > m.maxRow {
+		b.WriteString("no values\n")
+		return b.Flush()
+	}
+	rows := m.maxRow - m.minRow + 1
+	perLine := 1
+	if t.color && !t.ASCII {
+		perLine = 2
+	}
+	// Don't stretch a few rows over the whole height.
+	lines = min(lines, (rows+perLine-1)/perLine)
+	cells, maxCount := m.grid(width, perLine*lines)
+	for line := lines - 1; line >= 0; line-- {
+		// The lowest value of the line's lower row.
+		row := m.minRow + perLine*line*rows/(perLine*lines)
+		fmt.Fprintf(b, "%*s ", heatmapLabelWidth-1, t.value(logRowValue(row, m.rowsPerDoubling)))
+		for x := range cells {
+			if perLine == 2 {
+				writeHalfBlockCell(b, cells[x][2*line+1], cells[x][2*line], maxCount)
+			} else {
+				t.writeHeatmapCell(b, cells[x][line], maxCount)
+			}
+		}
+		if t.color {
+			b.WriteString(ansiReset)
+		}
+		b.WriteString("\n")
+	}
+	startMs, endMs := m.span()
+	end := formatHeatmapTime(endMs - startMs)
+	fmt.Fprintf(b, "%*s %-*s%s", heatmapLabelWidth-1, t.ValueUnit, max(0, width-len(end)), "0s", end)
+	b.WriteString("\n")
+	return b.Flush()
+}
+
+// writeHalfBlockCell writes a character cell showing the cells of two rows,
+// the upper one in the background and the lower one in a half block.
+func writeHalfBlockCell(b *bufio.Writer, upper, lower, maxCount int64) {
+	switch {
+	case upper == 0 && lower == 0:
+		b.WriteString(ansiReset + " ")
+		return
+	case upper == 0:
+		b.WriteString("\x1b[49m")
+	default:
+		c := heatmapColor(upper, maxCount)
+		fmt.Fprintf(b, "\x1b[48;2;%d;%d;%dm", c.R, c.G, c.B)
+	}
+	if lower == 0 {
+		b.WriteString("\x1b[39m ")
+		return
+	}
+	c := heatmapColor(lower, maxCount)
+	fmt.Fprintf(b, "\x1b[38;2;%d;%d;%dm▄", c.R, c.G, c.B)
+}
+
+// writeHeatmapCell writes a character cell showing a single cell, as a
+// coloured blank or shaded by its count.
+func (t *terminal) writeHeatmapCell(b *bufio.Writer, count, maxCount int64) {
+	if t.color {
+		if count == 0 {
+			b.WriteString("\x1b[49m ")
+			return
+		}
+		c := heatmapColor(count, maxCount)
+		fmt.Fprintf(b, "\x1b[48;2;%d;%d;%dm ", c.R, c.G, c.B)
+		return
+	}
+	shades := heatmapShades
+	if t.ASCII {
+		shades = asciiHeatmapShades
+	}
+	if count == 0 {
+		b.WriteRune(shades[0])
+		return
+	}
+	// Any count shows, with the darkest shade for the highest.
+	b.WriteRune(shades[1+int(heatmapLevel(count, maxCount)*float64(len(shades)-2)+0.5)])
+}
@@ -0,0 +1,127 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bimodalHeatmap returns a heatmap of ten one second intervals recording 1000
+// values around 100 and, in the last five, 10 values around 10000.
+func bimodalHeatmap(t *testing.T) *Heatmap {
+	m := NewHeatmap(0)
+	for i := int64(0); i < 10; i++ {
+		h := New(1, 1000000, 3)
+		assert.Nil(t, h.RecordValues(100, 1000))
+		if i >= 5 {
+			assert.Nil(t, h.RecordValues(10000, 10))
+		}
+		h.SetStartTimeMs(1000000 + i*1000)
+		h.SetEndTimeMs(1000000 + (i+1)*1000)
+		m.Add(h)
+	}
+	return m
+}
+
+func TestHeatmap_grid(t *testing.T) {
+	m := bimodalHeatmap(t)
+	// log2(100) and log2(10000) in quarters of a doubling
+	assert.Equal(t, 26, m.minRow)
+	assert.Equal(t, 53, m.maxRow)
+	cells, maxCount := m.grid(10, 28)
+	assert.Equal(t, int64(1000), maxCount)
+	for x, column := range cells {
+		assert.Equal(t, int64(1000), column[0])
+		if x < 5 {
+			assert.Equal(t, int64(0), column[27])
+		} else {
+			assert.Equal(t, int64(10), column[27])
+		}
+	}
+	// resampling to fewer cells adds up, to more repeats
+	cells, _ = m.grid(5, 1)
+	assert.Equal(t, int64(2000), cells[0][0])
+	assert.Equal(t, int64(2020), cells[4][0])
+	cells, _ = m.grid(20, 56)
+	assert.Equal(t, int64(1000), cells[0][1])
+	assert.Equal(t, int64(10), cells[19][55])
+
+	assert.Equal(t, heatmapRamp[0], heatmapColor(1, 1000))
+	assert.Equal(t, heatmapRamp[len(heatmapRamp)-1], heatmapColor(1000, 1000))
+}
+
+func TestHeatmap_WriteSVG(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, bimodalHeatmap(t).WriteSVG(&b, &HeatmapOptions{Title: "p & q", ValueUnitRatio: 1000, ValueUnit: "ms"}))
+	svg := b.String()
+	elements := svgElements(t, svg)
+	// the background, the border, and a cell per interval and mode, as there
+	// are fewer intervals than pixels
+	assert.Equal(t, 2+10+5, len(elements["rect"]))
+	for _, label := range []string{"p &amp; q", "Value (ms)", ">0.0905<", ">0s<", ">10s<"} {
+		assert.Contains(t, svg, label)
+	}
+
+	b.Reset()
+	assert.Nil(t, NewHeatmap(4).WriteHTML(&b, nil))
+	assert.True(t, strings.HasPrefix(b.String(), "<!DOCTYPE html>"))
+	assert.Contains(t, b.String(), "</svg>")
+	assert.NotNil(t, NewHeatmap(4).WriteSVG(&b, &HeatmapOptions{Height: 50}))
+}
+
+func TestHeatmap_WritePNG(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, bimodalHeatmap(t).WritePNG(&b, &HeatmapOptions{Width: 100, Height: 56}))
+	img, err := png.Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+	assert.Equal(t, 56, img.Bounds().Dy())
+	white := func(x, y int) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return r == 0xffff && g == 0xffff && b == 0xffff
+	}
+	// the low mode at the bottom throughout, the high one at the top late on
+	assert.False(t, white(0, 55))
+	assert.False(t, white(99, 55))
+	assert.True(t, white(0, 0))
+	assert.False(t, white(99, 0))
+	assert.True(t, white(50, 30))
+}
+
+func TestHeatmap_WriteANSI(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, bimodalHeatmap(t).WriteANSI(&b, &TerminalOptions{Width: 40, Height: 10, Color: ColorAlways, ValueUnit: "ns"}))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert.Equal(t, 10, len(lines))
+	assert.Contains(t, lines[0], "\x1b[48;2;")
+	assert.True(t, strings.HasSuffix(lines[len(lines)-1], "10s"))
+	assert.True(t, strings.HasPrefix(lines[len(lines)-1], "       ns 0s"))
+
+	// without colour, as when not writing to a terminal, cells are shaded
+	for _, ascii := range []bool{false, true} {
+		b.Reset()
+		assert.Nil(t, bimodalHeatmap(t).WriteANSI(&b, &TerminalOptions{Width: 40, Height: 10, ASCII: ascii}))
+		assert.NotContains(t, b.String(), "\x1b")
+		lines = strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		assert.Equal(t, 10, len(lines))
+		for _, line := range lines[:9] {
+			assert.Equal(t, 40, utf8.RuneCountInString(line))
+		}
+		shades := "░▒▓█"
+		if ascii {
+			shades = ".:+#"
+			assert.NotContains(t, b.String(), "░")
+		}
+		assert.True(t, strings.ContainsAny(b.String(), shades))
+	}
+
+	b.Reset()
+	assert.Nil(t, NewHeatmap(4).WriteANSI(&b, nil))
+	assert.Equal(t, "no values\n", b.String())
+	assert.NotNil(t, NewHeatmap(4).WriteANSI(&b, &TerminalOptions{Width: 5}))
+	assert.NotNil(t, NewHeatmap(4).WriteANSI(&b, &TerminalOptions{Height: 1}))
+}
@@ -0,0 +1,115 @@
+package hdrhistogram
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// IntervalLoggerOptions configures an IntervalLogger.
+type IntervalLoggerOptions struct {
+	// Tag, if not empty, tags every logged interval.
+	Tag string
+	// Now returns the current time, which starts the first interval and ends
+	// the last. It defaults to time.Now.
+	Now func() time.Time
+	// Ticks, if not nil, is used in place of a ticker firing every interval:
+	// each time received ends an interval. Together with Now it makes the
+	// logger deterministic under test.
+	Ticks <-chan time.Time
+}
+
+// An IntervalLogger periodically takes an interval histogram from a source,
+// such as a Recorder, stamps it with the interval's start and end times and
+// logs it to a HistogramLogWriter. It replaces the hand written loop of
+// ticker, swap, SetStartTimeMs/SetEndTimeMs, OutputIntervalHistogram and
+// reset.
+//
+// The log header is left to the caller, typically written with
+// OutputLogHeader before the logger is started.
+type IntervalLogger struct {
+	source   IntervalSource
+	writer   *HistogramLogWriter
+	interval time.Duration
+	options  IntervalLoggerOptions
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// NewIntervalLogger returns a logger that logs the intervals of source to
+// writer every interval. options may be nil.
+func NewIntervalLogger(source IntervalSource, writer *HistogramLogWriter, interval time.Duration, options *IntervalLoggerOptions) *IntervalLogger {
+	l := &IntervalLogger{source: source, writer: writer, interval: interval}
+	if options != nil {
+		l.options = *options
+	}
+	if l.options.Now == nil {
+		l.options.Now = time.Now
+	}
+	return l
+}
+
+// Run logs an interval every tick until ctx is done, then logs the final,
+// partial interval and flushes the writer. Values recorded before Run is
+// called are part of the first interval. Run returns the first error met
+// writing the log, which stops the logger, or nil once ctx is done.
+func (l *IntervalLogger) Run(ctx context.Context) (err error) {
+	ticks := l.options.Ticks
+	if ticks == nil {
+		if l.interval <= 0 {
+			return errors.New("interval logger needs a positive interval")
+		}
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	start := l.options.Now()
+	for {
+		select {
+		case end := <-ticks:
+			if err = l.logInterval(start, end); err != nil {
+				return
+			}
+			start = end
+		case <-ctx.Done():
+			if err = l.logInterval(start, l.options.Now()); err != nil {
+				return
+			}
+			return l.writer.Flush()
+		}
+	}
+}
+
+func (l *IntervalLogger) logInterval(start, end time.Time) error {
+	h := l.source.IntervalHistogram()
+	h.SetStartTimeMs(start.UnixMilli())
+	h.SetEndTimeMs(end.UnixMilli())
+	if l.options.Tag != "" {
+		h.SetTag(l.options.Tag)
+	}
+	return l.writer.OutputIntervalHistogram(h)
+}
+
+// Start runs the logger in the background until ctx is done or Stop is
+// called. A logger can only be started once.
+func (l *IntervalLogger) Start(ctx context.Context) {
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		l.err = l.Run(ctx)
+	}()
+}
+
+// Stop stops a logger started with Start, waiting for the final interval to
+// be logged, and returns the error that stopped it, if any.
+func (l *IntervalLogger) Stop() error {
+	if l.cancel == nil {
+		return nil
+	}
+	l.cancel()
+	<-l.done
+	return l.err
+}
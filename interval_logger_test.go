@@ -0,0 +1,103 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// notifyingWriter collects log lines and signals each write.
+type notifyingWriter struct {
+	mu      sync.Mutex
+	b       bytes.Buffer
+	written chan struct{}
+}
+
+func (w *notifyingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	n, err := w.b.Write(p)
+	w.mu.Unlock()
+	w.written <- struct{}{}
+	return n, err
+}
+
+func (w *notifyingWriter) intervals(t *testing.T) []*Histogram {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(w.b.Bytes())))
+}
+
+func TestIntervalLogger(t *testing.T) {
+	out := &notifyingWriter{written: make(chan struct{}, 1)}
+	recorder := NewRecorder(1, 1000, 3)
+	ticks := make(chan time.Time)
+	t0 := time.UnixMilli(1441812279000)
+	var mu sync.Mutex
+	now := t0
+	logger := NewIntervalLogger(recorder, NewHistogramLogWriter(out), time.Second, &IntervalLoggerOptions{
+		Tag: "svc",
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+		Ticks: ticks,
+	})
+
+	assert.Nil(t, recorder.RecordValue(1))
+	logger.Start(context.Background())
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, recorder.RecordValues(int64(i*10), int64(i)))
+		ticks <- t0.Add(time.Duration(i) * time.Second)
+		<-out.written
+	}
+	assert.Nil(t, recorder.RecordValue(99))
+	mu.Lock()
+	now = t0.Add(3500 * time.Millisecond)
+	mu.Unlock()
+	go func() {
+		for range out.written {
+		}
+	}()
+	assert.Nil(t, logger.Stop())
+	close(out.written)
+
+	got := out.intervals(t)
+	assert.Equal(t, 4, len(got))
+	assert.Equal(t, []int64{2, 2, 3, 1}, []int64{got[0].TotalCount(), got[1].TotalCount(), got[2].TotalCount(), got[3].TotalCount()})
+	for i, h := range got {
+		assert.Equal(t, "svc", h.Tag())
+		assert.Equal(t, t0.Add(time.Duration(i)*time.Second).UnixMilli(), h.StartTimeMs())
+	}
+	assert.Equal(t, int64(30), got[2].Max())
+	// the final, partial interval ends when the logger stops
+	assert.Equal(t, now.UnixMilli(), got[3].EndTimeMs())
+	assert.Equal(t, int64(99), got[3].Max())
+}
+
+func TestIntervalLogger_writeError(t *testing.T) {
+	writer := NewHistogramLogWriter(&bytes.Buffer{})
+	assert.Nil(t, writer.Close())
+	ticks := make(chan time.Time, 1)
+	ticks <- time.Now()
+	logger := NewIntervalLogger(NewRecorder(1, 1000, 3), writer, time.Second, &IntervalLoggerOptions{Ticks: ticks})
+	assert.Equal(t, errLogWriterClosed, logger.Run(context.Background()))
+}
+
+func TestIntervalLogger_Run(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewIntervalLogger(NewRecorder(1, 1000, 3), NewBufferedHistogramLogWriter(&b, 0), 0, nil)
+	assert.NotNil(t, logger.Run(context.Background()))
+	assert.Nil(t, logger.Stop())
+
+	// a real ticker, stopped by the context; the final interval is flushed
+	logger = NewIntervalLogger(NewRecorder(1, 1000, 3), NewBufferedHistogramLogWriter(&b, 0), time.Millisecond, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, logger.Run(ctx))
+	assert.NotEmpty(t, drainAllIntervals(t, NewHistogramLogReader(&b)))
+}
@@ -0,0 +1,184 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultFollowPollInterval is the poll interval of NewHistogramLogFollower
+// when given none.
+const defaultFollowPollInterval = 100 * time.Millisecond
+
+// NewHistogramLogFollower opens the log file at path and returns a reader that
+// follows it as it grows, like tail -f. Instead of reporting the end of the
+// log, reads block until more complete lines have been appended, checking the
+// file every pollInterval, or every 100ms if it is zero. A line is only read once its terminating newline
+// has been written, so intervals are never decoded from a partial write.
+//
+// If the file is truncated, or replaced by a new file at path as log rotation
+// does, the reader drains what was written to the old file and starts over at
+// the beginning of the new one, resetting the header and times it had parsed.
+//
+// Reads block until ctx is done, after which they fail with ctx.Err(). Call
+// Close to release the file. Compressed logs cannot be followed.
+func NewHistogramLogFollower(ctx context.Context, path string, pollInterval time.Duration) (*HistogramLogReader, error) {
+	if pollInterval < 0 {
+		return nil, errors.New("log follower poll interval cannot be negative")
+	}
+	if pollInterval == 0 {
+		pollInterval = defaultFollowPollInterval
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src := &followSource{ctx: ctx, path: path, pollInterval: pollInterval, file: file}
+	hlr := NewHistogramLogReader(src)
+	hlr.compressionChecked = true
+	src.restart = hlr.restartLog
+	hlr.closer = src
+	return hlr, nil
+}
+
+// Close releases the file of a reader returned by NewHistogramLogFollower. It
+// does nothing for other readers.
+func (hlr *HistogramLogReader) Close() error {
+	if hlr.closer == nil {
+		return nil
+	}
+	return hlr.closer.Close()
+}
+
+// restartLog forgets the header and times parsed so far, before reading a new
+// log from its beginning.
+func (hlr *HistogramLogReader) restartLog() {
+	hlr.startTimeSec, hlr.observedStartTime = 0.0, false
+	hlr.baseTimeSec, hlr.observedBaseTime = 0.0, false
+	hlr.header = LogHeader{}
+	hlr.lineNumber, hlr.nextLineOffset = 0, 0
+}
+
+// followSource is an io.Reader over a growing log file that only hands out
+// complete lines, and blocks rather than returning io.EOF.
+type followSource struct {
+	ctx          context.Context
+	path         string
+	pollInterval time.Duration
+	file         *os.File
+	// restart is called when the source switches to a new or truncated file.
+	restart func()
+
+	// offset is the number of bytes of the file read into buf.
+	// buf[start:complete] holds the complete lines not yet handed out, and
+	// buf[complete:] the partial line that follows them.
+	offset   int64
+	buf      []byte
+	start    int
+	complete int
+}
+
+func (s *followSource) Read(p []byte) (n int, err error) {
+	for {
+		if err = s.ctx.Err(); err != nil {
+			return 0, err
+		}
+		if s.start < s.complete {
+			n = copy(p, s.buf[s.start:s.complete])
+			s.start += n
+			return n, nil
+		}
+		grew, err := s.readAvailable()
+		if err != nil {
+			return 0, err
+		}
+		if grew {
+			continue
+		}
+		switched, err := s.checkFile()
+		if err != nil {
+			return 0, err
+		}
+		if switched {
+			continue
+		}
+		timer := time.NewTimer(s.pollInterval)
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+		}
+	}
+}
+
+// readAvailable reads what has been written to the file since the last read,
+// stopping once buf holds a complete line, and reports whether it does.
+func (s *followSource) readAvailable() (grew bool, err error) {
+	// Drop the lines already handed out to make room.
+	s.buf = s.buf[:copy(s.buf, s.buf[s.start:])]
+	s.complete -= s.start
+	s.start = 0
+	for {
+		if len(s.buf) == cap(s.buf) {
+			s.buf = append(s.buf, make([]byte, 32*1024)...)[:len(s.buf)]
+		}
+		n, err := s.file.Read(s.buf[len(s.buf):cap(s.buf)])
+		read := s.buf[len(s.buf) : len(s.buf)+n]
+		s.buf = s.buf[:len(s.buf)+n]
+		s.offset += int64(n)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if err == io.EOF || n == 0 || bytes.IndexByte(read, '\n') >= 0 {
+			break
+		}
+	}
+	complete := bytes.LastIndexByte(s.buf, '\n') + 1
+	grew = complete > s.complete
+	s.complete = complete
+	return
+}
+
+// checkFile detects the truncation or replacement of the followed file, and
+// switches to reading the new content from its start. A missing file is
+// waited for.
+func (s *followSource) checkFile() (switched bool, err error) {
+	current, err := s.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if current.Size() < s.offset {
+		if _, err = s.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		s.reset()
+		return true, nil
+	}
+	latest, err := os.Stat(s.path)
+	if err != nil || os.SameFile(current, latest) {
+		return false, nil
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return false, nil
+	}
+	s.file.Close()
+	s.file = file
+	s.reset()
+	return true, nil
+}
+
+func (s *followSource) reset() {
+	// A partial line left at the end of the old content is never completed.
+	s.offset = 0
+	s.buf = s.buf[:0]
+	s.start, s.complete = 0, 0
+	s.restart()
+}
+
+func (s *followSource) Close() error {
+	return s.file.Close()
+}
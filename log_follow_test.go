@@ -0,0 +1,154 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// followedLog returns the text of a log starting at startMs with intervals
+// recording the given values, one second apart.
+func followedLog(t *testing.T, startMs int64, values ...int64) []byte {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputStartTime(startMs))
+	assert.Nil(t, writer.OutputLegend())
+	for i, v := range values {
+		h := New(1, 1000, 3)
+		assert.Nil(t, h.RecordValue(v))
+		h.SetStartTimeMs(startMs + int64(i)*1000)
+		h.SetEndTimeMs(startMs + int64(i+1)*1000)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+	return b.Bytes()
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func nextFollowed(t *testing.T, reader *HistogramLogReader) *Histogram {
+	h, err := reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assert.NotNil(t, h)
+	return h
+}
+
+func TestHistogramLogFollower_partialLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "follow.hlog")
+	log := followedLog(t, 1000000, 1, 2, 3)
+	// StartTime, legend and the first interval, then part of the second
+	lines := bytes.SplitAfter(log, []byte("\n"))
+	second := len(bytes.Join(lines[:3], nil))
+	assert.Nil(t, os.WriteFile(path, log[:second+10], 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, err := NewHistogramLogFollower(ctx, path, time.Millisecond)
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, int64(1), nextFollowed(t, reader).Max())
+	go func() {
+		// complete the second line in two more writes, then add the third
+		time.Sleep(20 * time.Millisecond)
+		appendFile(t, path, log[second+10:second+20])
+		time.Sleep(20 * time.Millisecond)
+		appendFile(t, path, log[second+20:])
+	}()
+	assert.Equal(t, int64(2), nextFollowed(t, reader).Max())
+	third := nextFollowed(t, reader)
+	assert.Equal(t, int64(3), third.Max())
+	assert.Equal(t, int64(1002000), third.StartTimeMs())
+	assert.Equal(t, int64(3), reader.AcceptedLines())
+	assert.Equal(t, int64(0), reader.SkippedLines())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	h, err := reader.NextIntervalHistogram()
+	assert.Nil(t, h)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestHistogramLogFollower_truncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "follow.hlog")
+	assert.Nil(t, os.WriteFile(path, followedLog(t, 1000000, 1, 2), 0o644))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reader, err := NewHistogramLogFollower(ctx, path, time.Millisecond)
+	assert.Nil(t, err)
+	defer reader.Close()
+	nextFollowed(t, reader)
+	nextFollowed(t, reader)
+
+	assert.Nil(t, os.WriteFile(path, followedLog(t, 5000000, 7), 0o644))
+	h := nextFollowed(t, reader)
+	assert.Equal(t, int64(7), h.Max())
+	assert.Equal(t, int64(5000000), h.StartTimeMs())
+	assert.Equal(t, 5000.0, reader.Header().StartTimeSec)
+}
+
+func TestHistogramLogFollower_rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "follow.hlog")
+	assert.Nil(t, os.WriteFile(path, followedLog(t, 1000000, 1), 0o644))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reader, err := NewHistogramLogFollower(ctx, path, time.Millisecond)
+	assert.Nil(t, err)
+	defer reader.Close()
+	nextFollowed(t, reader)
+
+	// the old file gains a last interval before it is rotated away
+	old := followedLog(t, 1000000, 1, 2)
+	assert.Nil(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", old[len(followedLog(t, 1000000, 1)):])
+	assert.Nil(t, os.WriteFile(path, followedLog(t, 9000000, 9), 0o644))
+
+	assert.Equal(t, int64(2), nextFollowed(t, reader).Max())
+	h := nextFollowed(t, reader)
+	assert.Equal(t, int64(9), h.Max())
+	assert.Equal(t, int64(9000000), h.StartTimeMs())
+}
+
+func TestHistogramLogFollower_readError(t *testing.T) {
+	// A directory opens, but every read of it fails while it stays in place,
+	// so the error must be returned rather than polled on until ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reader, err := NewHistogramLogFollower(ctx, t.TempDir(), time.Millisecond)
+	assert.Nil(t, err)
+	defer reader.Close()
+	_, err = reader.NextIntervalHistogram()
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestHistogramLogFollower_pollInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "followed.hlog")
+	assert.Nil(t, os.WriteFile(path, nil, 0o644))
+	_, err := NewHistogramLogFollower(context.Background(), path, -time.Millisecond)
+	assert.NotNil(t, err)
+
+	// zero polls at the default rather than spinning
+	reader, err := NewHistogramLogFollower(context.Background(), path, 0)
+	assert.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, defaultFollowPollInterval, reader.closer.(*followSource).pollInterval)
+}
+
+func TestHistogramLogReader_CloseWithoutFile(t *testing.T) {
+	assert.Nil(t, NewHistogramLogReader(bytes.NewReader(nil)).Close())
+}
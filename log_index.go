@@ -0,0 +1,373 @@
+package hdrhistogram
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A LogIndex records where each interval line of a histogram log starts,
+// together with its tag and absolute time range, so that time range queries can
+// seek straight to the lines they need instead of scanning the log from the
+// beginning. An index is built with BuildLogIndex and can be saved next to the
+// log with WriteTo and loaded back with ReadLogIndex.
+type LogIndex struct {
+	// Size is the length in bytes of the indexed log. Anything appended to the
+	// log after it was indexed is not covered by the index.
+	Size int64
+	// StartTimeSec and BaseTimeSec are the log start and base times, in seconds
+	// since the epoch, as resolved while indexing. Interval timestamps in the
+	// log are relative to BaseTimeSec.
+	StartTimeSec float64
+	BaseTimeSec  float64
+	// Intervals holds one record per interval line, in log order. It must not
+	// be modified once the index is in use.
+	Intervals []LogIndexInterval
+
+	// sorted reports whether Intervals is ordered by start time, which lets
+	// queries binary search for the start of their range.
+	sorted bool
+}
+
+// A LogIndexInterval locates a single interval line of an indexed log.
+type LogIndexInterval struct {
+	// Tag is the interval's tag, or empty for an untagged line.
+	Tag string
+	// StartTimeSec and EndTimeSec bound the interval in seconds since the epoch.
+	StartTimeSec float64
+	EndTimeSec   float64
+	// Line is the 1-based line number of the interval, Offset the byte offset
+	// of its start and Length its length in bytes, including the line terminator.
+	Line   int64
+	Offset int64
+	Length int64
+}
+
+// StartTime returns the absolute start time of the interval.
+func (iv *LogIndexInterval) StartTime() time.Time {
+	return secondsToTime(iv.StartTimeSec)
+}
+
+// BuildLogIndex reads a histogram log to the end and indexes its interval
+// lines. Payloads are not decoded. Unrecognised lines are skipped; a line whose
+// timestamps cannot be parsed fails the build with a *LogParseError.
+func BuildLogIndex(log io.Reader) (index *LogIndex, err error) {
+	return NewHistogramLogReader(log).buildIndex()
+}
+
+func (hlr *HistogramLogReader) buildIndex() (index *LogIndex, err error) {
+	hlr.rangeStartTimeSec = 0.0
+	hlr.rangeEndTimeSec = math.MaxFloat64
+	hlr.absolute = true
+	index = &LogIndex{}
+	for {
+		var entry *LogEntry
+		entry, _, err = hlr.scanInterval(func(*LogParseError) {})
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		index.Intervals = append(index.Intervals, LogIndexInterval{
+			Tag:          entry.Tag,
+			StartTimeSec: entry.AbsoluteStartTimeSec,
+			EndTimeSec:   entry.AbsoluteEndTimeSec,
+			Line:         hlr.lineNumber,
+			Offset:       hlr.lineOffset,
+			Length:       hlr.nextLineOffset - hlr.lineOffset,
+		})
+	}
+	index.Size = hlr.nextLineOffset
+	index.StartTimeSec = hlr.startTimeSec
+	index.BaseTimeSec = hlr.baseTimeSec
+	index.finish()
+	return index, nil
+}
+
+func (index *LogIndex) finish() {
+	index.sorted = sort.SliceIsSorted(index.Intervals, func(i, j int) bool {
+		return index.Intervals[i].StartTimeSec < index.Intervals[j].StartTimeSec
+	})
+}
+
+// search returns the position of the first interval that may start at or
+// after start.
+func (index *LogIndex) search(start time.Time) int {
+	if !index.sorted {
+		return 0
+	}
+	return sort.Search(len(index.Intervals), func(i int) bool {
+		return !index.Intervals[i].StartTime().Before(start)
+	})
+}
+
+const (
+	logIndexVersionPrefix = "#[Histogram log index version "
+	logIndexVersion       = "1"
+	logIndexSizePrefix    = "#[LogSize: "
+	logIndexStartPrefix   = "#[StartTime: "
+	logIndexBasePrefix    = "#[BaseTime: "
+	logIndexLegend        = `"Line","Offset","Length","StartTimestamp","EndTimestamp","Tag"`
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the index in a line based text format, which ReadLogIndex
+// reads back. Times are written with enough digits to round trip exactly.
+func (index *LogIndex) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	fmt.Fprintf(bw, "%s%s]\n", logIndexVersionPrefix, logIndexVersion)
+	fmt.Fprintf(bw, "%s%d]\n", logIndexSizePrefix, index.Size)
+	fmt.Fprintf(bw, "%s%s]\n", logIndexStartPrefix, strconv.FormatFloat(index.StartTimeSec, 'f', -1, 64))
+	fmt.Fprintf(bw, "%s%s]\n", logIndexBasePrefix, strconv.FormatFloat(index.BaseTimeSec, 'f', -1, 64))
+	fmt.Fprintf(bw, "%s\n", logIndexLegend)
+	var line []byte
+	for _, iv := range index.Intervals {
+		line = strconv.AppendInt(line[:0], iv.Line, 10)
+		line = append(line, ',')
+		line = strconv.AppendInt(line, iv.Offset, 10)
+		line = append(line, ',')
+		line = strconv.AppendInt(line, iv.Length, 10)
+		line = append(line, ',')
+		line = strconv.AppendFloat(line, iv.StartTimeSec, 'f', -1, 64)
+		line = append(line, ',')
+		line = strconv.AppendFloat(line, iv.EndTimeSec, 'f', -1, 64)
+		line = append(line, ',')
+		line = append(line, iv.Tag...)
+		line = append(line, '\n')
+		bw.Write(line)
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ReadLogIndex reads an index written by LogIndex.WriteTo.
+func ReadLogIndex(r io.Reader) (index *LogIndex, err error) {
+	index = &LogIndex{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, logIndexVersionPrefix):
+			if version := strings.TrimSuffix(line[len(logIndexVersionPrefix):], "]"); version != logIndexVersion {
+				return nil, fmt.Errorf("histogram log index version %s is not supported", version)
+			}
+		case strings.HasPrefix(line, logIndexSizePrefix):
+			index.Size, err = strconv.ParseInt(strings.TrimSuffix(line[len(logIndexSizePrefix):], "]"), 10, 64)
+		case strings.HasPrefix(line, logIndexStartPrefix):
+			index.StartTimeSec, err = strconv.ParseFloat(strings.TrimSuffix(line[len(logIndexStartPrefix):], "]"), 64)
+		case strings.HasPrefix(line, logIndexBasePrefix):
+			index.BaseTimeSec, err = strconv.ParseFloat(strings.TrimSuffix(line[len(logIndexBasePrefix):], "]"), 64)
+		case line == logIndexLegend, line == "":
+		default:
+			var iv LogIndexInterval
+			iv, err = parseLogIndexInterval(line)
+			index.Intervals = append(index.Intervals, iv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("histogram log index line %d: %w", lineNumber, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	index.finish()
+	return index, nil
+}
+
+func parseLogIndexInterval(line string) (iv LogIndexInterval, err error) {
+	fields := strings.SplitN(line, ",", 6)
+	if len(fields) != 6 {
+		return iv, fmt.Errorf("expected 6 fields, got %d", len(fields))
+	}
+	if iv.Line, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return
+	}
+	if iv.Offset, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return
+	}
+	if iv.Length, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return
+	}
+	if iv.StartTimeSec, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return
+	}
+	if iv.EndTimeSec, err = strconv.ParseFloat(fields[4], 64); err != nil {
+		return
+	}
+	iv.Tag = fields[5]
+	return
+}
+
+// A SeekableLogReader answers time range queries over a histogram log held in
+// an io.ReaderAt, such as an *os.File or a *bytes.Reader, using a LogIndex to
+// read and decode only the interval lines that fall within the range. The log
+// must not be compressed.
+//
+// A SeekableLogReader is not safe for concurrent use.
+type SeekableLogReader struct {
+	log    io.ReaderAt
+	index  *LogIndex
+	header LogHeader
+	// parser holds the resolved log times and the tag filter, and parses the
+	// lines read from the log.
+	parser *HistogramLogReader
+	buf    []byte
+}
+
+// NewSeekableLogReader indexes the log with a single pass over it, without
+// decoding any payloads, and returns a reader over it.
+func NewSeekableLogReader(log io.ReaderAt) (*SeekableLogReader, error) {
+	if err := checkUncompressed(log); err != nil {
+		return nil, err
+	}
+	hlr := NewHistogramLogReader(io.NewSectionReader(log, 0, math.MaxInt64))
+	index, err := hlr.buildIndex()
+	if err != nil {
+		return nil, err
+	}
+	return newSeekableLogReader(log, index, hlr.header), nil
+}
+
+// NewSeekableLogReaderWithIndex returns a reader over the log using a
+// previously built index, typically loaded with ReadLogIndex. Only the header
+// lines preceding the first interval are read.
+func NewSeekableLogReaderWithIndex(log io.ReaderAt, index *LogIndex) (*SeekableLogReader, error) {
+	if err := checkUncompressed(log); err != nil {
+		return nil, err
+	}
+	hlr := NewHistogramLogReader(io.NewSectionReader(log, 0, index.Size))
+	header, err := hlr.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	return newSeekableLogReader(log, index, *header), nil
+}
+
+// checkUncompressed rejects gzip compressed logs, whose lines cannot be read
+// at random.
+func checkUncompressed(log io.ReaderAt) error {
+	magic := make([]byte, len(gzipMagic))
+	if n, _ := log.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+		return fmt.Errorf("a gzip compressed histogram log cannot be read at random")
+	}
+	return nil
+}
+
+func newSeekableLogReader(log io.ReaderAt, index *LogIndex, header LogHeader) *SeekableLogReader {
+	parser := NewHistogramLogReader(nil)
+	parser.startTimeSec = index.StartTimeSec
+	parser.observedStartTime = true
+	parser.baseTimeSec = index.BaseTimeSec
+	parser.observedBaseTime = true
+	parser.rangeStartTimeSec = -math.MaxFloat64
+	parser.rangeEndTimeSec = math.MaxFloat64
+	parser.absolute = true
+	return &SeekableLogReader{log: log, index: index, header: header, parser: parser}
+}
+
+// Index returns the index the reader uses.
+func (r *SeekableLogReader) Index() *LogIndex {
+	return r.index
+}
+
+// Header returns the log header metadata.
+func (r *SeekableLogReader) Header() *LogHeader {
+	return &r.header
+}
+
+// SetTagFilter restricts the intervals returned by later queries to those
+// accepted by filter. A nil filter accepts every interval.
+func (r *SeekableLogReader) SetTagFilter(filter *TagFilter) {
+	r.parser.SetTagFilter(filter)
+}
+
+// Entries returns an iterator over the intervals whose start time falls within
+// [start, end], in log order. A zero start or end leaves that side of the range
+// open. Iteration stops after the first error, which is yielded with a nil
+// entry.
+func (r *SeekableLogReader) Entries(start, end time.Time) iter.Seq2[*LogEntry, error] {
+	return func(yield func(*LogEntry, error) bool) {
+		intervals := r.index.Intervals
+		for i := r.index.search(start); i < len(intervals); i++ {
+			iv := &intervals[i]
+			t := iv.StartTime()
+			if !end.IsZero() && t.After(end) {
+				if r.index.sorted {
+					return
+				}
+				continue
+			}
+			if t.Before(start) || !r.parser.tagFilter.accepts(iv.Tag) {
+				continue
+			}
+			entry, err := r.readInterval(iv)
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// AccumulateByTag merges the intervals whose start time falls within
+// [start, end] into one histogram per tag, as HistogramLogReader.AccumulateByTag
+// does.
+func (r *SeekableLogReader) AccumulateByTag(start, end time.Time) (accumulated map[string]*Histogram, err error) {
+	accumulated = make(map[string]*Histogram)
+	for entry, err := range r.Entries(start, end) {
+		if err != nil {
+			return accumulated, err
+		}
+		accumulated[entry.Tag] = AccumulateInterval(accumulated[entry.Tag], entry.Histogram)
+	}
+	return accumulated, nil
+}
+
+// readInterval reads and decodes the interval line located by iv.
+func (r *SeekableLogReader) readInterval(iv *LogIndexInterval) (entry *LogEntry, err error) {
+	if int64(cap(r.buf)) < iv.Length {
+		r.buf = make([]byte, iv.Length)
+	}
+	line := r.buf[:iv.Length]
+	n, err := r.log.ReadAt(line, iv.Offset)
+	if n == len(line) {
+		err = nil
+	}
+	if err == nil {
+		tok := &r.parser.tok
+		tokenizeLogLine(line, tok)
+		switch {
+		case tok.kind != logLineInterval, string(tok.tag) != iv.Tag:
+			err = fmt.Errorf("the log no longer matches its index")
+		default:
+			if entry, _, err = r.parser.parseInterval(iv.Tag, tok); err == nil {
+				err = entry.decodeHistogram(tok.payload)
+			}
+		}
+	}
+	if err != nil {
+		return nil, &LogParseError{Line: iv.Line, Offset: iv.Offset, Err: err}
+	}
+	return entry, nil
+}
@@ -0,0 +1,143 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReaderAt counts the bytes read through it.
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestBuildLogIndex(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	index, err := BuildLogIndex(bytes.NewReader(dat))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(dat)), index.Size)
+	assert.Equal(t, 1441812279.474, index.StartTimeSec)
+	// interval timestamps are relative, so the base time is the start time
+	assert.Equal(t, index.StartTimeSec, index.BaseTimeSec)
+	assert.True(t, index.sorted)
+	assert.Equal(t, 42, len(index.Intervals))
+
+	first := index.Intervals[0]
+	assert.Equal(t, "", first.Tag)
+	assert.Equal(t, time.UnixMilli(goldenIntv0StartMs), first.StartTime())
+	assert.Equal(t, "A", index.Intervals[1].Tag)
+	for _, iv := range index.Intervals {
+		line := dat[iv.Offset : iv.Offset+iv.Length]
+		assert.Equal(t, byte('\n'), line[len(line)-1])
+		assert.Equal(t, iv.Offset == 0 || dat[iv.Offset-1] == '\n', true)
+	}
+}
+
+func TestLogIndex_WriteToReadLogIndex(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	index, err := BuildLogIndex(bytes.NewReader(dat))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	n, err := index.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+
+	read, err := ReadLogIndex(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, index, read)
+
+	_, err = ReadLogIndex(bytes.NewBufferString("#[Histogram log index version 9]\n"))
+	assert.NotNil(t, err)
+	_, err = ReadLogIndex(bytes.NewBufferString("1,2,3,x,5,\n"))
+	assert.Contains(t, err.Error(), "line 1")
+}
+
+func TestSeekableLogReader_Entries(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	var want []*LogEntry
+	for entry, err := range NewHistogramLogReader(bytes.NewReader(dat)).Entries() {
+		assert.Nil(t, err)
+		want = append(want, entry)
+	}
+
+	reader, err := NewSeekableLogReader(bytes.NewReader(dat))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2", reader.Header().FormatVersion)
+	var got []*LogEntry
+	for entry, err := range reader.Entries(time.Time{}, time.Time{}) {
+		assert.Nil(t, err)
+		got = append(got, entry)
+	}
+	assert.Equal(t, want, got)
+
+	// a closed range reads only the lines it needs
+	src := &countingReaderAt{r: bytes.NewReader(dat)}
+	reader, err = NewSeekableLogReaderWithIndex(src, reader.Index())
+	assert.Nil(t, err)
+	reader.SetTagFilter(&TagFilter{Include: []string{"A"}})
+	start, end := want[10].StartTime(), want[19].StartTime()
+	src.n = 0
+	var wantBytes int64
+	got = got[:0]
+	for entry, err := range reader.Entries(start, end) {
+		assert.Nil(t, err)
+		got = append(got, entry)
+	}
+	assert.Equal(t, 5, len(got))
+	for i, entry := range got {
+		assert.Equal(t, "A", entry.Tag)
+		assert.Equal(t, want[11+2*i], entry)
+		wantBytes += reader.Index().Intervals[11+2*i].Length
+	}
+	assert.Equal(t, wantBytes, src.n)
+}
+
+func TestSeekableLogReader_AccumulateByTag(t *testing.T) {
+	dat, err := os.ReadFile("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+	reader, err := NewSeekableLogReader(bytes.NewReader(dat))
+	assert.Nil(t, err)
+	intervals := reader.Index().Intervals
+	start, end := intervals[20].StartTime(), intervals[40].StartTime()
+
+	got, err := reader.AccumulateByTag(start, end)
+	assert.Nil(t, err)
+	want, err := NewHistogramLogReader(bytes.NewReader(dat)).AccumulateByTag(intervals[20].StartTimeSec, intervals[40].StartTimeSec, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(got))
+	assert.True(t, want[""].Equals(got[""]))
+	assert.Equal(t, want[""].StartTimeMs(), got[""].StartTimeMs())
+	assert.Equal(t, want[""].EndTimeMs(), got[""].EndTimeMs())
+}
+
+func TestSeekableLogReader_staleIndex(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	index, err := BuildLogIndex(bytes.NewReader(dat))
+	assert.Nil(t, err)
+
+	reader, err := NewSeekableLogReaderWithIndex(bytes.NewReader(dat[100:]), index)
+	assert.Nil(t, err)
+	var perr *LogParseError
+	for entry, err := range reader.Entries(time.Time{}, time.Time{}) {
+		assert.Nil(t, entry)
+		assert.True(t, errors.As(err, &perr))
+	}
+	assert.Equal(t, index.Intervals[0].Line, perr.Line)
+}
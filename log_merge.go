@@ -0,0 +1,202 @@
+package hdrhistogram
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// LogMergeOptions configures MergeHistogramLogs.
+type LogMergeOptions struct {
+	// Window is the length of the windows intervals are merged into, at
+	// least a millisecond. Windows are aligned to multiples of Window since
+	// the epoch, so logs merged with the same Window line up.
+	Window time.Duration
+	// SignificantFigures, if lower than that of the intervals, lowers the
+	// precision of the merged histograms, shrinking their payloads at the
+	// cost of value resolution. Zero keeps the intervals' precision.
+	SignificantFigures int
+	// LogOptions sets the ValueUnit and MaxValueUnit the merged intervals are
+	// logged with, as passed to OutputIntervalHistogramWithLogOptions. Its
+	// StartTime and EndTime are ignored, as every merged interval spans its
+	// window. Nil uses DefaultHistogramLogOptions.
+	LogOptions *HistogramLogOptions
+}
+
+// MergeHistogramLogs merges the intervals of logs, such as those written by
+// the same load test on several hosts, into a single log written to writer.
+//
+// Every log is read relative to its own StartTime and BaseTime, and intervals
+// are placed by their absolute start time into aligned windows of
+// options.Window. The intervals falling into a window are merged per tag, and
+// logged as one interval per tag spanning the whole window. The merged log
+// starts with a header, as written by OutputLogHeader, whose StartTime and
+// BaseTime are the start of the first window, rounded down to a second.
+//
+// The logs are read in step, so memory use does not grow with their length,
+// but every log must list its intervals in order of start time. An interval
+// starting in a window that was already written fails the merge. The readers'
+// tag filters and lenient modes are honoured.
+func MergeHistogramLogs(writer *HistogramLogWriter, logs []*HistogramLogReader, options *LogMergeOptions) (err error) {
+	if options == nil || options.Window < time.Millisecond {
+		return errors.New("log merge window must be at least a millisecond")
+	}
+	logOptions := DefaultHistogramLogOptions()
+	if options.LogOptions != nil {
+		logOptions = &HistogramLogOptions{ValueUnit: options.LogOptions.ValueUnit, MaxValueUnit: options.LogOptions.MaxValueUnit}
+	}
+	if logOptions.ValueUnit < 0 || logOptions.MaxValueUnit < 0 {
+		return errors.New("log merge value units cannot be negative")
+	}
+	m := &logMerger{
+		writer:             writer,
+		windowMs:           options.Window.Milliseconds(),
+		significantFigures: options.SignificantFigures,
+		logOptions:         logOptions,
+		logs:               logs,
+		heads:              make([]*Histogram, len(logs)),
+	}
+	for i := range logs {
+		if err = m.advance(i); err != nil {
+			return
+		}
+	}
+	if err = m.outputHeader(); err != nil {
+		return
+	}
+	for {
+		next := -1
+		for i, h := range m.heads {
+			if h != nil && (next < 0 || h.StartTimeMs() < m.heads[next].StartTimeMs()) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		if err = m.add(m.heads[next]); err != nil {
+			return
+		}
+		if err = m.advance(next); err != nil {
+			return
+		}
+	}
+	if err = m.flush(); err != nil {
+		return
+	}
+	return writer.Flush()
+}
+
+// ResampleHistogramLog rewrites the log read by log at a coarser resolution,
+// merging its intervals per tag into aligned windows of options.Window, as
+// MergeHistogramLogs does for several logs. The distribution accumulated over
+// any number of whole windows is kept exactly, unless options lowers the
+// significant figures, and the interval max column is rewritten from the
+// merged histograms.
+func ResampleHistogramLog(writer *HistogramLogWriter, log *HistogramLogReader, options *LogMergeOptions) error {
+	return MergeHistogramLogs(writer, []*HistogramLogReader{log}, options)
+}
+
+// logMerger merges intervals, arriving in order of start time, into windows.
+// As they arrive in order, only the latest window is ever open.
+type logMerger struct {
+	writer             *HistogramLogWriter
+	windowMs           int64
+	significantFigures int
+	logOptions         *HistogramLogOptions
+	logs               []*HistogramLogReader
+	// heads holds the next interval of every log, nil once it is exhausted.
+	heads []*Histogram
+
+	open   bool
+	window int64
+	tags   map[string]*Histogram
+}
+
+// advance reads the next interval of the i-th log.
+func (m *logMerger) advance(i int) (err error) {
+	m.heads[i], err = m.logs[i].NextIntervalHistogram()
+	return
+}
+
+// outputHeader writes the merged log's header. It is called once the first
+// interval of every log has been read, so the first window is known.
+func (m *logMerger) outputHeader() error {
+	first := int64(math.MaxInt64)
+	for _, h := range m.heads {
+		if h != nil && h.StartTimeMs() < first {
+			first = h.StartTimeMs()
+		}
+	}
+	startMs := int64(0)
+	if first != math.MaxInt64 {
+		// The header carries whole seconds.
+		startMs = m.windowOf(first) * m.windowMs
+		startMs -= startMs % 1000
+	}
+	return m.writer.OutputLogHeader(startMs, startMs)
+}
+
+// windowOf returns the index of the window holding msec since the epoch.
+func (m *logMerger) windowOf(msec int64) int64 {
+	return alignedWindow(msec, m.windowMs)
+}
+
+// alignedWindow returns the index of the window of windowMs milliseconds,
+// aligned to the epoch, holding msec since the epoch.
+func alignedWindow(msec, windowMs int64) int64 {
+	window := msec / windowMs
+	if msec < 0 && msec%windowMs != 0 {
+		window--
+	}
+	return window
+}
+
+func (m *logMerger) add(interval *Histogram) error {
+	window := m.windowOf(interval.StartTimeMs())
+	switch {
+	case !m.open:
+		m.open, m.window, m.tags = true, window, make(map[string]*Histogram)
+	case window < m.window:
+		return fmt.Errorf("interval starting at %v is out of order: the window from %v has been written",
+			time.UnixMilli(interval.StartTimeMs()).UTC(), time.UnixMilli(m.window*m.windowMs).UTC())
+	case window > m.window:
+		if err := m.flush(); err != nil {
+			return err
+		}
+		m.open, m.window, m.tags = true, window, make(map[string]*Histogram)
+	}
+	tag := interval.Tag()
+	acc := m.tags[tag]
+	if acc == nil && m.significantFigures > 0 && int64(m.significantFigures) < interval.SignificantFigures() {
+		acc = New(interval.LowestTrackableValue(), interval.HighestTrackableValue(), m.significantFigures)
+		acc.SetTag(tag)
+	}
+	m.tags[tag] = AccumulateInterval(acc, interval)
+	return nil
+}
+
+// flush writes the open window, one interval per tag with untagged first and
+// the rest in order of tag.
+func (m *logMerger) flush() error {
+	if !m.open {
+		return nil
+	}
+	m.open = false
+	tags := make([]string, 0, len(m.tags))
+	for tag := range m.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		h := m.tags[tag]
+		h.SetStartTimeMs(m.window * m.windowMs)
+		h.SetEndTimeMs((m.window + 1) * m.windowMs)
+		if err := m.writer.OutputIntervalHistogramWithLogOptions(h, m.logOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
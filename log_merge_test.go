@@ -0,0 +1,169 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hostLog returns a log of n one second intervals from startMs, the i-th
+// recording value i+1 under tag. The log header uses the given base time, in
+// whole seconds, so hosts can log relative or absolute timestamps.
+func hostLog(t *testing.T, startMs, baseMs int64, tag string, n int) *HistogramLogReader {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogHeader(startMs, baseMs))
+	for i := 0; i < n; i++ {
+		h := New(1, 1000, 3)
+		assert.Nil(t, h.RecordValue(int64(i+1)))
+		h.SetStartTimeMs(startMs + int64(i)*1000)
+		h.SetEndTimeMs(startMs + int64(i+1)*1000)
+		h.SetTag(tag)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+	return NewHistogramLogReader(bytes.NewReader(b.Bytes()))
+}
+
+func TestMergeHistogramLogs(t *testing.T) {
+	const startMs = 1441812280000
+	var b bytes.Buffer
+	err := MergeHistogramLogs(NewHistogramLogWriter(&b), []*HistogramLogReader{
+		hostLog(t, startMs+300, startMs, "", 4),
+		hostLog(t, startMs+1500, 0, "", 3),
+		hostLog(t, startMs+200, startMs, "A", 2),
+	}, &LogMergeOptions{Window: 2 * time.Second})
+	assert.Nil(t, err)
+
+	reader := NewHistogramLogReader(&b)
+	header, err := reader.ReadHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, float64(startMs)/1000, header.StartTimeSec)
+	assert.Equal(t, float64(startMs)/1000, header.BaseTimeSec)
+
+	type window struct {
+		startMs, endMs int64
+		tag            string
+		count, max     int64
+	}
+	var windows []window
+	for _, h := range drainAllIntervals(t, reader) {
+		windows = append(windows, window{h.StartTimeMs(), h.EndTimeMs(), h.Tag(), h.TotalCount(), h.Max()})
+	}
+	// windows are aligned to even seconds since the epoch, which startMs is
+	assert.Equal(t, []window{
+		// the first host's intervals 1 and 2, the second host's 1
+		{startMs, startMs + 2000, "", 3, 2},
+		{startMs, startMs + 2000, "A", 2, 2},
+		// the first host's 3 and 4, the second host's 2 and 3
+		{startMs + 2000, startMs + 4000, "", 4, 4},
+	}, windows)
+}
+
+func TestMergeHistogramLogs_taggedCorpus(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	logs := []*HistogramLogReader{NewHistogramLogReader(bytes.NewReader(dat)), NewHistogramLogReader(bytes.NewReader(dat))}
+	assert.Nil(t, MergeHistogramLogs(NewHistogramLogWriter(&b), logs, &LogMergeOptions{Window: 10 * time.Second}))
+
+	expected, err := NewHistogramLogReader(bytes.NewReader(dat)).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	merged, err := NewHistogramLogReader(&b).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(merged))
+	for tag, h := range merged {
+		assert.Equal(t, 2*expected[tag].TotalCount(), h.TotalCount())
+		assert.Equal(t, expected[tag].Max(), h.Max())
+		assert.Equal(t, int64(0), h.StartTimeMs()%10000)
+		assert.Equal(t, int64(0), h.EndTimeMs()%10000)
+	}
+}
+
+func TestMergeHistogramLogs_errors(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.NotNil(t, MergeHistogramLogs(writer, nil, nil))
+	assert.NotNil(t, MergeHistogramLogs(writer, nil, &LogMergeOptions{Window: time.Microsecond}))
+	assert.NotNil(t, MergeHistogramLogs(writer, nil, &LogMergeOptions{Window: time.Second, LogOptions: &HistogramLogOptions{ValueUnit: -1}}))
+
+	// a log going back to a window that was already written
+	var log bytes.Buffer
+	logWriter := NewHistogramLogWriter(&log)
+	for _, startMs := range []int64{1000000, 1003000, 1001000} {
+		h := New(1, 1000, 3)
+		assert.Nil(t, h.RecordValue(1))
+		h.SetStartTimeMs(startMs)
+		h.SetEndTimeMs(startMs + 1000)
+		assert.Nil(t, logWriter.OutputIntervalHistogram(h))
+	}
+	err := MergeHistogramLogs(writer, []*HistogramLogReader{NewHistogramLogReader(&log)}, &LogMergeOptions{Window: time.Second})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "out of order")
+}
+
+func TestMergeHistogramLogs_logOptions(t *testing.T) {
+	// microsecond values, logged with a max column in milliseconds
+	logOptions := &HistogramLogOptions{ValueUnit: time.Microsecond, MaxValueUnit: time.Millisecond}
+	var log bytes.Buffer
+	logWriter := NewHistogramLogWriter(&log)
+	for i := int64(0); i < 2; i++ {
+		h := New(1, 10000000, 3)
+		assert.Nil(t, h.RecordValue(3000))
+		h.SetStartTimeMs(1000000 + i*1000)
+		h.SetEndTimeMs(1000000 + (i+1)*1000)
+		assert.Nil(t, logWriter.OutputIntervalHistogramWithLogOptions(h, logOptions))
+	}
+
+	var b bytes.Buffer
+	options := &LogMergeOptions{Window: 10 * time.Second, LogOptions: logOptions}
+	assert.Nil(t, MergeHistogramLogs(NewHistogramLogWriter(&b), []*HistogramLogReader{NewHistogramLogReader(&log)}, options))
+	var maxes []float64
+	for entry, err := range NewHistogramLogReader(bytes.NewReader(b.Bytes())).Entries() {
+		assert.Nil(t, err)
+		maxes = append(maxes, entry.IntervalMax)
+	}
+	assert.Equal(t, []float64{3.001}, maxes)
+	findings, err := VerifyHistogramLog(bytes.NewReader(b.Bytes()), &LogVerifyOptions{ValueUnit: time.Microsecond, MaxValueUnit: time.Millisecond})
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestResampleHistogramLog(t *testing.T) {
+	dat, err := os.ReadFile("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+	original, err := NewHistogramLogReader(bytes.NewReader(dat)).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, ResampleHistogramLog(NewHistogramLogWriter(&b), NewHistogramLogReader(bytes.NewReader(dat)), &LogMergeOptions{Window: 10 * time.Second}))
+	resampled := bytes.Clone(b.Bytes())
+	assert.Less(t, len(resampled), len(dat)/2)
+	findings, err := VerifyHistogramLog(bytes.NewReader(resampled), nil)
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+
+	intervals := drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(resampled)))
+	for i, h := range intervals {
+		assert.Equal(t, int64(10000), h.EndTimeMs()-h.StartTimeMs())
+		if i > 0 {
+			assert.Equal(t, intervals[i-1].EndTimeMs(), h.StartTimeMs())
+		}
+	}
+	accumulated, err := NewHistogramLogReader(bytes.NewReader(resampled)).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	assert.True(t, original[""].Equals(accumulated[""]))
+
+	// lowering the precision shrinks the payloads, but keeps every count
+	b.Reset()
+	assert.Nil(t, ResampleHistogramLog(NewHistogramLogWriter(&b), NewHistogramLogReader(bytes.NewReader(dat)), &LogMergeOptions{Window: 10 * time.Second, SignificantFigures: 1}))
+	assert.Less(t, b.Len(), len(resampled))
+	coarse := drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(b.Bytes())))
+	assert.Equal(t, len(intervals), len(coarse))
+	for i, h := range coarse {
+		assert.Equal(t, int64(1), h.SignificantFigures())
+		assert.Equal(t, intervals[i].TotalCount(), h.TotalCount())
+	}
+}
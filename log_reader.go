@@ -2,11 +2,14 @@ package hdrhistogram
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
+	"iter"
 	"math"
-	"regexp"
-	"strconv"
-	"strings"
+	"time"
 )
 
 type HistogramLogReader struct {
@@ -17,16 +20,313 @@ type HistogramLogReader struct {
 	observedBaseTime  bool
 
 	// scanner handling state
-	absolute            bool
-	rangeStartTimeSec   float64
-	rangeEndTimeSec     float64
-	observedMax         bool
-	rangeObservedMax    int64
-	observedMin         bool
-	rangeObservedMin    int64
-	reStartTime         *regexp.Regexp
-	reBaseTime          *regexp.Regexp
-	reHistogramInterval *regexp.Regexp
+	absolute          bool
+	rangeStartTimeSec float64
+	rangeEndTimeSec   float64
+	observedMax       bool
+	rangeObservedMax  int64
+	observedMin       bool
+	rangeObservedMin  int64
+
+	header         LogHeader
+	tagFilter      tagFilter
+	tok            logLine
+	lineBuf        []byte
+	pendingLine    []byte
+	hasPendingLine bool
+	lastTag        string
+
+	// fault tolerance state
+	lenient        bool
+	onLineError    func(err *LogParseError)
+	lineNumber     int64
+	lineOffset     int64
+	nextLineOffset int64
+	acceptedLines  int64
+	skippedLines   int64
+
+	// closer releases the file of a followed log.
+	closer io.Closer
+	// compressionChecked is set once the log has been checked for gzip.
+	compressionChecked bool
+}
+
+// A LogParseError describes a log line that could not be read, and where it is.
+type LogParseError struct {
+	// Line is the 1-based line number of the offending line.
+	Line int64
+	// Offset is the byte offset of the start of the line.
+	Offset int64
+	Err    error
+}
+
+func (e *LogParseError) Error() string {
+	return fmt.Sprintf("histogram log line %d (byte offset %d): %v", e.Line, e.Offset, e.Err)
+}
+
+func (e *LogParseError) Unwrap() error {
+	return e.Err
+}
+
+// SetLenient switches the reader between strict mode (the default), where the
+// first undecodable line stops the read with a *LogParseError, and lenient
+// mode, where such lines are skipped and reading carries on. In both modes
+// unrecognised lines are skipped.
+func (hlr *HistogramLogReader) SetLenient(lenient bool) {
+	hlr.lenient = lenient
+}
+
+// SetErrorHandler registers a function called with every line the reader
+// skips: unrecognised lines in either mode, and undecodable lines in lenient
+// mode. A nil handler disables the reporting.
+func (hlr *HistogramLogReader) SetErrorHandler(onLineError func(err *LogParseError)) {
+	hlr.onLineError = onLineError
+}
+
+// AcceptedLines returns the number of interval lines decoded and returned so far.
+func (hlr *HistogramLogReader) AcceptedLines() int64 {
+	return hlr.acceptedLines
+}
+
+// SkippedLines returns the number of malformed or undecodable lines skipped so far.
+func (hlr *HistogramLogReader) SkippedLines() int64 {
+	return hlr.skippedLines
+}
+
+// lineError annotates err with the position of the current line.
+func (hlr *HistogramLogReader) lineError(err error) *LogParseError {
+	return &LogParseError{Line: hlr.lineNumber, Offset: hlr.lineOffset, Err: err}
+}
+
+func (hlr *HistogramLogReader) reportSkipped(perr *LogParseError) {
+	hlr.skippedLines++
+	if hlr.onLineError != nil {
+		hlr.onLineError(perr)
+	}
+}
+
+// lineFailed handles err on the current line according to the reader mode:
+// in lenient mode the line is skipped and nil is returned, otherwise the error
+// is returned annotated with the line position.
+func (hlr *HistogramLogReader) lineFailed(err error) error {
+	return hlr.failLine(hlr.lineError(err))
+}
+
+func (hlr *HistogramLogReader) failLine(perr *LogParseError) error {
+	if hlr.lenient {
+		hlr.reportSkipped(perr)
+		return nil
+	}
+	return perr
+}
+
+// A TagFilter selects the interval lines a HistogramLogReader returns by their
+// tag. The empty string stands for untagged lines in both Include and Exclude.
+type TagFilter struct {
+	// Include, when non-empty, restricts reading to intervals with these tags.
+	Include []string
+	// Exclude skips intervals with these tags.
+	Exclude []string
+	// UntaggedOnly restricts reading to intervals without a tag.
+	UntaggedOnly bool
+}
+
+// tagFilter is the compiled form of a TagFilter.
+type tagFilter struct {
+	include      map[string]bool
+	exclude      map[string]bool
+	untaggedOnly bool
+}
+
+func (f *tagFilter) accepts(tag string) bool {
+	if f.untaggedOnly && tag != "" {
+		return false
+	}
+	if f.include != nil && !f.include[tag] {
+		return false
+	}
+	return !f.exclude[tag]
+}
+
+// SetTagFilter restricts the intervals returned by the reader to those
+// selected by filter. Lines that are filtered out are skipped without decoding
+// their payload. A nil filter accepts every interval.
+func (hlr *HistogramLogReader) SetTagFilter(filter *TagFilter) {
+	hlr.tagFilter = tagFilter{}
+	if filter == nil {
+		return
+	}
+	hlr.tagFilter.untaggedOnly = filter.UntaggedOnly
+	if len(filter.Include) > 0 {
+		hlr.tagFilter.include = make(map[string]bool, len(filter.Include))
+		for _, tag := range filter.Include {
+			hlr.tagFilter.include[tag] = true
+		}
+	}
+	if len(filter.Exclude) > 0 {
+		hlr.tagFilter.exclude = make(map[string]bool, len(filter.Exclude))
+		for _, tag := range filter.Exclude {
+			hlr.tagFilter.exclude[tag] = true
+		}
+	}
+}
+
+// ListTags reads the remainder of the log and returns the distinct tags of its
+// interval lines, in order of first appearance. Untagged lines are reported as
+// the empty string. Payloads are not decoded, and the tag filter is ignored.
+func (hlr *HistogramLogReader) ListTags() (tags []string, err error) {
+	seen := make(map[string]bool)
+	tok := &hlr.tok
+	for {
+		var line []byte
+		var ok, isHeader bool
+		line, ok, err = hlr.readLine()
+		if err != nil || !ok {
+			return
+		}
+		tokenizeLogLine(line, tok)
+		isHeader, err = hlr.parseHeaderLine(tok)
+		if err != nil {
+			if err = hlr.lineFailed(err); err != nil {
+				return
+			}
+			continue
+		}
+		if isHeader || tok.kind != logLineInterval {
+			continue
+		}
+		tag := ""
+		if tok.hasTag {
+			tag = hlr.tagString(tok)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+}
+
+// AccumulateByTag reads the remaining intervals whose start time falls within
+// [rangeStartTimeSec, rangeEndTimeSec] and merges them into one histogram per
+// tag, keyed by tag (the empty string for untagged intervals). This matches the
+// accumulated distribution HistogramLogProcessor reports with -tag or -allTags.
+//
+// With absolute set the range is in seconds since the epoch, otherwise it is in
+// seconds since the log start time. Only intervals accepted by the tag filter
+// are accumulated. Each accumulated histogram spans the start of its first
+// interval to the end of its last one, and grows to the highest trackable value
+// of any interval merged into it, so no values are dropped.
+func (hlr *HistogramLogReader) AccumulateByTag(rangeStartTimeSec, rangeEndTimeSec float64, absolute bool) (accumulated map[string]*Histogram, err error) {
+	accumulated = make(map[string]*Histogram)
+	for {
+		var histogram *Histogram
+		histogram, err = hlr.NextIntervalHistogramWithRange(rangeStartTimeSec, rangeEndTimeSec, absolute)
+		if err != nil || histogram == nil {
+			return
+		}
+		tag := histogram.Tag()
+		accumulated[tag] = AccumulateInterval(accumulated[tag], histogram)
+	}
+}
+
+// AccumulateInterval merges interval into acc, returning the accumulator, as
+// AccumulateByTag does for every interval it reads. A nil acc, or one too
+// narrow to hold interval's values, is replaced by a new one, so no values are
+// dropped. The accumulator spans the start of the first interval merged into
+// it to the end of the last. It is meant for tools that report the
+// accumulated distribution as they go, interval by interval.
+func AccumulateInterval(acc *Histogram, interval *Histogram) *Histogram {
+	if acc == nil {
+		acc = New(interval.LowestTrackableValue(), interval.HighestTrackableValue(), int(interval.SignificantFigures()))
+		acc.SetTag(interval.Tag())
+		acc.SetStartTimeMs(interval.StartTimeMs())
+		acc.SetEndTimeMs(interval.EndTimeMs())
+	} else if interval.HighestTrackableValue() > acc.HighestTrackableValue() {
+		grown := New(acc.LowestTrackableValue(), interval.HighestTrackableValue(), int(acc.SignificantFigures()))
+		grown.Merge(acc)
+		grown.SetTag(acc.Tag())
+		grown.SetStartTimeMs(acc.StartTimeMs())
+		grown.SetEndTimeMs(acc.EndTimeMs())
+		acc = grown
+	}
+	acc.Merge(interval)
+	if interval.StartTimeMs() < acc.StartTimeMs() {
+		acc.SetStartTimeMs(interval.StartTimeMs())
+	}
+	if interval.EndTimeMs() > acc.EndTimeMs() {
+		acc.SetEndTimeMs(interval.EndTimeMs())
+	}
+	return acc
+}
+
+// LogHeader holds the metadata carried by the comment and legend lines of a
+// histogram log.
+type LogHeader struct {
+	// FormatVersion is the version from the "#[Histogram log format version X]"
+	// line, or empty if the log has none.
+	FormatVersion string
+	// StartTimeSec is the log start time in seconds since the epoch, as given by
+	// the "#[StartTime: ...]" line. HasStartTime reports whether the line was
+	// present, and StartTimeText holds its human-readable date, if any.
+	StartTimeSec  float64
+	HasStartTime  bool
+	StartTimeText string
+	// BaseTimeSec is the base time in seconds since the epoch that interval
+	// timestamps are relative to, as given by the "#[BaseTime: ...]" line.
+	BaseTimeSec float64
+	HasBaseTime bool
+	// Legend is the column header line, if present.
+	Legend string
+	// Comments holds every other comment line, without the leading '#'.
+	Comments []string
+}
+
+// A LogEntry is a single interval line of a histogram log.
+type LogEntry struct {
+	// Tag is the interval's tag, or empty for an untagged line.
+	Tag string
+	// LogTimeStampSec and IntervalLengthSec are the interval's timestamp and
+	// length in seconds, exactly as written in the log (that is, relative to
+	// the log's base time).
+	LogTimeStampSec   float64
+	IntervalLengthSec float64
+	// AbsoluteStartTimeSec and AbsoluteEndTimeSec bound the interval in
+	// seconds since the epoch.
+	AbsoluteStartTimeSec float64
+	AbsoluteEndTimeSec   float64
+	// RelativeStartTimeSec is the interval start in seconds since the log's
+	// start time.
+	RelativeStartTimeSec float64
+	// IntervalMax is the interval max column as written in the log, which is
+	// scaled by the writer's max value unit ratio.
+	IntervalMax float64
+	// Histogram holds the decoded interval. Its start/end times and tag are
+	// set from the line.
+	Histogram *Histogram
+}
+
+// StartTime returns the absolute start time of the interval.
+func (e *LogEntry) StartTime() time.Time {
+	return secondsToTime(e.AbsoluteStartTimeSec)
+}
+
+// EndTime returns the absolute end time of the interval.
+func (e *LogEntry) EndTime() time.Time {
+	return secondsToTime(e.AbsoluteEndTimeSec)
+}
+
+// StartTime returns the log start time, or the zero time if the header has none.
+func (h *LogHeader) StartTime() time.Time {
+	if !h.HasStartTime {
+		return time.Time{}
+	}
+	return secondsToTime(h.StartTimeSec)
+}
+
+// secondsToTime converts fractional seconds since the epoch to a time.Time,
+// rounded to the millisecond resolution of the log format.
+func secondsToTime(sec float64) time.Time {
+	return time.UnixMilli(int64(math.Round(sec * 1000.0)))
 }
 
 func (hlr *HistogramLogReader) ObservedMin() bool {
@@ -47,32 +347,36 @@ func (hlr *HistogramLogReader) RangeObservedMin() int64 {
 	return hlr.rangeObservedMin
 }
 
-func NewHistogramLogReader(log io.Reader) *HistogramLogReader {
-	//# "#[StartTime: %f (seconds since epoch), %s]\n"
-	reStartTime, _ := regexp.Compile(`#\[StartTime: ([\d\.]*)`)
+// StartTimeSec returns the log start time in seconds since the epoch, as given
+// by the "#[StartTime: ...]" line or, for a log without one, taken from the
+// timestamp of the first interval line read. It is 0 until either is seen.
+func (hlr *HistogramLogReader) StartTimeSec() float64 {
+	return hlr.startTimeSec
+}
 
-	//# "#[BaseTime: %f (seconds since epoch)]\n"
-	reBaseTime, _ := regexp.Compile(`#\[BaseTime: ([\d\.]*)`)
+// BaseTimeSec returns the base time in seconds since the epoch that interval
+// timestamps are relative to, as given by the "#[BaseTime: ...]" line or
+// deduced from the first interval line read.
+func (hlr *HistogramLogReader) BaseTimeSec() float64 {
+	return hlr.baseTimeSec
+}
 
-	//# 0.127,1.007,2.769,HISTFAAAAEV42pNpmSz...
-	//# Tag=A,0.127,1.007,2.769,HISTFAAAAEV42pNpmSz
-	//# "%f,%f,%f,%s\n"
-	reHistogramInterval, _ := regexp.Compile(`([\d\.]*),([\d\.]*),([\d\.]*),(.*)`)
-	//
+// NewHistogramLogReader returns a reader over the histogram log read from log.
+// A gzip compressed log, such as an .hlog.gz file, is detected from its leading
+// bytes and decompressed transparently; line numbers and byte offsets then
+// refer to the decompressed log.
+func NewHistogramLogReader(log io.Reader) *HistogramLogReader {
 	reader := bufio.NewReader(log)
 
 	return &HistogramLogReader{log: reader,
-		startTimeSec:        0.0,
-		observedStartTime:   false,
-		baseTimeSec:         0.0,
-		observedBaseTime:    false,
-		reStartTime:         reStartTime,
-		reBaseTime:          reBaseTime,
-		reHistogramInterval: reHistogramInterval,
-		rangeObservedMin:    math.MaxInt64,
-		observedMin:         false,
-		rangeObservedMax:    math.MinInt64,
-		observedMax:         false,
+		startTimeSec:      0.0,
+		observedStartTime: false,
+		baseTimeSec:       0.0,
+		observedBaseTime:  false,
+		rangeObservedMin:  math.MaxInt64,
+		observedMin:       false,
+		rangeObservedMax:  math.MinInt64,
+		observedMax:       false,
 	}
 }
 
@@ -80,6 +384,10 @@ func (hlr *HistogramLogReader) NextIntervalHistogram() (histogram *Histogram, er
 	return hlr.NextIntervalHistogramWithRange(0.0, math.MaxFloat64, true)
 }
 
+// NextIntervalHistogramWithRange returns the next interval starting within
+// [rangeStartTimeSec, rangeEndTimeSec], or nil at the end of the log. With
+// absolute, the range is in seconds since the epoch; otherwise it is in
+// seconds since the log's StartTime, as in the Java HistogramLogReader.
 func (hlr *HistogramLogReader) NextIntervalHistogramWithRange(rangeStartTimeSec, rangeEndTimeSec float64, absolute bool) (histogram *Histogram, err error) {
 	hlr.rangeStartTimeSec = rangeStartTimeSec
 	hlr.rangeEndTimeSec = rangeEndTimeSec
@@ -88,133 +396,368 @@ func (hlr *HistogramLogReader) NextIntervalHistogramWithRange(rangeStartTimeSec,
 }
 
 func (hlr *HistogramLogReader) decodeNextIntervalHistogram() (histogram *Histogram, err error) {
-	var line string
-	var tag = ""
-	var logTimeStampInSec float64
-	var intervalLengthSec float64
+	entry, err := hlr.nextEntry()
+	if entry != nil {
+		histogram = entry.Histogram
+	}
+	return
+}
+
+// Header returns the log header metadata observed so far. Header lines may
+// appear anywhere in a log, so the result is only complete once ReadHeader has
+// been called or the log has been read to the end.
+func (hlr *HistogramLogReader) Header() *LogHeader {
+	return &hlr.header
+}
+
+// ReadHeader reads the leading comment and legend lines of the log, up to the
+// first interval line, and returns the parsed header. The interval line that
+// ends the header is kept and returned by the next read.
+func (hlr *HistogramLogReader) ReadHeader() (header *LogHeader, err error) {
 	for {
-		line, err = hlr.log.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-				// A final line lacking a trailing newline is returned by
-				// ReadString together with io.EOF. Process it before
-				// terminating so the last interval is not silently dropped.
-				if line == "" {
-					break
-				}
-			} else {
-				break
-			}
+		var line []byte
+		var ok bool
+		line, ok, err = hlr.readLine()
+		if err != nil || !ok {
+			break
 		}
-		if line[0] == '#' {
-			matchRes := hlr.reStartTime.FindStringSubmatch(line)
-			if len(matchRes) > 0 {
-				hlr.startTimeSec, err = strconv.ParseFloat(matchRes[1], 64)
-				if err != nil {
-					return
-				}
-				hlr.observedStartTime = true
-				continue
-			}
-			matchRes = hlr.reBaseTime.FindStringSubmatch(line)
-			if len(matchRes) > 0 {
-				hlr.baseTimeSec, err = strconv.ParseFloat(matchRes[1], 64)
-				if err != nil {
-					return
-				}
-				hlr.observedBaseTime = true
-				continue
+		tokenizeLogLine(line, &hlr.tok)
+		var isHeader bool
+		if isHeader, err = hlr.parseHeaderLine(&hlr.tok); err != nil {
+			if err = hlr.lineFailed(err); err != nil {
+				break
 			}
 			continue
 		}
-
-		if strings.HasPrefix(line, "Tag=") {
-			commaPos := strings.Index(line, ",")
-			// A "Tag=" line with no comma is malformed; line[4:commaPos] would be
-			// line[4:-1] and panic. Skip the line instead.
-			if commaPos < 0 {
-				continue
-			}
-			tag = line[4:commaPos]
-			line = line[commaPos+1:]
+		if !isHeader {
+			// line aliases the read buffer, which the next read reuses.
+			hlr.pendingLine = append(hlr.pendingLine[:0], line...)
+			hlr.hasPendingLine = true
+			break
 		}
+	}
+	return &hlr.header, err
+}
 
-		matchRes := hlr.reHistogramInterval.FindStringSubmatch(line)
-		if len(matchRes) >= 1 {
-			// Decode: startTimestamp, intervalLength, maxTime, histogramPayload
-			// Timestamp is expected to be in seconds
-			logTimeStampInSec, err = strconv.ParseFloat(matchRes[1], 64)
+// Entries returns an iterator over the remaining interval lines of the log.
+// Iteration stops after the first error, which is yielded with a nil entry.
+func (hlr *HistogramLogReader) Entries() iter.Seq2[*LogEntry, error] {
+	return func(yield func(*LogEntry, error) bool) {
+		hlr.rangeStartTimeSec = 0.0
+		hlr.rangeEndTimeSec = math.MaxFloat64
+		hlr.absolute = true
+		for {
+			entry, err := hlr.nextEntry()
 			if err != nil {
+				yield(nil, err)
 				return
 			}
-			intervalLengthSec, err = strconv.ParseFloat(matchRes[2], 64)
-			if err != nil {
+			if entry == nil || !yield(entry, nil) {
 				return
 			}
-			cpayload := matchRes[4]
-
-			// No explicit start time noted. Use 1st observed time:
-
-			if !hlr.observedStartTime {
-				hlr.startTimeSec = logTimeStampInSec
-				hlr.observedStartTime = true
-			}
+		}
+	}
+}
 
-			// No explicit base time noted.
-			// Deduce from 1st observed time (compared to start time):
-			if !hlr.observedBaseTime {
-				// Criteria Note: if log timestamp is more than a year in
-				// the past (compared to StartTime),
-				// we assume that timestamps in the log are not absolute
-				if logTimeStampInSec < (hlr.startTimeSec - (365 * 24 * 3600.0)) {
-					hlr.baseTimeSec = hlr.startTimeSec
-				} else {
-					hlr.baseTimeSec = 0.0
-				}
-				hlr.observedBaseTime = true
-			}
+// readLine returns the next line of the log, or ok == false at the end of it.
+// The line aliases the reader's buffers and is only valid until the next call.
+func (hlr *HistogramLogReader) readLine() (line []byte, ok bool, err error) {
+	if hlr.hasPendingLine {
+		hlr.hasPendingLine = false
+		return hlr.pendingLine, true, nil
+	}
+	if !hlr.compressionChecked {
+		if err = hlr.detectCompression(); err != nil {
+			return
+		}
+	}
+	line, err = hlr.log.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// The line is longer than the read buffer: assemble it in lineBuf.
+		hlr.lineBuf = append(hlr.lineBuf[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = hlr.log.ReadSlice('\n')
+			hlr.lineBuf = append(hlr.lineBuf, line...)
+		}
+		line = hlr.lineBuf
+	}
+	if err != nil {
+		if err != io.EOF {
+			return
+		}
+		err = nil
+		// A final line lacking a trailing newline is returned by
+		// ReadSlice together with io.EOF. Process it before
+		// terminating so the last interval is not silently dropped.
+		if len(line) == 0 {
+			return
+		}
+	}
+	hlr.lineNumber++
+	hlr.lineOffset = hlr.nextLineOffset
+	hlr.nextLineOffset += int64(len(line))
+	return line, true, nil
+}
 
-			absoluteStartTimeStampSec := logTimeStampInSec + hlr.baseTimeSec
-			offsetStartTimeStampSec := absoluteStartTimeStampSec + hlr.startTimeSec
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
 
-			// Timestamp length is expected to be in seconds
-			absoluteEndTimeStampSec := absoluteStartTimeStampSec + intervalLengthSec
+// detectCompression switches to reading through a gzip decompressor if the log
+// starts with the gzip magic bytes.
+func (hlr *HistogramLogReader) detectCompression() error {
+	hlr.compressionChecked = true
+	magic, err := hlr.log.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return nil
+	}
+	gz, err := gzip.NewReader(hlr.log)
+	if err != nil {
+		return err
+	}
+	hlr.log = bufio.NewReader(gz)
+	return nil
+}
 
-			var startTimeStampToCheckRangeOn float64
-			if hlr.absolute {
-				startTimeStampToCheckRangeOn = absoluteStartTimeStampSec
-			} else {
-				startTimeStampToCheckRangeOn = offsetStartTimeStampSec
-			}
+// parseHeaderLine records the metadata carried by a comment or legend line,
+// reporting whether the line was one. An unparsable StartTime or BaseTime is an
+// error, as it would leave every following timestamp ambiguous.
+func (hlr *HistogramLogReader) parseHeaderLine(tok *logLine) (isHeader bool, err error) {
+	switch tok.kind {
+	case logLineStartTime:
+		var startTimeSec float64
+		if startTimeSec, err = parseLogDecimal(tok.timestamp); err != nil {
+			return true, err
+		}
+		hlr.startTimeSec = startTimeSec
+		hlr.observedStartTime = true
+		hlr.header.StartTimeSec = startTimeSec
+		hlr.header.HasStartTime = true
+		hlr.header.StartTimeText = string(tok.text)
+	case logLineBaseTime:
+		var baseTimeSec float64
+		if baseTimeSec, err = parseLogDecimal(tok.timestamp); err != nil {
+			return true, err
+		}
+		hlr.baseTimeSec = baseTimeSec
+		hlr.observedBaseTime = true
+		hlr.header.BaseTimeSec = baseTimeSec
+		hlr.header.HasBaseTime = true
+	case logLineFormatVersion:
+		hlr.header.FormatVersion = string(tok.text)
+	case logLineLegend:
+		hlr.header.Legend = string(tok.text)
+	case logLineComment:
+		hlr.header.Comments = append(hlr.header.Comments, string(tok.text))
+	default:
+		return false, nil
+	}
+	return true, nil
+}
 
-			if startTimeStampToCheckRangeOn < hlr.rangeStartTimeSec {
-				continue
-			}
+// tagString returns tok's tag as a string. Consecutive lines usually share a
+// tag, so the previous string is reused rather than allocating a new one.
+func (hlr *HistogramLogReader) tagString(tok *logLine) string {
+	if string(tok.tag) != hlr.lastTag {
+		hlr.lastTag = string(tok.tag)
+	}
+	return hlr.lastTag
+}
 
-			if startTimeStampToCheckRangeOn > hlr.rangeEndTimeSec {
-				return
-			}
-			histogram, err = Decode([]byte(cpayload))
-			if err != nil {
+func (hlr *HistogramLogReader) nextEntry() (entry *LogEntry, err error) {
+	for {
+		var payload []byte
+		entry, payload, err = hlr.scanInterval(hlr.reportSkipped)
+		if err != nil || entry == nil {
+			return
+		}
+		if err = entry.decodeHistogram(payload); err != nil {
+			if err = hlr.lineFailed(err); err != nil {
 				return
 			}
+			continue
+		}
+		hlr.observeInterval(entry.Histogram)
+		hlr.acceptedLines++
+		return
+	}
+}
 
-			if histogram.Max() > hlr.rangeObservedMax {
-				hlr.rangeObservedMax = histogram.Max()
+// scanInterval reads up to the next interval line within the requested range
+// and parses everything but its payload, which is returned undecoded and
+// aliases the reader's buffers. Lines that cannot be parsed are handed to skip
+// in lenient mode and returned as an error otherwise. A nil entry marks the end
+// of the log or of the range.
+func (hlr *HistogramLogReader) scanInterval(skip func(*LogParseError)) (entry *LogEntry, payload []byte, err error) {
+	tok := &hlr.tok
+	for {
+		var line []byte
+		var ok bool
+		line, ok, err = hlr.readLine()
+		if err != nil || !ok {
+			return
+		}
+		tokenizeLogLine(line, tok)
+		var isHeader bool
+		isHeader, err = hlr.parseHeaderLine(tok)
+		if err != nil {
+			if err = hlr.scanFailed(err, skip); err != nil {
+				return
 			}
+			continue
+		}
+		switch {
+		case isHeader, tok.kind == logLineBlank:
+			continue
+		case tok.kind == logLineUnrecognised:
+			skip(hlr.lineError(errors.New(tok.problem)))
+			continue
+		}
 
-			if histogram.Min() < hlr.rangeObservedMin {
-				hlr.rangeObservedMin = histogram.Min()
+		tag := ""
+		if tok.hasTag {
+			tag = hlr.tagString(tok)
+		}
+		// Filtered out lines are skipped before their payload is decoded, but
+		// still date a log lacking StartTime or BaseTime headers, so that
+		// filtering does not shift relative times.
+		if !hlr.tagFilter.accepts(tag) {
+			if logTimeStampInSec, perr := parseLogDecimal(tok.start); perr == nil {
+				hlr.observeTimeStamp(logTimeStampInSec)
 			}
+			continue
+		}
 
-			histogram.SetStartTimeMs(int64(absoluteStartTimeStampSec * 1000.0))
-			histogram.SetEndTimeMs(int64(absoluteEndTimeStampSec * 1000.0))
-			if tag != "" {
-				histogram.SetTag(tag)
+		var pastRange bool
+		entry, pastRange, err = hlr.parseInterval(tag, tok)
+		if err != nil {
+			if err = hlr.scanFailed(err, skip); err != nil {
+				return
 			}
+			continue
+		}
+		if pastRange {
 			return
 		}
+		if entry != nil {
+			return entry, tok.payload, nil
+		}
+	}
+}
+
+func (hlr *HistogramLogReader) scanFailed(err error, skip func(*LogParseError)) error {
+	perr := hlr.lineError(err)
+	if !hlr.lenient {
+		return perr
+	}
+	skip(perr)
+	return nil
+}
+
+// parseInterval parses the timestamps of a tokenized interval line, leaving
+// the payload to LogEntry.decodeHistogram. It returns a nil entry for intervals
+// starting before the requested range, and pastRange for those starting after
+// it.
+func (hlr *HistogramLogReader) parseInterval(tag string, tok *logLine) (entry *LogEntry, pastRange bool, err error) {
+	// Decode: startTimestamp, intervalLength, maxTime, histogramPayload
+	// Timestamp is expected to be in seconds
+	logTimeStampInSec, err := parseLogDecimal(tok.start)
+	if err != nil {
+		return
+	}
+	intervalLengthSec, err := parseLogDecimal(tok.length)
+	if err != nil {
+		return
+	}
+	// The interval max column is informational only; a missing or
+	// garbled value is reported as 0 rather than failing the line.
+	intervalMax, _ := parseLogDecimal(tok.max)
+
+	hlr.observeTimeStamp(logTimeStampInSec)
+
+	absoluteStartTimeStampSec := logTimeStampInSec + hlr.baseTimeSec
+	offsetStartTimeStampSec := absoluteStartTimeStampSec - hlr.startTimeSec
+
+	// Timestamp length is expected to be in seconds
+	absoluteEndTimeStampSec := absoluteStartTimeStampSec + intervalLengthSec
+
+	var startTimeStampToCheckRangeOn float64
+	if hlr.absolute {
+		startTimeStampToCheckRangeOn = absoluteStartTimeStampSec
+	} else {
+		startTimeStampToCheckRangeOn = offsetStartTimeStampSec
+	}
+
+	if startTimeStampToCheckRangeOn < hlr.rangeStartTimeSec {
+		return
+	}
+
+	if startTimeStampToCheckRangeOn > hlr.rangeEndTimeSec {
+		pastRange = true
+		return
+	}
+	entry = &LogEntry{
+		Tag:                  tag,
+		LogTimeStampSec:      logTimeStampInSec,
+		IntervalLengthSec:    intervalLengthSec,
+		AbsoluteStartTimeSec: absoluteStartTimeStampSec,
+		AbsoluteEndTimeSec:   absoluteEndTimeStampSec,
+		RelativeStartTimeSec: offsetStartTimeStampSec,
+		IntervalMax:          intervalMax,
 	}
 	return
 }
+
+// observeTimeStamp infers the start and base times of a log without StartTime
+// or BaseTime headers from the timestamp of its first interval line.
+func (hlr *HistogramLogReader) observeTimeStamp(logTimeStampInSec float64) {
+	// No explicit start time noted. Use 1st observed time:
+
+	if !hlr.observedStartTime {
+		hlr.startTimeSec = logTimeStampInSec
+		hlr.observedStartTime = true
+	}
+
+	// No explicit base time noted.
+	// Deduce from 1st observed time (compared to start time):
+	if !hlr.observedBaseTime {
+		// Criteria Note: if log timestamp is more than a year in
+		// the past (compared to StartTime),
+		// we assume that timestamps in the log are not absolute
+		if logTimeStampInSec < (hlr.startTimeSec - (365 * 24 * 3600.0)) {
+			hlr.baseTimeSec = hlr.startTimeSec
+		} else {
+			hlr.baseTimeSec = 0.0
+		}
+		hlr.observedBaseTime = true
+	}
+}
+
+// decodeHistogram decodes the interval's payload into e.Histogram, stamped
+// with the interval's times and tag.
+func (e *LogEntry) decodeHistogram(payload []byte) error {
+	histogram, err := Decode(payload)
+	if err != nil {
+		return err
+	}
+	histogram.SetStartTimeMs(int64(e.AbsoluteStartTimeSec * 1000.0))
+	histogram.SetEndTimeMs(int64(e.AbsoluteEndTimeSec * 1000.0))
+	if e.Tag != "" {
+		histogram.SetTag(e.Tag)
+	}
+	e.Histogram = histogram
+	return nil
+}
+
+// observeInterval folds a returned interval into the observed range limits.
+func (hlr *HistogramLogReader) observeInterval(histogram *Histogram) {
+	if histogram.Max() > hlr.rangeObservedMax {
+		hlr.rangeObservedMax = histogram.Max()
+	}
+
+	if histogram.Min() < hlr.rangeObservedMin {
+		hlr.rangeObservedMin = histogram.Min()
+	}
+}
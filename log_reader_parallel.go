@@ -0,0 +1,150 @@
+package hdrhistogram
+
+import (
+	"context"
+	"iter"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// decodeJob carries one interval from the line scanner through a decoding
+// worker to the consumer. Jobs reach the consumer in log order; an interval is
+// ready once done is closed. A job without an entry instead reports a skipped
+// line or the error that ended the scan.
+type decodeJob struct {
+	entry        *LogEntry
+	payload      []byte
+	line, offset int64
+	decodeErr    error
+	done         chan struct{}
+
+	skipped *LogParseError
+	err     error
+}
+
+// ParallelEntries is like Entries, but decodes the interval payloads on a pool
+// of parallelism goroutines while the log is still being read. Lines are split
+// and parsed sequentially and entries are yielded in log order, together with
+// any skipped lines reported to the error handler, exactly as Entries would
+// produce them. A parallelism of zero or less uses runtime.GOMAXPROCS(0).
+//
+// At most 2*parallelism intervals are read ahead of the consumer, so a slow
+// consumer stalls the reading of the log rather than growing memory. If ctx is
+// cancelled, iteration stops and yields ctx.Err(). Stopping the iteration early
+// shuts the pool down before returning; with a source that blocks in Read this
+// waits for that Read to return. The reader must not otherwise be used while
+// the iteration is in progress.
+func (hlr *HistogramLogReader) ParallelEntries(ctx context.Context, parallelism int) iter.Seq2[*LogEntry, error] {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	return func(yield func(*LogEntry, error) bool) {
+		hlr.rangeStartTimeSec = 0.0
+		hlr.rangeEndTimeSec = math.MaxFloat64
+		hlr.absolute = true
+
+		ctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer cancel()
+
+		pending := make(chan *decodeJob, 2*parallelism)
+		work := make(chan *decodeJob)
+		var cancelled bool
+		wg.Add(1 + parallelism)
+		go func() {
+			defer wg.Done()
+			defer close(pending)
+			defer close(work)
+			cancelled = !hlr.scanJobs(ctx, pending, work)
+		}()
+		for i := 0; i < parallelism; i++ {
+			go func() {
+				defer wg.Done()
+				for job := range work {
+					if ctx.Err() == nil {
+						job.decodeErr = job.entry.decodeHistogram(job.payload)
+					}
+					close(job.done)
+				}
+			}()
+		}
+
+		for job := range pending {
+			if job.done != nil {
+				select {
+				case <-job.done:
+				case <-ctx.Done():
+				}
+			}
+			// Once cancelled, workers stop decoding the jobs they are handed.
+			if ctx.Err() != nil {
+				yield(nil, ctx.Err())
+				return
+			}
+			switch {
+			case job.skipped != nil:
+				hlr.reportSkipped(job.skipped)
+				continue
+			case job.err != nil:
+				yield(nil, job.err)
+				return
+			case job.decodeErr != nil:
+				perr := &LogParseError{Line: job.line, Offset: job.offset, Err: job.decodeErr}
+				if err := hlr.failLine(perr); err != nil {
+					yield(nil, err)
+					return
+				}
+				continue
+			}
+			hlr.observeInterval(job.entry.Histogram)
+			hlr.acceptedLines++
+			if !yield(job.entry, nil) {
+				return
+			}
+		}
+		if cancelled {
+			yield(nil, ctx.Err())
+		}
+	}
+}
+
+// scanJobs splits the log into jobs, queueing each on pending in log order
+// and handing intervals to the workers on work. It returns false if ctx was
+// cancelled before the log was exhausted.
+func (hlr *HistogramLogReader) scanJobs(ctx context.Context, pending, work chan<- *decodeJob) bool {
+	send := func(ch chan<- *decodeJob, job *decodeJob) bool {
+		select {
+		case ch <- job:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	queueSkipped := true
+	skip := func(perr *LogParseError) {
+		queueSkipped = queueSkipped && send(pending, &decodeJob{skipped: perr})
+	}
+	for queueSkipped {
+		entry, payload, err := hlr.scanInterval(skip)
+		if err != nil {
+			return send(pending, &decodeJob{err: err})
+		}
+		if entry == nil {
+			return true
+		}
+		// The payload aliases the reader's buffers, which the next line reuses.
+		job := &decodeJob{
+			entry:   entry,
+			payload: append([]byte(nil), payload...),
+			line:    hlr.lineNumber,
+			offset:  hlr.lineOffset,
+			done:    make(chan struct{}),
+		}
+		if !send(pending, job) || !send(work, job) {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramLogReader_ParallelEntriesMatchesEntries(t *testing.T) {
+	files := []string{
+		"./test/jHiccup-2.0.7S.logV2.hlog",
+		"./test/tagged-Log.logV2.hlog",
+	}
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		var want []*LogEntry
+		for entry, err := range NewHistogramLogReader(bytes.NewReader(dat)).Entries() {
+			assert.Nil(t, err)
+			want = append(want, entry)
+		}
+		for _, parallelism := range []int{0, 1, 3, 16} {
+			reader := NewHistogramLogReader(bytes.NewReader(dat))
+			var got []*LogEntry
+			for entry, err := range reader.ParallelEntries(context.Background(), parallelism) {
+				assert.Nil(t, err)
+				got = append(got, entry)
+			}
+			assert.Equal(t, len(want), len(got), file)
+			for i := range want {
+				assert.True(t, want[i].Histogram.Equals(got[i].Histogram), "%s interval %d", file, i)
+				assert.Equal(t, want[i].Tag, got[i].Tag)
+				assert.Equal(t, want[i].AbsoluteStartTimeSec, got[i].AbsoluteStartTimeSec)
+			}
+			assert.Equal(t, int64(len(want)), reader.AcceptedLines())
+			assert.Equal(t, "1.2", reader.Header().FormatVersion)
+		}
+	}
+}
+
+func TestHistogramLogReader_ParallelEntriesStrict(t *testing.T) {
+	log, corruptLine, corruptOffset := corruptLog(t)
+	reader := NewHistogramLogReader(bytes.NewReader(log))
+	var entries int
+	var perr *LogParseError
+	for entry, err := range reader.ParallelEntries(context.Background(), 4) {
+		if err != nil {
+			assert.True(t, errors.As(err, &perr))
+			continue
+		}
+		assert.NotNil(t, entry)
+		entries++
+	}
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, corruptLine, perr.Line)
+	assert.Equal(t, corruptOffset, perr.Offset)
+}
+
+func TestHistogramLogReader_ParallelEntriesLenient(t *testing.T) {
+	log, _, _ := corruptLog(t)
+	reader := NewHistogramLogReader(bytes.NewReader(log))
+	reader.SetLenient(true)
+	var reported []int64
+	reader.SetErrorHandler(func(err *LogParseError) {
+		reported = append(reported, err.Line)
+	})
+	var entries int
+	for _, err := range reader.ParallelEntries(context.Background(), 4) {
+		assert.Nil(t, err)
+		entries++
+	}
+	assert.Equal(t, 3, entries)
+	assert.Equal(t, []int64{4, 6, 7}, reported)
+	assert.Equal(t, int64(3), reader.SkippedLines())
+}
+
+func TestHistogramLogReader_ParallelEntriesCancel(t *testing.T) {
+	dat, err := os.ReadFile("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for entry, err := range NewHistogramLogReader(bytes.NewReader(dat)).ParallelEntries(ctx, 2) {
+		assert.Nil(t, entry)
+		assert.Equal(t, context.Canceled, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var entries int
+	var last error
+	for entry, err := range NewHistogramLogReader(bytes.NewReader(dat)).ParallelEntries(ctx, 2) {
+		if err != nil {
+			last = err
+			continue
+		}
+		assert.NotNil(t, entry)
+		entries++
+		if entries == 5 {
+			cancel()
+		}
+	}
+	assert.Equal(t, context.Canceled, last)
+	assert.Less(t, entries, 62)
+}
+
+func TestHistogramLogReader_ParallelEntriesBreak(t *testing.T) {
+	dat, err := os.ReadFile("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+	var entries int
+	for _, err := range NewHistogramLogReader(bytes.NewReader(dat)).ParallelEntries(context.Background(), 4) {
+		assert.Nil(t, err)
+		entries++
+		break
+	}
+	assert.Equal(t, 1, entries)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r *bytes.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestHistogramLogReader_ParallelEntriesBackpressure(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	for i := 0; i < 2000; i++ {
+		h.SetStartTimeMs(int64(i) * 1000)
+		h.SetEndTimeMs(int64(i+1) * 1000)
+		h.SetTag(fmt.Sprint("tag", i%3))
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+	total := int64(b.Len())
+	src := &countingReader{r: bytes.NewReader(b.Bytes())}
+
+	var entries int
+	for _, err := range NewHistogramLogReader(src).ParallelEntries(context.Background(), 2) {
+		assert.Nil(t, err)
+		entries++
+		if entries == 1 {
+			// Give the pipeline time to run ahead as far as it is allowed to.
+			time.Sleep(50 * time.Millisecond)
+			assert.Less(t, src.n.Load(), total/10)
+		}
+	}
+	assert.Equal(t, 2000, entries)
+	assert.Equal(t, total, src.n.Load())
+}
@@ -0,0 +1,410 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramLogReader_ReadHeader(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	reader := NewHistogramLogReader(bytes.NewReader(dat))
+
+	header, err := reader.ReadHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2", header.FormatVersion)
+	assert.True(t, header.HasStartTime)
+	assert.Equal(t, 1441812279.474, header.StartTimeSec)
+	assert.Equal(t, "Wed Sep 09 08:24:39 PDT 2015", header.StartTimeText)
+	assert.Equal(t, time.UnixMilli(1441812279474), header.StartTime())
+	assert.False(t, header.HasBaseTime)
+	assert.Equal(t, `"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`, header.Legend)
+	assert.Equal(t, []string{"[Logged with jHiccup version 2.0.7-SNAPSHOT, manually edited to duplicate contents with Tag=A]"}, header.Comments)
+
+	// the interval line that ended the header is not lost
+	first, err := reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assertGoldenInterval0(t, first)
+}
+
+func TestHistogramLogReader_StartTimeSec(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	reader := NewHistogramLogReader(bytes.NewReader(dat))
+	assert.Equal(t, 0.0, reader.StartTimeSec())
+	_, err = reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assert.Equal(t, 1441812279.474, reader.StartTimeSec())
+	// interval timestamps are relative, so the base time is the start time
+	assert.Equal(t, 1441812279.474, reader.BaseTimeSec())
+
+	// without a StartTime line the first interval starts the log
+	reader = NewHistogramLogReader(bytes.NewReader([]byte("1000.5,1.0,2.0,HISTFAAAAEV42pNpmSzMwMCgyAABTBDKT4GBgdnNYMcCBvsPEBEJISEuATEZMQ4uASkhIR4nrxg9v2lMaxhvMekILGZkKmcCAEf2CsI=\n")))
+	_, err = reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000.5, reader.StartTimeSec())
+	assert.Equal(t, 0.0, reader.BaseTimeSec())
+}
+
+func TestHistogramLogReader_Entries(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	reader := NewHistogramLogReader(bytes.NewReader(dat))
+
+	var entries []*LogEntry
+	for entry, err := range reader.Entries() {
+		assert.Nil(t, err)
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, 42, len(entries))
+	assert.Equal(t, "1.2", reader.Header().FormatVersion)
+
+	first := entries[0]
+	assertGoldenInterval0(t, first.Histogram)
+	assert.Equal(t, "", first.Tag)
+	assert.Equal(t, 0.127, first.LogTimeStampSec)
+	assert.Equal(t, 1.007, first.IntervalLengthSec)
+	assert.Equal(t, 2.769, first.IntervalMax)
+	assert.InDelta(t, 0.127, first.RelativeStartTimeSec, 1e-6)
+	assert.Equal(t, time.UnixMilli(goldenIntv0StartMs), first.StartTime())
+	assert.Equal(t, time.UnixMilli(goldenIntv0EndMs), first.EndTime())
+
+	second := entries[1]
+	assert.Equal(t, "A", second.Tag)
+	assert.Equal(t, "A", second.Histogram.Tag())
+	assert.Equal(t, first.AbsoluteStartTimeSec, second.AbsoluteStartTimeSec)
+}
+
+func TestHistogramLogReader_EntriesStopsOnError(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+	b.WriteString("1.0,2.0,3.0,!!!notbase64!!!\n")
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+
+	var good, bad int
+	for entry, err := range NewHistogramLogReader(&b).Entries() {
+		if err != nil {
+			assert.Nil(t, entry)
+			bad++
+			continue
+		}
+		good++
+	}
+	assert.Equal(t, 1, good)
+	assert.Equal(t, 1, bad)
+}
+
+func TestHistogramLogReader_EntriesRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogFormatVersion())
+	assert.Nil(t, writer.OutputComment("recorded by a test"))
+	assert.Nil(t, writer.OutputStartTime(1000000))
+	assert.Nil(t, writer.OutputBaseTime(1000000))
+	assert.Nil(t, writer.OutputLegend())
+	writer.SetBaseTime(1000000)
+	for k := 0; k < 3; k++ {
+		h := New(1, 100000000, 3)
+		assert.Nil(t, h.RecordValue(int64(3000000*(k+1))))
+		h.SetStartTimeMs(int64(1000000 + 1000*k))
+		h.SetEndTimeMs(int64(1000000 + 1000*(k+1)))
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+
+	reader := NewHistogramLogReader(&b)
+	k := 0
+	for entry, err := range reader.Entries() {
+		assert.Nil(t, err)
+		assert.Equal(t, float64(k), entry.LogTimeStampSec)
+		assert.Equal(t, float64(k), entry.RelativeStartTimeSec)
+		assert.Equal(t, float64(1000+k), entry.AbsoluteStartTimeSec)
+		assert.InDelta(t, float64(3*(k+1)), entry.IntervalMax, 0.01)
+		k++
+		if k == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, k)
+
+	header := reader.Header()
+	assert.Equal(t, HISTOGRAM_LOG_FORMAT_VERSION, header.FormatVersion)
+	assert.Equal(t, []string{"recorded by a test"}, header.Comments)
+	assert.Equal(t, 1000.0, header.BaseTimeSec)
+	assert.True(t, header.HasBaseTime)
+	assert.Equal(t, "1970-01-01T00:16:40Z", header.StartTimeText)
+
+	// breaking out of the loop leaves the remaining intervals to be read
+	h, err := reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1002000), h.StartTimeMs())
+}
+
+func TestHistogramLogReader_ListTags(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	tags, err := NewHistogramLogReader(bytes.NewReader(dat)).ListTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "A"}, tags)
+
+	dat, err = os.ReadFile("./test/jHiccup-2.0.7S.logV2.hlog")
+	assert.Nil(t, err)
+	tags, err = NewHistogramLogReader(bytes.NewReader(dat)).ListTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{""}, tags)
+}
+
+func TestHistogramLogReader_SetTagFilter(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	cases := []struct {
+		name    string
+		filter  *TagFilter
+		wantTag string
+		want    int
+	}{
+		{"include", &TagFilter{Include: []string{"A"}}, "A", 21},
+		{"include untagged", &TagFilter{Include: []string{""}}, "", 21},
+		{"exclude untagged", &TagFilter{Exclude: []string{""}}, "A", 21},
+		{"untagged only", &TagFilter{UntaggedOnly: true}, "", 21},
+		{"include unknown", &TagFilter{Include: []string{"B"}}, "", 0},
+		{"include and exclude", &TagFilter{Include: []string{"A"}, Exclude: []string{"A"}}, "", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reader := NewHistogramLogReader(bytes.NewReader(dat))
+			reader.SetTagFilter(c.filter)
+			got := drainAllIntervals(t, reader)
+			assert.Equal(t, c.want, len(got))
+			for _, h := range got {
+				assert.Equal(t, c.wantTag, h.Tag())
+			}
+		})
+	}
+
+	reader := NewHistogramLogReader(bytes.NewReader(dat))
+	reader.SetTagFilter(&TagFilter{UntaggedOnly: true})
+	reader.SetTagFilter(nil)
+	assert.Equal(t, 42, len(drainAllIntervals(t, reader)))
+}
+
+func TestHistogramLogReader_AccumulateByTag(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+
+	all, err := NewHistogramLogReader(bytes.NewReader(dat)).AccumulateByTag(0, math.MaxFloat64, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, int64(16145), all[""].TotalCount())
+	assert.True(t, all[""].Equals(all["A"]))
+	assert.Equal(t, "A", all["A"].Tag())
+	assert.Equal(t, goldenIntv0StartMs, all["A"].StartTimeMs())
+
+	// relative to the log start time, the first five intervals start within 5 seconds
+	reader := NewHistogramLogReader(bytes.NewReader(dat))
+	reader.SetTagFilter(&TagFilter{Include: []string{"A"}})
+	firstSeconds, err := reader.AccumulateByTag(0, 5, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(firstSeconds))
+	intervals := drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(dat)))
+	want := New(intervals[0].LowestTrackableValue(), intervals[0].HighestTrackableValue(), int(intervals[0].SignificantFigures()))
+	for _, h := range intervals[:10] {
+		if h.Tag() == "A" {
+			want.Merge(h)
+		}
+	}
+	assert.Equal(t, want.TotalCount(), firstSeconds["A"].TotalCount())
+	assert.Equal(t, want.Max(), firstSeconds["A"].Max())
+}
+
+// Relative ranges are offsets from the log's StartTime, as in the Java
+// HistogramLogReader. Earlier releases added StartTime to the interval
+// timestamps instead of subtracting it, so no interval of a log with a
+// StartTime ever fell within a relative range.
+func TestHistogramLogReader_NextIntervalHistogramWithRange_relative(t *testing.T) {
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	payload, err := h.Encode(V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	var log bytes.Buffer
+	log.WriteString("#[StartTime: 1000.000 (seconds since epoch), Thu Jan 01 00:16:40 UTC 1970]\n")
+	for _, start := range []string{"1001.000", "1004.000", "1010.000"} {
+		fmt.Fprintf(&log, "%s,1.000,42.000,%s\n", start, payload)
+	}
+
+	reader := NewHistogramLogReader(bytes.NewReader(log.Bytes()))
+	var startsMs []int64
+	for {
+		interval, err := reader.NextIntervalHistogramWithRange(0, 5, false)
+		assert.Nil(t, err)
+		if interval == nil {
+			break
+		}
+		startsMs = append(startsMs, interval.StartTimeMs())
+	}
+	assert.Equal(t, []int64{1001000, 1004000}, startsMs)
+}
+
+func TestHistogramLogReader_SetTagFilter_headerlessTimes(t *testing.T) {
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	payload, err := h.Encode(V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	// no StartTime or BaseTime header, and a first line with an excluded tag
+	var log bytes.Buffer
+	for _, line := range []string{"Tag=B,1000.000", "Tag=A,1002.000", "Tag=A,1005.000"} {
+		fmt.Fprintf(&log, "%s,1.000,42.000,%s\n", line, payload)
+	}
+
+	relativeStarts := func(filter *TagFilter) (starts []float64) {
+		reader := NewHistogramLogReader(bytes.NewReader(log.Bytes()))
+		reader.SetTagFilter(filter)
+		for entry, err := range reader.Entries() {
+			assert.Nil(t, err)
+			if entry.Tag == "A" {
+				starts = append(starts, entry.RelativeStartTimeSec)
+			}
+		}
+		return
+	}
+	assert.Equal(t, []float64{2, 5}, relativeStarts(nil))
+	assert.Equal(t, []float64{2, 5}, relativeStarts(&TagFilter{Include: []string{"A"}}))
+
+	reader := NewHistogramLogReader(bytes.NewReader(log.Bytes()))
+	reader.SetTagFilter(&TagFilter{Exclude: []string{"B"}})
+	interval, err := reader.NextIntervalHistogramWithRange(0, 3, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1002000), interval.StartTimeMs())
+	interval, err = reader.NextIntervalHistogramWithRange(0, 3, false)
+	assert.Nil(t, err)
+	assert.Nil(t, interval)
+}
+
+func TestAccumulateInterval_grows(t *testing.T) {
+	narrow := New(1, 1000, 3)
+	assert.Nil(t, narrow.RecordValue(500))
+	narrow.SetStartTimeMs(2000)
+	narrow.SetEndTimeMs(3000)
+	wide := New(1, 1000000, 3)
+	assert.Nil(t, wide.RecordValue(900000))
+	wide.SetStartTimeMs(1000)
+	wide.SetEndTimeMs(2000)
+
+	acc := AccumulateInterval(nil, narrow)
+	acc = AccumulateInterval(acc, wide)
+	assert.Equal(t, int64(2), acc.TotalCount())
+	assert.Equal(t, int64(1000000), acc.HighestTrackableValue())
+	assert.Equal(t, int64(1000), acc.StartTimeMs())
+	assert.Equal(t, int64(3000), acc.EndTimeMs())
+}
+
+// corruptLog returns a log with three good intervals around a corrupt payload,
+// an unrecognised line and an unparsable timestamp, along with the line number
+// and byte offset of the corrupt payload line.
+func corruptLog(t *testing.T) (log []byte, corruptLine, corruptOffset int64) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogFormatVersion())
+	assert.Nil(t, writer.OutputLegend())
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+	corruptOffset = int64(b.Len())
+	b.WriteString("1.000,1.000,0.000,HISTFAAAAB542pNpmSzMwMDAxAABzFCaEUoz2X\n")
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+	b.WriteString("this is not an interval\n")
+	b.WriteString("1.0.0,1.000,0.000,HISTFAAAAB542pNpmSzMwMDAxAABzFCaEUoz2X+AMIKZAEARAtM=\n")
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+	return b.Bytes(), 4, corruptOffset
+}
+
+func TestHistogramLogReader_strictReportsPosition(t *testing.T) {
+	log, corruptLine, corruptOffset := corruptLog(t)
+	reader := NewHistogramLogReader(bytes.NewReader(log))
+	h, err := reader.NextIntervalHistogram()
+	assert.Nil(t, err)
+	assert.NotNil(t, h)
+
+	_, err = reader.NextIntervalHistogram()
+	var perr *LogParseError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, corruptLine, perr.Line)
+	assert.Equal(t, corruptOffset, perr.Offset)
+	assert.Contains(t, err.Error(), "line 4")
+	assert.Equal(t, int64(1), reader.AcceptedLines())
+}
+
+func TestHistogramLogReader_lenient(t *testing.T) {
+	log, corruptLine, corruptOffset := corruptLog(t)
+	reader := NewHistogramLogReader(bytes.NewReader(log))
+	reader.SetLenient(true)
+	var reported []*LogParseError
+	reader.SetErrorHandler(func(err *LogParseError) {
+		reported = append(reported, err)
+	})
+
+	got := drainAllIntervals(t, reader)
+	assert.Equal(t, 3, len(got))
+	assert.Equal(t, int64(3), reader.AcceptedLines())
+	assert.Equal(t, int64(3), reader.SkippedLines())
+	assert.Equal(t, 3, len(reported))
+	assert.Equal(t, corruptLine, reported[0].Line)
+	assert.Equal(t, corruptOffset, reported[0].Offset)
+	assert.Equal(t, []int64{4, 6, 7}, []int64{reported[0].Line, reported[1].Line, reported[2].Line})
+	for _, perr := range reported {
+		assert.Equal(t, log[perr.Offset-1], byte('\n'))
+	}
+}
+
+func TestHistogramLogReader_cleanCorpusSkipsNothing(t *testing.T) {
+	files := []string{
+		"./test/jHiccup-2.0.7S.logV2.hlog",
+		"./test/tagged-Log.logV2.hlog",
+	}
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		reader := NewHistogramLogReader(bytes.NewReader(dat))
+		n := len(drainAllIntervals(t, reader))
+		assert.Equal(t, int64(n), reader.AcceptedLines())
+		assert.Equal(t, int64(0), reader.SkippedLines(), file)
+	}
+}
+
+func TestHistogramLogReader_gzip(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(dat)
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+
+	want := drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(dat)))
+	reader := NewHistogramLogReader(bytes.NewReader(compressed.Bytes()))
+	got := drainAllIntervals(t, reader)
+	assert.Equal(t, want, got)
+	assert.Equal(t, "1.2", reader.Header().FormatVersion)
+
+	// a log too short to hold the magic bytes is still read as text
+	h, err := NewHistogramLogReader(bytes.NewReader([]byte{0x1f})).NextIntervalHistogram()
+	assert.Nil(t, h)
+	assert.Nil(t, err)
+
+	// a corrupt gzip stream is an error
+	_, err = NewHistogramLogReader(bytes.NewReader(compressed.Bytes()[:20])).NextIntervalHistogram()
+	assert.NotNil(t, err)
+
+	_, err = NewSeekableLogReader(bytes.NewReader(compressed.Bytes()))
+	assert.NotNil(t, err)
+}
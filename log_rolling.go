@@ -0,0 +1,267 @@
+package hdrhistogram
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RollingLogOptions configures a rolling histogram log.
+type RollingLogOptions struct {
+	// MaxSize rotates the log before a line would grow the current file past
+	// MaxSize bytes. Zero disables rotation by size.
+	MaxSize int64
+	// Period rotates the log once the current file has been open for Period,
+	// when the next line is written. Zero disables rotation by time.
+	Period time.Duration
+	// MaxFiles is the number of files kept, including the current one; older
+	// files are deleted. Zero keeps every file.
+	MaxFiles int
+	// StartTime and BaseTime are written in the header of every file.
+	// StartTime defaults to the time the log is opened. Intervals are logged
+	// relative to BaseTime when it is non-zero, and with absolute timestamps
+	// otherwise.
+	StartTime time.Time
+	BaseTime  time.Time
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewRollingHistogramLogWriter returns a writer of a histogram log that rolls
+// over to a new file by size or by period. The current file is always path;
+// on rotation it is renamed to path.1, path.1 to path.2 and so on, as
+// logrotate does, so NewHistogramLogFollower keeps following path across
+// rotations. A file already at path is rotated away first.
+//
+// Every file starts with the same log header, as written by OutputLogHeader,
+// so each one can be read on its own. NewRollingHistogramLogReader reads the
+// series back as a single log. Close the writer to close the current file.
+func NewRollingHistogramLogWriter(path string, options *RollingLogOptions) (*HistogramLogWriter, error) {
+	r := &rollingLogFile{path: path}
+	if options != nil {
+		r.options = *options
+	}
+	if r.options.MaxSize < 0 || r.options.Period < 0 || r.options.MaxFiles < 0 {
+		return nil, errors.New("rolling log limits cannot be negative")
+	}
+	if r.options.Now == nil {
+		r.options.Now = time.Now
+	}
+	if r.options.StartTime.IsZero() {
+		r.options.StartTime = r.options.Now()
+	}
+	var baseTime int64
+	if !r.options.BaseTime.IsZero() {
+		baseTime = r.options.BaseTime.UnixMilli()
+	}
+	r.header = appendLogFormatVersion(nil)
+	r.header = appendStartTime(r.header, r.options.StartTime.UnixMilli())
+	r.header = appendBaseTime(r.header, baseTime)
+	r.header = appendLegend(r.header)
+
+	if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+		if err = r.shift(); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return &HistogramLogWriter{baseTime: baseTime, log: r, closer: r}, nil
+}
+
+// rollingLogFile is the io.Writer behind a rolling log. HistogramLogWriter
+// hands it whole lines, so rotation only ever happens between lines.
+type rollingLogFile struct {
+	path    string
+	options RollingLogOptions
+	header  []byte
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+func (r *rollingLogFile) Write(p []byte) (n int, err error) {
+	if r.file == nil {
+		return 0, errLogWriterClosed
+	}
+	if r.due(len(p)) {
+		if err = r.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+// due reports whether the current file should be rotated before writing n
+// more bytes. A file holding nothing but its header is never rotated.
+func (r *rollingLogFile) due(n int) bool {
+	if r.size <= int64(len(r.header)) {
+		return false
+	}
+	if r.options.MaxSize > 0 && r.size+int64(n) > r.options.MaxSize {
+		return true
+	}
+	return r.options.Period > 0 && !r.options.Now().Before(r.opened.Add(r.options.Period))
+}
+
+func (r *rollingLogFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	if err := r.shift(); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// shift renames path to path.1, path.1 to path.2 and so on, deleting the
+// files beyond MaxFiles.
+func (r *rollingLogFile) shift() error {
+	last := 0
+	for {
+		if _, err := os.Stat(rolledLogName(r.path, last+1)); err != nil {
+			break
+		}
+		last++
+	}
+	if r.options.MaxFiles > 0 {
+		for i := r.options.MaxFiles - 1; i <= last; i++ {
+			if err := os.Remove(rolledLogName(r.path, i)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if last > r.options.MaxFiles-2 {
+			last = r.options.MaxFiles - 2
+		}
+	}
+	for i := last; i >= 0; i-- {
+		if err := os.Rename(rolledLogName(r.path, i), rolledLogName(r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rollingLogFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.opened = r.options.Now()
+	n, err := file.Write(r.header)
+	r.size = int64(n)
+	return err
+}
+
+func (r *rollingLogFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// rolledLogName returns the name of the i-th most recently rotated file of a
+// rolling log, where the current file is the 0th.
+func rolledLogName(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// RollingLogFiles returns the files of the rolling log at path, oldest first.
+func RollingLogFiles(path string) (files []string) {
+	last := 0
+	for {
+		if _, err := os.Stat(rolledLogName(path, last+1)); err != nil {
+			break
+		}
+		last++
+	}
+	for i := last; i > 0; i-- {
+		files = append(files, rolledLogName(path, i))
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return
+}
+
+// NewRollingHistogramLogReader returns a reader over the files of the rolling
+// log at path, oldest first, stitched back into one chronological log. A file
+// whose last line lacks its newline, as left by a crash, ends that line rather
+// than running into the next file. Line numbers and byte offsets run on across
+// the files. Call Close to release the files.
+func NewRollingHistogramLogReader(path string) (*HistogramLogReader, error) {
+	names := RollingLogFiles(path)
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	var files multiCloser
+	readers := make([]io.Reader, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files = append(files, file)
+		readers = append(readers, &lineTerminatedReader{r: file})
+	}
+	hlr := NewHistogramLogReader(io.MultiReader(readers...))
+	hlr.closer = files
+	return hlr, nil
+}
+
+// multiCloser closes a list of files.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() (err error) {
+	for _, c := range m {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// lineTerminatedReader reads r, adding a newline at its end if the last line
+// read lacks one.
+type lineTerminatedReader struct {
+	r       io.Reader
+	partial bool
+	done    bool
+}
+
+func (l *lineTerminatedReader) Read(p []byte) (n int, err error) {
+	if l.done {
+		return 0, io.EOF
+	}
+	n, err = l.r.Read(p)
+	if n > 0 {
+		l.partial = p[n-1] != '\n'
+	}
+	if err != io.EOF {
+		return
+	}
+	if l.partial {
+		if n == len(p) {
+			// No room left for the newline; add it on the next call.
+			return n, nil
+		}
+		p[n] = '\n'
+		n++
+		l.partial = false
+	}
+	l.done = true
+	return
+}
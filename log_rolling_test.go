@@ -0,0 +1,190 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rollingStartMs = 1441812279000
+
+// writeRollingIntervals logs n one second intervals, recording i+1 in the i-th.
+func writeRollingIntervals(t *testing.T, writer *HistogramLogWriter, from, n int) {
+	for i := from; i < from+n; i++ {
+		h := New(1, 1000, 3)
+		assert.Nil(t, h.RecordValue(int64(i+1)))
+		h.SetStartTimeMs(rollingStartMs + int64(i)*1000)
+		h.SetEndTimeMs(rollingStartMs + int64(i+1)*1000)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+}
+
+func readRollingLog(t *testing.T, path string) []*Histogram {
+	reader, err := NewRollingHistogramLogReader(path)
+	assert.Nil(t, err)
+	defer reader.Close()
+	return drainAllIntervals(t, reader)
+}
+
+func TestRollingHistogramLogWriter_size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{
+		MaxSize:   600,
+		StartTime: time.UnixMilli(rollingStartMs),
+		BaseTime:  time.UnixMilli(rollingStartMs),
+	})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 0, 10)
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, errLogWriterClosed, writer.OutputComment("late"))
+
+	files := RollingLogFiles(path)
+	assert.Greater(t, len(files), 2)
+	assert.Equal(t, path, files[len(files)-1])
+	var perFile int
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(dat), 600)
+		// every file carries the header and reads on its own
+		reader := NewHistogramLogReader(bytes.NewReader(dat))
+		intervals := drainAllIntervals(t, reader)
+		assert.NotEmpty(t, intervals)
+		perFile += len(intervals)
+		assert.Equal(t, "1.3", reader.Header().FormatVersion)
+		assert.Equal(t, float64(rollingStartMs/1000), reader.Header().StartTimeSec)
+		assert.Equal(t, float64(rollingStartMs/1000), reader.Header().BaseTimeSec)
+	}
+	assert.Equal(t, 10, perFile)
+
+	all := readRollingLog(t, path)
+	assert.Equal(t, 10, len(all))
+	for i, h := range all {
+		assert.Equal(t, int64(i+1), h.Max())
+		assert.Equal(t, rollingStartMs+int64(i)*1000, h.StartTimeMs())
+	}
+}
+
+func TestRollingHistogramLogWriter_maxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{MaxSize: 1, MaxFiles: 3, StartTime: time.UnixMilli(rollingStartMs)})
+	assert.Nil(t, err)
+	// with a tiny size limit every interval gets a file of its own
+	writeRollingIntervals(t, writer, 0, 7)
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, []string{path + ".2", path + ".1", path}, RollingLogFiles(path))
+	all := readRollingLog(t, path)
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, int64(5), all[0].Max())
+	assert.Equal(t, int64(7), all[2].Max())
+}
+
+func TestRollingHistogramLogWriter_period(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	now := time.UnixMilli(rollingStartMs)
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{
+		Period: time.Hour,
+		Now:    func() time.Time { return now },
+	})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 0, 3)
+	now = now.Add(59 * time.Minute)
+	writeRollingIntervals(t, writer, 3, 1)
+	assert.Equal(t, 1, len(RollingLogFiles(path)))
+	now = now.Add(time.Minute)
+	writeRollingIntervals(t, writer, 4, 2)
+	now = now.Add(time.Hour)
+	writeRollingIntervals(t, writer, 6, 1)
+	assert.Nil(t, writer.Close())
+
+	files := RollingLogFiles(path)
+	assert.Equal(t, 3, len(files))
+	counts := []int{}
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		counts = append(counts, len(drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(dat)))))
+	}
+	assert.Equal(t, []int{4, 2, 1}, counts)
+
+	// the start time defaults to when the log was opened
+	reader, err := NewRollingHistogramLogReader(path)
+	assert.Nil(t, err)
+	defer reader.Close()
+	header, err := reader.ReadHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, float64(rollingStartMs/1000), header.StartTimeSec)
+}
+
+func TestRollingHistogramLogWriter_existingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{StartTime: time.UnixMilli(rollingStartMs)})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 0, 2)
+	assert.Nil(t, writer.Close())
+
+	// a restarted process continues the series rather than overwriting it
+	writer, err = NewRollingHistogramLogWriter(path, &RollingLogOptions{StartTime: time.UnixMilli(rollingStartMs)})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 2, 2)
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, []string{path + ".1", path}, RollingLogFiles(path))
+	assert.Equal(t, 4, len(readRollingLog(t, path)))
+
+	_, err = NewRollingHistogramLogReader(filepath.Join(t.TempDir(), "missing.hlog"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = NewRollingHistogramLogWriter(path, &RollingLogOptions{MaxFiles: -1})
+	assert.NotNil(t, err)
+}
+
+func TestRollingHistogramLogWriter_subSecondBaseTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	base := time.UnixMilli(rollingStartMs - 500)
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{MaxSize: 1, StartTime: base, BaseTime: base})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 0, 2)
+	assert.Nil(t, writer.Close())
+	all := readRollingLog(t, path)
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, int64(rollingStartMs), all[0].StartTimeMs())
+	assert.Equal(t, int64(rollingStartMs+2000), all[1].EndTimeMs())
+}
+
+func TestRollingHistogramLogReader_unterminatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolling.hlog")
+	writer, err := NewRollingHistogramLogWriter(path, &RollingLogOptions{MaxSize: 1, StartTime: time.UnixMilli(rollingStartMs)})
+	assert.Nil(t, err)
+	writeRollingIntervals(t, writer, 0, 2)
+	assert.Nil(t, writer.Close())
+
+	// a crash left the last line of the older file without its newline
+	older := path + ".1"
+	dat, err := os.ReadFile(older)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(older, bytes.TrimSuffix(dat, []byte("\n")), 0o644))
+	all := readRollingLog(t, path)
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, int64(1), all[0].Max())
+	assert.Equal(t, int64(2), all[1].Max())
+
+	// lines are terminated whatever the size of the reads
+	for _, size := range []int{1, len(dat) - 2, len(dat) - 1, len(dat)} {
+		r := &lineTerminatedReader{r: bytes.NewReader(dat[:len(dat)-1])}
+		var got bytes.Buffer
+		p := make([]byte, size)
+		for {
+			n, err := r.Read(p)
+			got.Write(p[:n])
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, string(dat), got.String())
+	}
+}
@@ -0,0 +1,222 @@
+package hdrhistogram
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultTimeSeriesPercentiles are the percentiles TimeSeries reports when
+// given none.
+var DefaultTimeSeriesPercentiles = []float64{50, 90, 99, 99.9}
+
+// TimeSeriesOptions configures TimeSeries.
+type TimeSeriesOptions struct {
+	// Step is the length of the steps of the series, aligned to multiples of
+	// Step since the epoch so series of different logs line up. Zero uses the
+	// length of the first interval read, rounded to a millisecond.
+	Step time.Duration
+	// Smoothing, if above one, reports at every step the distribution
+	// accumulated over the last Smoothing steps rather than the step alone.
+	Smoothing int
+	// RangeStartTimeSec and RangeEndTimeSec limit the series to intervals
+	// within the range, in seconds since the log StartTime, as done by
+	// NextIntervalHistogramWithRange. A zero RangeEndTimeSec reads to the end
+	// of the log.
+	RangeStartTimeSec float64
+	RangeEndTimeSec   float64
+}
+
+// TimeSeriesPoint is a step of a time series.
+type TimeSeriesPoint struct {
+	// StartTimeMs and EndTimeMs bound the step, in milliseconds since the
+	// epoch. With smoothing the point describes the steps ending at EndTimeMs.
+	StartTimeMs int64
+	EndTimeMs   int64
+	// Count is the number of values recorded.
+	Count int64
+	// Throughput is Count per second.
+	Throughput float64
+	// Max is the highest value recorded, and Values holds the value at each
+	// of the series' percentiles, in their order. Both are left empty when
+	// Count is zero.
+	Max    int64
+	Values []int64
+}
+
+// Empty reports whether no values were recorded in the point's steps,
+// either because the log has no interval for them or because its intervals
+// are empty.
+func (p *TimeSeriesPoint) Empty() bool {
+	return p.Count == 0
+}
+
+// TimeSeries reads the intervals of log and returns, per tag (the empty string
+// for untagged intervals), the series of count, throughput, max and the given
+// percentiles over time, as used for plotting and alerting.
+//
+// Intervals are placed by their start time into aligned steps of
+// options.Step, and those of a tag falling into the same step are merged. All
+// the series are aligned: they run from the first step holding any interval to
+// the last, and their i-th points cover the same span of time. Steps for which
+// a tag has no data are not skipped but reported as Empty points, with a nil
+// Values, so gaps can't be taken for zero latencies. A run of steps in which no
+// tag has data, past the smoothing window, is reported as a single Empty point
+// spanning it, so a jump in the log's timestamps costs one point rather than
+// one per step.
+//
+// Intervals must be listed in order of start time; one starting in a step
+// that was already reported fails the series. The reader's tag filter and
+// lenient mode are honoured. A nil options uses the defaults.
+func TimeSeries(log *HistogramLogReader, percentiles []float64, options *TimeSeriesOptions) (series map[string][]TimeSeriesPoint, err error) {
+	if options == nil {
+		options = &TimeSeriesOptions{}
+	}
+	if options.Step < 0 {
+		return nil, errors.New("time series step must not be negative")
+	}
+	if percentiles == nil {
+		percentiles = DefaultTimeSeriesPercentiles
+	}
+	b := &timeSeriesBuilder{
+		percentiles: percentiles,
+		stepMs:      options.Step.Milliseconds(),
+		smoothing:   max(options.Smoothing, 1),
+		series:      make(map[string][]TimeSeriesPoint),
+		recent:      make(map[string][]*Histogram),
+	}
+	rangeEndTimeSec := options.RangeEndTimeSec
+	if rangeEndTimeSec == 0 {
+		rangeEndTimeSec = math.MaxFloat64
+	}
+	for {
+		var h *Histogram
+		h, err = log.NextIntervalHistogramWithRange(options.RangeStartTimeSec, rangeEndTimeSec, false)
+		if err != nil {
+			return nil, err
+		}
+		if h == nil {
+			break
+		}
+		if err = b.add(h); err != nil {
+			return nil, err
+		}
+	}
+	if b.started {
+		b.emit()
+	}
+	return b.series, nil
+}
+
+// timeSeriesBuilder builds time series from intervals arriving in order of
+// start time, so only the latest step is ever open.
+type timeSeriesBuilder struct {
+	percentiles []float64
+	stepMs      int64
+	smoothing   int
+	series      map[string][]TimeSeriesPoint
+	// recent holds every tag's histograms of the last smoothing steps, the
+	// step i since the first one at i % smoothing, nil where it had none.
+	recent map[string][]*Histogram
+
+	started bool
+	first   int64
+	step    int64
+	open    map[string]*Histogram
+}
+
+func (b *timeSeriesBuilder) add(interval *Histogram) error {
+	if b.stepMs == 0 {
+		b.stepMs = max(interval.EndTimeMs()-interval.StartTimeMs(), 1)
+	}
+	step := alignedWindow(interval.StartTimeMs(), b.stepMs)
+	switch {
+	case !b.started:
+		b.started, b.first, b.step, b.open = true, step, step, make(map[string]*Histogram)
+	case step < b.step:
+		return fmt.Errorf("interval starting at %v is out of order: the step from %v has been reported",
+			time.UnixMilli(interval.StartTimeMs()).UTC(), time.UnixMilli(b.step*b.stepMs).UTC())
+	}
+	last := b.step
+	for b.step < step {
+		b.emit()
+		b.step++
+		// Once the last step with data has left the smoothing window, the
+		// rest of the gap is empty in every series.
+		if step-b.step > 1 && b.step-last >= int64(b.smoothing) {
+			b.emitGap(step)
+		}
+	}
+	tag := interval.Tag()
+	b.open[tag] = AccumulateInterval(b.open[tag], interval)
+	return nil
+}
+
+// emit appends the open step to every series, and clears it.
+func (b *timeSeriesBuilder) emit() {
+	for tag := range b.open {
+		if _, ok := b.series[tag]; !ok {
+			// The tag's series starts with the points before its first
+			// interval, all empty.
+			var points []TimeSeriesPoint
+			for _, other := range b.series {
+				points = make([]TimeSeriesPoint, len(other))
+				for i, p := range other {
+					points[i] = TimeSeriesPoint{StartTimeMs: p.StartTimeMs, EndTimeMs: p.EndTimeMs}
+				}
+				break
+			}
+			b.series[tag] = points
+			b.recent[tag] = make([]*Histogram, b.smoothing)
+		}
+	}
+	for tag, recent := range b.recent {
+		recent[(b.step-b.first)%int64(b.smoothing)] = b.open[tag]
+		b.series[tag] = append(b.series[tag], b.point(recent, b.step))
+	}
+	clear(b.open)
+}
+
+// emitGap appends a single Empty point to every series, spanning the steps
+// from the current one up to end, and moves on to end.
+func (b *timeSeriesBuilder) emitGap(end int64) {
+	for tag, recent := range b.recent {
+		clear(recent)
+		b.series[tag] = append(b.series[tag], TimeSeriesPoint{StartTimeMs: b.step * b.stepMs, EndTimeMs: end * b.stepMs})
+	}
+	b.step = end
+}
+
+// point returns the point of the given step, over the histograms of recent.
+func (b *timeSeriesBuilder) point(recent []*Histogram, step int64) TimeSeriesPoint {
+	p := TimeSeriesPoint{
+		StartTimeMs: step * b.stepMs,
+		EndTimeMs:   (step + 1) * b.stepMs,
+	}
+	var acc *Histogram
+	for _, h := range recent {
+		switch {
+		case h == nil:
+		case b.smoothing == 1:
+			acc = h
+		default:
+			acc = AccumulateInterval(acc, h)
+		}
+	}
+	// Early on fewer than smoothing steps have been seen.
+	steps := min(int64(b.smoothing), step-b.first+1)
+	if acc != nil {
+		p.Count = acc.TotalCount()
+	}
+	p.Throughput = float64(p.Count) / (float64(steps*b.stepMs) / 1000)
+	if p.Count == 0 {
+		return p
+	}
+	p.Max = acc.Max()
+	p.Values = make([]int64, len(b.percentiles))
+	for i, percentile := range b.percentiles {
+		p.Values[i] = acc.ValueAtPercentile(percentile)
+	}
+	return p
+}
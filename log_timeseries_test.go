@@ -0,0 +1,173 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seriesLog returns a log of one second intervals from startMs, recording
+// values[i] once in the i-th interval of every tag, or nothing where it is
+// zero. Intervals of tags with fewer values are left out of the log.
+func seriesLog(t *testing.T, startMs int64, values map[string][]int64) *HistogramLogReader {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogHeader(startMs, startMs))
+	for i := 0; ; i++ {
+		written := false
+		for _, tag := range []string{"", "A"} {
+			if i >= len(values[tag]) {
+				continue
+			}
+			h := New(1, 1000, 3)
+			if values[tag][i] > 0 {
+				assert.Nil(t, h.RecordValue(values[tag][i]))
+			}
+			h.SetStartTimeMs(startMs + int64(i)*1000)
+			h.SetEndTimeMs(startMs + int64(i+1)*1000)
+			h.SetTag(tag)
+			assert.Nil(t, writer.OutputIntervalHistogram(h))
+			written = true
+		}
+		if !written {
+			return NewHistogramLogReader(bytes.NewReader(b.Bytes()))
+		}
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	const startMs = 1441812280000
+	series, err := TimeSeries(seriesLog(t, startMs, map[string][]int64{
+		"":  {10, 0, 30, 40},
+		"A": {5},
+	}), []float64{50, 100}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(series))
+
+	untagged := series[""]
+	assert.Equal(t, 4, len(untagged))
+	for i, p := range untagged {
+		assert.Equal(t, startMs+int64(i)*1000, p.StartTimeMs)
+		assert.Equal(t, startMs+int64(i+1)*1000, p.EndTimeMs)
+	}
+	assert.Equal(t, TimeSeriesPoint{startMs, startMs + 1000, 1, 1, 10, []int64{10, 10}}, untagged[0])
+	// an empty interval is reported as such
+	assert.True(t, untagged[1].Empty())
+	assert.Nil(t, untagged[1].Values)
+	assert.Equal(t, []int64{40, 40}, untagged[3].Values)
+
+	// the series are aligned, with the steps missing from the log empty
+	tagged := series["A"]
+	assert.Equal(t, 4, len(tagged))
+	assert.Equal(t, []int64{5, 5}, tagged[0].Values)
+	for _, p := range tagged[1:] {
+		assert.True(t, p.Empty())
+		assert.Equal(t, 0.0, p.Throughput)
+	}
+}
+
+func TestTimeSeries_smoothing(t *testing.T) {
+	const startMs = 1441812280000
+	series, err := TimeSeries(seriesLog(t, startMs, map[string][]int64{
+		"": {10, 0, 30, 0, 0, 0},
+	}), []float64{100}, &TimeSeriesOptions{Step: 2 * time.Second, Smoothing: 2})
+	assert.Nil(t, err)
+	points := series[""]
+	assert.Equal(t, 3, len(points))
+	// the first step has no step before it to smooth over
+	assert.Equal(t, int64(1), points[0].Count)
+	assert.Equal(t, 0.5, points[0].Throughput)
+	assert.Equal(t, []int64{10}, points[0].Values)
+	assert.Equal(t, int64(2), points[1].Count)
+	assert.Equal(t, 0.5, points[1].Throughput)
+	assert.Equal(t, []int64{30}, points[1].Values)
+	assert.Equal(t, int64(1), points[2].Count)
+	assert.Equal(t, 0.25, points[2].Throughput)
+}
+
+func TestTimeSeries_gap(t *testing.T) {
+	// a first interval of a millisecond, then one a year later, and a tag
+	// first seen after the gap
+	const startMs = 1441812280000
+	const gapMs = 365 * 24 * 3600 * 1000
+	var log bytes.Buffer
+	writer := NewHistogramLogWriter(&log)
+	for _, iv := range []struct {
+		tag     string
+		startMs int64
+	}{{"", startMs}, {"", startMs + gapMs}, {"A", startMs + gapMs + 1}} {
+		h := New(1, 1000, 3)
+		assert.Nil(t, h.RecordValue(10))
+		h.SetStartTimeMs(iv.startMs)
+		h.SetEndTimeMs(iv.startMs + 1)
+		h.SetTag(iv.tag)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+
+	for _, smoothing := range []int{1, 3} {
+		series, err := TimeSeries(NewHistogramLogReader(bytes.NewReader(log.Bytes())), nil, &TimeSeriesOptions{Smoothing: smoothing})
+		assert.Nil(t, err)
+		untagged, tagged := series[""], series["A"]
+		// the steps still smoothed over, a single gap point, and the two
+		// steps after it
+		assert.Equal(t, smoothing+3, len(untagged))
+		assert.Equal(t, len(untagged), len(tagged))
+		gap := untagged[smoothing]
+		assert.True(t, gap.Empty())
+		assert.Equal(t, startMs+int64(smoothing), gap.StartTimeMs)
+		assert.Equal(t, int64(startMs+gapMs), gap.EndTimeMs)
+		for i, p := range untagged {
+			if i > 0 {
+				assert.Equal(t, untagged[i-1].EndTimeMs, p.StartTimeMs)
+			}
+			assert.Equal(t, p.StartTimeMs, tagged[i].StartTimeMs)
+			assert.Equal(t, p.EndTimeMs, tagged[i].EndTimeMs)
+		}
+		assert.Equal(t, int64(1), untagged[smoothing+1].Count)
+		assert.Equal(t, int64(1), tagged[len(tagged)-1].Count)
+	}
+}
+
+func TestTimeSeries_corpus(t *testing.T) {
+	dat, err := os.ReadFile("./test/tagged-Log.logV2.hlog")
+	assert.Nil(t, err)
+	series, err := TimeSeries(NewHistogramLogReader(bytes.NewReader(dat)), nil, &TimeSeriesOptions{Step: 5 * time.Second})
+	assert.Nil(t, err)
+	accumulated, err := NewHistogramLogReader(bytes.NewReader(dat)).AccumulateByTag(0, 1e12, true)
+	assert.Nil(t, err)
+	assert.Equal(t, len(accumulated), len(series))
+	for tag, h := range accumulated {
+		var count, maxValue int64
+		for _, p := range series[tag] {
+			assert.Equal(t, int64(5000), p.EndTimeMs-p.StartTimeMs)
+			count += p.Count
+			maxValue = max(maxValue, p.Max)
+			if !p.Empty() {
+				assert.Equal(t, len(DefaultTimeSeriesPercentiles), len(p.Values))
+			}
+		}
+		assert.Equal(t, h.TotalCount(), count)
+		assert.Equal(t, h.Max(), maxValue)
+		assert.Equal(t, len(series[""]), len(series[tag]))
+	}
+}
+
+func TestTimeSeries_errors(t *testing.T) {
+	_, err := TimeSeries(seriesLog(t, 0, nil), nil, &TimeSeriesOptions{Step: -time.Second})
+	assert.NotNil(t, err)
+
+	var log bytes.Buffer
+	writer := NewHistogramLogWriter(&log)
+	for _, startMs := range []int64{1000000, 1003000, 1001000} {
+		h := New(1, 1000, 3)
+		h.SetStartTimeMs(startMs)
+		h.SetEndTimeMs(startMs + 1000)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+	_, err = TimeSeries(NewHistogramLogReader(&log), nil, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "out of order")
+}
@@ -0,0 +1,181 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// logLineKind classifies a line of a histogram log.
+type logLineKind int
+
+const (
+	logLineBlank logLineKind = iota
+	logLineComment
+	logLineStartTime
+	logLineBaseTime
+	logLineFormatVersion
+	logLineLegend
+	logLineInterval
+	logLineUnrecognised
+)
+
+var (
+	//# "#[StartTime: %f (seconds since epoch), %s]\n"
+	startTimePrefix = []byte("#[StartTime: ")
+	//# "#[BaseTime: %f (seconds since epoch)]\n"
+	baseTimePrefix = []byte("#[BaseTime: ")
+	//# "#[Histogram log format version %s]\n"
+	formatVersionPrefix = []byte("#[Histogram log format version ")
+	legendPrefix        = []byte(`"StartTimestamp"`)
+	tagPrefix           = []byte("Tag=")
+	secondsSinceEpoch   = []byte(" (seconds since epoch), ")
+)
+
+// logLine is a tokenized histogram log line. All of its slices alias the line
+// it was tokenized from, so a logLine is only valid until that buffer is reused.
+type logLine struct {
+	kind logLineKind
+
+	// timestamp is the StartTime or BaseTime value. text is the human-readable
+	// date of a StartTime line, the format version, a comment without its
+	// leading '#' or the legend. problem says why a line is unrecognised.
+	timestamp []byte
+	text      []byte
+	problem   string
+
+	// Interval lines:
+	//# 0.127,1.007,2.769,HISTFAAAAEV42pNpmSz...
+	//# Tag=A,0.127,1.007,2.769,HISTFAAAAEV42pNpmSz...
+	hasTag  bool
+	tag     []byte
+	start   []byte
+	length  []byte
+	max     []byte
+	payload []byte
+}
+
+// tokenizeLogLine splits line into tok without copying or allocating. The
+// trailing line terminator, if any, is ignored.
+func tokenizeLogLine(line []byte, tok *logLine) {
+	*tok = logLine{}
+	line = bytes.TrimRight(line, "\r\n")
+	switch {
+	case len(bytes.TrimSpace(line)) == 0:
+		tok.kind = logLineBlank
+	case line[0] == '#':
+		tokenizeCommentLine(line, tok)
+	case bytes.HasPrefix(line, legendPrefix):
+		tok.kind = logLineLegend
+		tok.text = line
+	default:
+		tokenizeIntervalLine(line, tok)
+	}
+}
+
+func tokenizeCommentLine(line []byte, tok *logLine) {
+	switch {
+	case bytes.HasPrefix(line, startTimePrefix):
+		tok.kind = logLineStartTime
+		rest := line[len(startTimePrefix):]
+		tok.timestamp, rest = splitDecimal(rest)
+		if bytes.HasPrefix(rest, secondsSinceEpoch) {
+			text := rest[len(secondsSinceEpoch):]
+			if end := bytes.IndexByte(text, ']'); end >= 0 {
+				text = text[:end]
+			}
+			tok.text = text
+		}
+	case bytes.HasPrefix(line, baseTimePrefix):
+		tok.kind = logLineBaseTime
+		tok.timestamp, _ = splitDecimal(line[len(baseTimePrefix):])
+	case bytes.HasPrefix(line, formatVersionPrefix):
+		if end := bytes.IndexByte(line, ']'); end >= len(formatVersionPrefix) {
+			tok.kind = logLineFormatVersion
+			tok.text = line[len(formatVersionPrefix):end]
+			return
+		}
+		fallthrough
+	default:
+		tok.kind = logLineComment
+		tok.text = line[1:]
+	}
+}
+
+func tokenizeIntervalLine(line []byte, tok *logLine) {
+	if bytes.HasPrefix(line, tagPrefix) {
+		commaPos := bytes.IndexByte(line, ',')
+		if commaPos < 0 {
+			tok.kind = logLineUnrecognised
+			tok.problem = "tag without an interval"
+			return
+		}
+		tok.hasTag = true
+		tok.tag = line[len(tagPrefix):commaPos]
+		line = line[commaPos+1:]
+	}
+	start, rest, ok := splitDecimalField(line)
+	var length, max []byte
+	if ok {
+		if length, rest, ok = splitDecimalField(rest); ok {
+			max, rest, ok = splitDecimalField(rest)
+		}
+	}
+	if !ok {
+		*tok = logLine{kind: logLineUnrecognised, problem: "unrecognised line"}
+		return
+	}
+	tok.kind = logLineInterval
+	tok.start, tok.length, tok.max, tok.payload = start, length, max, rest
+}
+
+// splitDecimal splits the leading run of digits and dots off b.
+func splitDecimal(b []byte) (decimal, rest []byte) {
+	i := 0
+	for i < len(b) && (b[i] >= '0' && b[i] <= '9' || b[i] == '.') {
+		i++
+	}
+	return b[:i], b[i:]
+}
+
+// splitDecimalField splits a comma terminated run of digits and dots off b.
+func splitDecimalField(b []byte) (decimal, rest []byte, ok bool) {
+	decimal, rest = splitDecimal(b)
+	if len(rest) == 0 || rest[0] != ',' {
+		return nil, nil, false
+	}
+	return decimal, rest[1:], true
+}
+
+// parseLogDecimal parses a run of digits and dots as written for log timestamps.
+// Up to 15 significant digits, which covers every timestamp the log writers
+// emit, are converted exactly without allocating: both the integer mantissa and
+// the power of ten are exact float64 values, so a single division rounds
+// correctly, just as strconv.ParseFloat would. Anything else, including errors,
+// is left to strconv.
+func parseLogDecimal(b []byte) (float64, error) {
+	var mantissa uint64
+	digits, fraction := 0, -1
+	for _, c := range b {
+		switch {
+		case c >= '0' && c <= '9':
+			mantissa = mantissa*10 + uint64(c-'0')
+			digits++
+			if fraction >= 0 {
+				fraction++
+			}
+		case c == '.' && fraction < 0:
+			fraction = 0
+		default:
+			digits = len(exactPow10)
+		}
+	}
+	if digits == 0 || digits >= len(exactPow10) {
+		return strconv.ParseFloat(string(b), 64)
+	}
+	if fraction <= 0 {
+		return float64(mantissa), nil
+	}
+	return float64(mantissa) / exactPow10[fraction], nil
+}
+
+var exactPow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15}
@@ -0,0 +1,106 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizeLogLine(t *testing.T) {
+	cases := []struct {
+		line string
+		want logLine
+	}{
+		{"\n", logLine{kind: logLineBlank}},
+		{"  \r\n", logLine{kind: logLineBlank}},
+		{"#[Logged with jHiccup version 2.0.7]\n", logLine{kind: logLineComment, text: []byte("[Logged with jHiccup version 2.0.7]")}},
+		{"#[Histogram log format version 1.2]\r\n", logLine{kind: logLineFormatVersion, text: []byte("1.2")}},
+		{"#[Histogram log format version 1.2\n", logLine{kind: logLineComment, text: []byte("[Histogram log format version 1.2")}},
+		{"#[StartTime: 1441812279.474 (seconds since epoch), Wed Sep 09 08:24:39 PDT 2015]\n",
+			logLine{kind: logLineStartTime, timestamp: []byte("1441812279.474"), text: []byte("Wed Sep 09 08:24:39 PDT 2015")}},
+		{"#[StartTime: 1 (seconds since epoch)]\n", logLine{kind: logLineStartTime, timestamp: []byte("1")}},
+		{"#[BaseTime: 0.000 (seconds since epoch)]\n", logLine{kind: logLineBaseTime, timestamp: []byte("0.000")}},
+		{`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"` + "\n",
+			logLine{kind: logLineLegend, text: []byte(`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`)}},
+		{"0.127,1.007,2.769,HISTFAAA\n",
+			logLine{kind: logLineInterval, start: []byte("0.127"), length: []byte("1.007"), max: []byte("2.769"), payload: []byte("HISTFAAA")}},
+		{"Tag=A,0.127,1.007,2.769,HISTFAAA",
+			logLine{kind: logLineInterval, hasTag: true, tag: []byte("A"), start: []byte("0.127"), length: []byte("1.007"), max: []byte("2.769"), payload: []byte("HISTFAAA")}},
+		{"Tag=,1,2,3,\n",
+			logLine{kind: logLineInterval, hasTag: true, tag: []byte(""), start: []byte("1"), length: []byte("2"), max: []byte("3"), payload: []byte("")}},
+		{",,,HIST\n", logLine{kind: logLineInterval, start: []byte(""), length: []byte(""), max: []byte(""), payload: []byte("HIST")}},
+		{"Tag=abc\n", logLine{kind: logLineUnrecognised, problem: "tag without an interval"}},
+		{"0.127, 1.007,2.769,HISTFAAA\n", logLine{kind: logLineUnrecognised, problem: "unrecognised line"}},
+		{"-1,1,1,HISTFAAA\n", logLine{kind: logLineUnrecognised, problem: "unrecognised line"}},
+		{"0.127,1.007\n", logLine{kind: logLineUnrecognised, problem: "unrecognised line"}},
+	}
+	for _, c := range cases {
+		var tok logLine
+		tokenizeLogLine([]byte(c.line), &tok)
+		assert.Equal(t, c.want, tok, "line %q", c.line)
+	}
+}
+
+func TestTokenizeLogLine_noAllocs(t *testing.T) {
+	line := []byte("Tag=A,0.127,1.007,2.769,HISTFAAAAEV42pNpmSzMwMCgyAABTBDKT4GBgdnNYMcCBvsPEBEJISEuATEZMQ4uASkhIR4nrxg9v2lMaxhvMekILGZkKmcCAEf2Cs4=\n")
+	var tok logLine
+	allocs := testing.AllocsPerRun(100, func() {
+		tokenizeLogLine(line, &tok)
+		if _, err := parseLogDecimal(tok.start); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestParseLogDecimal(t *testing.T) {
+	inputs := []string{"0", "0.127", "1441812279.474", "1438613579.290", "3.", ".5", "000.001",
+		"123456789012345", "1234567890123456", "0.1234567890123456789", "", ".", "1.2.3", "99999999999999.9"}
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 10000; i++ {
+		inputs = append(inputs, strconv.FormatFloat(r.Float64()*math10(r.Intn(12)), 'f', r.Intn(7), 64))
+	}
+	for _, in := range inputs {
+		want, wantErr := strconv.ParseFloat(in, 64)
+		got, err := parseLogDecimal([]byte(in))
+		if (err != nil) != (wantErr != nil) || got != want {
+			t.Fatalf("parseLogDecimal(%q) = %v, %v; strconv.ParseFloat = %v, %v", in, got, err, want, wantErr)
+		}
+	}
+}
+
+func math10(n int) float64 {
+	return exactPow10[n]
+}
+
+// Payload lines longer than the bufio read buffer must be assembled in full.
+func TestHistogramLogReader_longLines(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	h := New(1, 1000000000, 5)
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, h.RecordValue(r.Int63n(1000000000)))
+	}
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	for i := 0; i < 3; i++ {
+		h.SetTag(fmt.Sprintf("T%d", i))
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+	log := b.String()
+	assert.Greater(t, strings.Index(log, "\n"), 4096)
+
+	got := drainAllIntervals(t, NewHistogramLogReader(strings.NewReader(log)))
+	assert.Equal(t, 3, len(got))
+	for i, decoded := range got {
+		assert.True(t, h.Equals(decoded))
+		assert.Equal(t, fmt.Sprintf("T%d", i), decoded.Tag())
+	}
+	tags, err := NewHistogramLogReader(strings.NewReader(log)).ListTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"T0", "T1", "T2"}, tags)
+}
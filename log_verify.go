@@ -0,0 +1,216 @@
+package hdrhistogram
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+	"unicode"
+)
+
+// The checks made by VerifyHistogramLog, as reported in LogFinding.Check.
+const (
+	// LogCheckHeader reports a missing StartTime, format version or legend line.
+	LogCheckHeader = "header"
+	// LogCheckParse reports a line that is neither a header nor an interval
+	// line, or whose timestamps cannot be parsed.
+	LogCheckParse = "parse"
+	// LogCheckDecode reports an interval whose payload does not decode.
+	LogCheckDecode = "decode"
+	// LogCheckTimestamp reports an interval with a negative length, or one
+	// starting before the previous interval with the same tag.
+	LogCheckTimestamp = "timestamp"
+	// LogCheckMax reports an interval whose max column does not match the max
+	// of its decoded histogram.
+	LogCheckMax = "max"
+	// LogCheckGeometry reports an interval whose histogram is laid out
+	// differently from the first interval with the same tag.
+	LogCheckGeometry = "geometry"
+	// LogCheckTag reports an empty tag, or one holding white space or control
+	// characters.
+	LogCheckTag = "tag"
+)
+
+// The severities of a LogFinding. Errors are problems that lose or corrupt
+// data; warnings are suspicious but readable.
+const (
+	LogFindingError   = "error"
+	LogFindingWarning = "warning"
+)
+
+// A LogFinding is a problem found in a histogram log by VerifyHistogramLog.
+type LogFinding struct {
+	// Line and Offset locate the offending line; both are 0 for findings
+	// about the log as a whole.
+	Line   int64 `json:"line"`
+	Offset int64 `json:"offset"`
+	// Check is the LogCheck constant naming the failed check, and Severity
+	// is LogFindingError or LogFindingWarning.
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	// Tag is the tag of the offending interval line, if any.
+	Tag     string `json:"tag,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f LogFinding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Check, f.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s: %s", f.Line, f.Severity, f.Check, f.Message)
+}
+
+// LogVerifyOptions configures VerifyHistogramLog.
+type LogVerifyOptions struct {
+	// ValueUnit and MaxValueUnit are the units the log was written with, as
+	// set by HistogramLogOptions, and the interval max column is compared in.
+	// They default to time.Nanosecond and time.Millisecond.
+	ValueUnit    time.Duration
+	MaxValueUnit time.Duration
+}
+
+// geometry is the layout of a histogram that should stay the same from one
+// interval to the next.
+type geometry struct {
+	lowest, highest, significantFigures int64
+}
+
+// VerifyHistogramLog reads a histogram log to the end and checks it for
+// problems: a missing header, lines that cannot be parsed, payloads that do
+// not decode, interval timestamps that go backwards per tag, interval max
+// columns that disagree with the decoded histograms, histogram geometries
+// that change within a tag, and malformed tags. The findings are returned in
+// log order; an error is only returned when the log cannot be read.
+//
+// It is meant for checking logs before they are archived, and in tests:
+//
+//	findings, err := hdrhistogram.VerifyHistogramLog(f, nil)
+//	for _, finding := range findings {
+//		t.Error(finding)
+//	}
+func VerifyHistogramLog(log io.Reader, options *LogVerifyOptions) (findings []LogFinding, err error) {
+	logOptions := DefaultHistogramLogOptions()
+	if options != nil && options.ValueUnit > 0 {
+		logOptions.ValueUnit = options.ValueUnit
+	}
+	if options != nil && options.MaxValueUnit > 0 {
+		logOptions.MaxValueUnit = options.MaxValueUnit
+	}
+	ratio := logOptions.MaxValueUnitRatio()
+	hlr := NewHistogramLogReader(log)
+	hlr.SetLenient(true)
+	hlr.rangeStartTimeSec = 0.0
+	hlr.rangeEndTimeSec = math.MaxFloat64
+	hlr.absolute = true
+
+	report := func(check, severity, tag, format string, args ...any) {
+		findings = append(findings, LogFinding{
+			Line:     hlr.lineNumber,
+			Offset:   hlr.lineOffset,
+			Check:    check,
+			Severity: severity,
+			Tag:      tag,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	skip := func(perr *LogParseError) {
+		findings = append(findings, LogFinding{
+			Line:     perr.Line,
+			Offset:   perr.Offset,
+			Check:    LogCheckParse,
+			Severity: LogFindingError,
+			Message:  perr.Err.Error(),
+		})
+	}
+
+	lastStart := make(map[string]float64)
+	geometries := make(map[string]geometry)
+	badTags := make(map[string]bool)
+	intervals := 0
+	for {
+		var entry *LogEntry
+		var payload []byte
+		entry, payload, err = hlr.scanInterval(skip)
+		if err != nil {
+			return
+		}
+		if entry == nil {
+			break
+		}
+		intervals++
+		tag := entry.Tag
+		if hlr.tok.hasTag && !badTags[tag] && !validLogTag(tag) {
+			// Reported once per tag, as every line would repeat it.
+			badTags[tag] = true
+			report(LogCheckTag, LogFindingError, tag, "invalid tag %q", tag)
+		}
+		if entry.IntervalLengthSec < 0 {
+			report(LogCheckTimestamp, LogFindingError, tag, "negative interval length %.3f", entry.IntervalLengthSec)
+		}
+		if last, ok := lastStart[tag]; ok && entry.AbsoluteStartTimeSec < last {
+			// Reported with the timestamps as written in the log.
+			report(LogCheckTimestamp, LogFindingError, tag, "interval starts at %.3f, before the previous interval at %.3f",
+				entry.LogTimeStampSec, last-hlr.baseTimeSec)
+		}
+		lastStart[tag] = entry.AbsoluteStartTimeSec
+
+		if err = entry.decodeHistogram(payload); err != nil {
+			report(LogCheckDecode, LogFindingError, tag, "%v", err)
+			err = nil
+			continue
+		}
+		h := entry.Histogram
+		g := geometry{h.LowestTrackableValue(), h.HighestTrackableValue(), h.SignificantFigures()}
+		if first, ok := geometries[tag]; !ok {
+			geometries[tag] = g
+		} else if g != first {
+			report(LogCheckGeometry, LogFindingWarning, tag,
+				"histogram tracks [%d, %d] with %d significant figures, where the first interval tracks [%d, %d] with %d",
+				g.lowest, g.highest, g.significantFigures, first.lowest, first.highest, first.significantFigures)
+		}
+		if !logMaxMatches(h, entry.IntervalMax, ratio) {
+			report(LogCheckMax, LogFindingError, tag, "interval max %v does not match the histogram max %v", entry.IntervalMax, float64(h.Max())/ratio)
+		}
+	}
+
+	header := hlr.Header()
+	hlr.lineNumber, hlr.lineOffset = 0, 0
+	if !header.HasStartTime {
+		report(LogCheckHeader, LogFindingError, "", "missing StartTime line")
+	}
+	if header.FormatVersion == "" {
+		report(LogCheckHeader, LogFindingWarning, "", "missing log format version line")
+	}
+	if header.Legend == "" {
+		report(LogCheckHeader, LogFindingWarning, "", "missing legend line")
+	}
+	if intervals == 0 {
+		report(LogCheckHeader, LogFindingWarning, "", "log has no interval lines")
+	}
+	return
+}
+
+// logMaxMatches reports whether max, an interval max column divided by ratio,
+// is the max of h. The column is rounded to a few decimals, and may have been
+// written from any value equivalent to the max.
+func logMaxMatches(h *Histogram, max, ratio float64) bool {
+	const rounding = 0.0005
+	hmax := h.Max()
+	low := float64(h.lowestEquivalentValue(hmax))/ratio - rounding
+	high := float64(hmax)/ratio + rounding
+	return max >= low*(1-1e-9) && max <= high*(1+1e-9)
+}
+
+// validLogTag reports whether tag can be written to and read back from a
+// log: it must not be empty, nor hold white space or control characters.
+func validLogTag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, r := range tag {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyHistogramLog_cleanCorpus(t *testing.T) {
+	for _, path := range []string{"./test/jHiccup-2.0.7S.logV2.hlog", "./test/tagged-Log.logV2.hlog"} {
+		f, err := os.Open(path)
+		assert.Nil(t, err)
+		findings, err := VerifyHistogramLog(f, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, findings, path)
+		f.Close()
+	}
+
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogHeader(1000000, 1000000))
+	writeRollingIntervals(t, writer, 0, 3)
+	findings, err := VerifyHistogramLog(&b, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+}
+
+// intervalLine returns a log line for an interval recording v, with the given
+// max column.
+func intervalLine(t *testing.T, prefix string, start float64, h *Histogram, max float64) string {
+	payload, err := h.Encode(V2CompressedEncodingCookieBase)
+	assert.Nil(t, err)
+	return fmt.Sprintf("%s%.3f,1.000,%.3f,%s\n", prefix, start, max, payload)
+}
+
+func TestVerifyHistogramLog_findings(t *testing.T) {
+	h := New(1, 10000000, 3)
+	assert.Nil(t, h.RecordValue(1000000))
+	wide := New(1, 100000000, 3)
+	assert.Nil(t, wide.RecordValue(2000000))
+
+	log := strings.Join([]string{
+		"#[StartTime: 100000000.000 (seconds since epoch), Sat Mar 03 09:46:40 UTC 1973]\n",
+		intervalLine(t, "", 1, h, 1),
+		intervalLine(t, "", 3, h, 1),
+		"garbage\n",
+		intervalLine(t, "", 2, h, 1),
+		intervalLine(t, "", 4, h, 7),
+		"5.000,1.000,1.000,HISTFAAAAnotapayload\n",
+		intervalLine(t, "", 6, wide, 2),
+		intervalLine(t, "Tag=A,", 1, h, 1),
+		intervalLine(t, "Tag=a b,", 2, h, 1),
+		intervalLine(t, "Tag=a b,", 3, h, 1),
+	}, "")
+	findings, err := VerifyHistogramLog(strings.NewReader(log), nil)
+	assert.Nil(t, err)
+
+	type found struct {
+		line     int64
+		check    string
+		severity string
+		tag      string
+	}
+	var got []found
+	for _, f := range findings {
+		got = append(got, found{f.Line, f.Check, f.Severity, f.Tag})
+	}
+	assert.Equal(t, []found{
+		{4, LogCheckParse, LogFindingError, ""},
+		{5, LogCheckTimestamp, LogFindingError, ""},
+		{6, LogCheckMax, LogFindingError, ""},
+		{7, LogCheckDecode, LogFindingError, ""},
+		{8, LogCheckGeometry, LogFindingWarning, ""},
+		{10, LogCheckTag, LogFindingError, "a b"},
+		{0, LogCheckHeader, LogFindingWarning, ""},
+		{0, LogCheckHeader, LogFindingWarning, ""},
+	}, got)
+	assert.Equal(t, "line 5: error: timestamp: interval starts at 2.000, before the previous interval at 3.000", findings[1].String())
+	assert.Equal(t, "warning: header: missing log format version line", findings[6].String())
+	assert.Equal(t, int64(len(log[:strings.Index(log, "garbage")])), findings[0].Offset)
+
+	// the max column is compared in the unit it was written in
+	line := intervalLine(t, "", 1, h, 1000)
+	findings, err = VerifyHistogramLog(strings.NewReader(line), &LogVerifyOptions{ValueUnit: time.Microsecond, MaxValueUnit: time.Millisecond})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"missing StartTime line", "missing log format version line", "missing legend line"}, findingMessages(findings))
+
+	findings, err = VerifyHistogramLog(strings.NewReader(""), nil)
+	assert.Nil(t, err)
+	assert.Contains(t, findingMessages(findings), "log has no interval lines")
+}
+
+func findingMessages(findings []LogFinding) (messages []string) {
+	for _, f := range findings {
+		messages = append(messages, f.Message)
+	}
+	return
+}
+
+func TestValidLogTag(t *testing.T) {
+	assert.True(t, validLogTag("A"))
+	assert.True(t, validLogTag("read-p99/us"))
+	assert.False(t, validLogTag(""))
+	assert.False(t, validLogTag("a b"))
+	assert.False(t, validLogTag("a\tb"))
+	assert.False(t, validLogTag("a\x00b"))
+}
@@ -2,32 +2,75 @@
 package hdrhistogram
 
 import (
+	"bufio"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"sync"
 	"time"
 )
 
 const HISTOGRAM_LOG_FORMAT_VERSION = "1.3"
 const MsToNsRatio float64 = 1000000.0
 
+// HistogramLogOptions controls how OutputIntervalHistogramWithLogOptions logs
+// an interval. The zero value logs the interval exactly as
+// OutputIntervalHistogram does.
 type HistogramLogOptions struct {
-	startTimeStampSec float64
-	endTimeStampSec   float64
-	maxValueUnitRatio float64
+	// StartTime and EndTime, when non-zero, are logged as the interval bounds
+	// in place of the start and end times stamped on the histogram.
+	StartTime time.Time
+	EndTime   time.Time
+	// ValueUnit is the unit of the values recorded in the histogram, and
+	// MaxValueUnit the unit the interval max column is written in. They
+	// default to time.Nanosecond and time.Millisecond, the convention of
+	// latency logs. For values that are not durations, set both to the same
+	// non-zero value to log the max unscaled.
+	ValueUnit    time.Duration
+	MaxValueUnit time.Duration
 }
 
+// DefaultHistogramLogOptions returns options that log intervals with their
+// histogram's own start and end times, and nanosecond values reported in
+// milliseconds in the interval max column.
 func DefaultHistogramLogOptions() *HistogramLogOptions {
-	return &HistogramLogOptions{0, 0, MsToNsRatio}
+	return &HistogramLogOptions{ValueUnit: time.Nanosecond, MaxValueUnit: time.Millisecond}
 }
 
+// MaxValueUnitRatio returns the ratio the histogram max is divided by before
+// it is written to the interval max column.
+func (o *HistogramLogOptions) MaxValueUnitRatio() float64 {
+	valueUnit, maxValueUnit := time.Nanosecond, time.Millisecond
+	if o != nil && o.ValueUnit != 0 {
+		valueUnit = o.ValueUnit
+	}
+	if o != nil && o.MaxValueUnit != 0 {
+		maxValueUnit = o.MaxValueUnit
+	}
+	return float64(maxValueUnit) / float64(valueUnit)
+}
+
+// A HistogramLogWriter writes histogram logs. It is safe for concurrent use:
+// each line, and each header written by OutputLogHeader, reaches the
+// underlying writer in a single Write, so lines from concurrent callers never
+// interleave.
 type HistogramLogWriter struct {
+	mu       sync.Mutex
 	baseTime int64
 	log      io.Writer
+	buf      *bufio.Writer
+	gz       *gzipLogSink
+	// closer closes the files of a rolling log.
+	closer io.Closer
+	closed bool
 }
 
 // BaseTime returns the current base time offset
 func (lw *HistogramLogWriter) BaseTime() int64 {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
 	return lw.baseTime
 }
 
@@ -36,6 +79,8 @@ func (lw *HistogramLogWriter) BaseTime() int64 {
 // baseTime is expected to be in msec since the epoch, as histogram start/end times
 // are typically stamped with absolute times in msec since the epoch.
 func (lw *HistogramLogWriter) SetBaseTime(baseTime int64) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
 	lw.baseTime = baseTime
 }
 
@@ -43,6 +88,175 @@ func NewHistogramLogWriter(log io.Writer) *HistogramLogWriter {
 	return &HistogramLogWriter{baseTime: 0, log: log}
 }
 
+// NewBufferedHistogramLogWriter returns a writer that buffers its output in
+// memory, writing to log only once size bytes have accumulated or on Flush or
+// Close. A size of zero or less uses a default buffer size. Lines are never
+// split across writes to log.
+func NewBufferedHistogramLogWriter(log io.Writer, size int) *HistogramLogWriter {
+	buf := bufio.NewWriterSize(log, size)
+	return &HistogramLogWriter{baseTime: 0, log: buf, buf: buf}
+}
+
+// NewGzipHistogramLogWriter returns a writer of a gzip compressed log, as read
+// transparently by HistogramLogReader.
+//
+// So that the log stays readable while it is still being written, lines are
+// flushed through the compressed stream at most flushInterval after they were
+// written, even if no other line follows them; a flushInterval of zero flushes
+// after every line. A negative flushInterval never flushes on its own, which
+// suits logs only read once complete, as it compresses best. Flush flushes the
+// stream at once. Close must be called to complete the stream.
+func NewGzipHistogramLogWriter(log io.Writer, flushInterval time.Duration) *HistogramLogWriter {
+	lw := &HistogramLogWriter{baseTime: 0}
+	gz := &gzipLogSink{mu: &lw.mu, zw: gzip.NewWriter(log), flushInterval: flushInterval, now: time.Now}
+	gz.lastFlush = gz.now()
+	lw.log, lw.gz = gz, gz
+	return lw
+}
+
+// Flush writes any output still buffered by a buffered or compressed log to
+// the underlying writer.
+func (lw *HistogramLogWriter) Flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.flush()
+}
+
+func (lw *HistogramLogWriter) flush() error {
+	if lw.buf != nil {
+		if err := lw.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	if lw.gz != nil {
+		return lw.gz.flush()
+	}
+	return nil
+}
+
+// Close flushes the log and completes the stream of a compressed log. Output
+// after Close fails. It does not close the underlying writer, except for the
+// files of a rolling log.
+func (lw *HistogramLogWriter) Close() (err error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return nil
+	}
+	lw.closed = true
+	if lw.buf != nil {
+		err = lw.buf.Flush()
+	}
+	if lw.gz != nil && err == nil {
+		err = lw.gz.close()
+	}
+	if lw.closer != nil {
+		if cerr := lw.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+var errLogWriterClosed = errors.New("histogram log writer is closed")
+
+// writeLines writes one or more complete lines in a single Write.
+func (lw *HistogramLogWriter) writeLines(lines []byte) (err error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.writeLinesLocked(lines)
+}
+
+func (lw *HistogramLogWriter) writeLinesLocked(lines []byte) (err error) {
+	if lw.closed {
+		return errLogWriterClosed
+	}
+	if lw.buf != nil && len(lines) > lw.buf.Available() && lw.buf.Buffered() > 0 {
+		// Flush first rather than let bufio split the lines across writes.
+		if err = lw.buf.Flush(); err != nil {
+			return
+		}
+	}
+	_, err = lw.log.Write(lines)
+	return
+}
+
+// gzipLogSink compresses log lines, flushing the stream periodically. Each
+// line reaches it in a single Write, so flushes fall on line boundaries.
+//
+// A line written before flushInterval has passed since the previous flush
+// arms a timer, which flushes it if no later line has by then. The sink is
+// guarded by the writer's lock, held by every caller and taken by the timer.
+type gzipLogSink struct {
+	mu            *sync.Mutex
+	zw            *gzip.Writer
+	flushInterval time.Duration
+	lastFlush     time.Time
+	now           func() time.Time
+	timer         *time.Timer
+	// err is the error of the last timed flush, reported by the next call.
+	err    error
+	closed bool
+}
+
+func (s *gzipLogSink) Write(p []byte) (n int, err error) {
+	if err = s.takeErr(); err != nil {
+		return
+	}
+	if n, err = s.zw.Write(p); err != nil || s.flushInterval < 0 {
+		return
+	}
+	if wait := s.flushInterval - s.now().Sub(s.lastFlush); wait <= 0 {
+		err = s.flush()
+	} else if s.timer == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(wait, func() { s.timedFlush(timer) })
+		s.timer = timer
+	}
+	return
+}
+
+// timedFlush flushes the lines written since the last flush, unless timer has
+// been stopped or replaced while it waited for the lock.
+func (s *gzipLogSink) timedFlush(timer *time.Timer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != timer || s.closed {
+		return
+	}
+	s.err = s.flush()
+}
+
+func (s *gzipLogSink) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func (s *gzipLogSink) takeErr() (err error) {
+	err, s.err = s.err, nil
+	return
+}
+
+func (s *gzipLogSink) flush() error {
+	s.stopTimer()
+	if err := s.takeErr(); err != nil {
+		return err
+	}
+	s.lastFlush = s.now()
+	return s.zw.Flush()
+}
+
+func (s *gzipLogSink) close() error {
+	s.stopTimer()
+	s.closed = true
+	if err := s.takeErr(); err != nil {
+		return err
+	}
+	return s.zw.Close()
+}
+
 // OutputIntervalHistogram outputs an interval histogram, using the start/end timestamp indicated in the histogram, and the [optional] tag associated with the histogram.
 // The histogram start and end timestamps are assumed to be in msec units
 //
@@ -58,10 +272,9 @@ func (lw *HistogramLogWriter) OutputIntervalHistogram(histogram *Histogram) (err
 
 // OutputIntervalHistogramWithLogOptions outputs an interval histogram, with the given timestamp information and the [optional] tag associated with the histogram
 //
-// If you specify non-nil logOptions, and non-zero start timestamp, the specified timestamp information will be used, and the start timestamp information in the actual histogram will be ignored.
-// If you specify non-nil logOptions, and non-zero start timestamp, the specified timestamp information will be used, and the end timestamp information in the actual histogram will be ignored.
-// If you specify non-nil logOptions, The max value reported with the interval line will be scaled by the given maxValueUnitRatio,
-// otherwise  a default maxValueUnitRatio of 1,000,000 (which is the msec : nsec ratio) will be used.
+// If logOptions has a non-zero StartTime or EndTime, it is logged in place of the start or end time stamped on the histogram.
+// The max value reported with the interval line is converted from logOptions.ValueUnit to logOptions.MaxValueUnit,
+// which default to nanoseconds and milliseconds. A nil logOptions is equivalent to DefaultHistogramLogOptions().
 //
 // By convention, histogram start/end time are generally stamped with absolute times in msec
 // since the epoch. For logging with absolute time stamps, the base time would remain zero ( default ).
@@ -81,71 +294,112 @@ func (lw *HistogramLogWriter) OutputIntervalHistogramWithLogOptions(histogram *H
 		}
 		tagStr = fmt.Sprintf("Tag=%s,", tag)
 	}
+	// Interval bounds in msec since the epoch.
 	var usedStartTime = float64(histogram.StartTimeMs())
 	var usedEndTime = float64(histogram.EndTimeMs())
-	var maxValueUnitRatio = MsToNsRatio
 	if logOptions != nil {
-		if logOptions.startTimeStampSec != 0 {
-			usedStartTime = logOptions.startTimeStampSec
+		if !logOptions.StartTime.IsZero() {
+			usedStartTime = timeToMs(logOptions.StartTime)
 		}
-		if logOptions.endTimeStampSec != 0 {
-			usedEndTime = logOptions.endTimeStampSec
+		if !logOptions.EndTime.IsZero() {
+			usedEndTime = timeToMs(logOptions.EndTime)
 		}
-		maxValueUnitRatio = logOptions.maxValueUnitRatio
 	}
-	startTime := (usedStartTime - float64(lw.baseTime)) / 1000.0
-	endTime := (usedEndTime - float64(lw.baseTime)) / 1000.0
+	maxValueUnitRatio := logOptions.MaxValueUnitRatio()
 	maxValueAsDouble := float64(histogram.Max()) / maxValueUnitRatio
-	cpayload, err := histogram.Encode(V2CompressedEncodingCookieBase)
+	// Encode before taking the lock, so concurrent writers only serialize on
+	// the write itself.
+	cpayload, err := histogram.EncodeAppend(nil)
 	if err != nil {
 		return
 	}
-	_, err = fmt.Fprintf(lw.log, "%s%f,%f,%f,%s\n", tagStr, startTime, endTime-startTime, maxValueAsDouble, string(cpayload))
-	return
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	startTime := (usedStartTime - float64(lw.baseTime)) / 1000.0
+	endTime := (usedEndTime - float64(lw.baseTime)) / 1000.0
+	line := fmt.Appendf(make([]byte, 0, len(tagStr)+64+len(cpayload)), "%s%f,%f,%f,", tagStr, startTime, endTime-startTime, maxValueAsDouble)
+	line = append(line, cpayload...)
+	line = append(line, '\n')
+	return lw.writeLinesLocked(line)
+}
+
+// timeToMs returns t in fractional msec since the epoch.
+func timeToMs(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
 }
 
 // OutputStartTime logs a start time in the log.
 // Start time is represented as seconds since epoch with up to 3 decimal places.
 // Line starts with the leading text '#[StartTime:'
 func (lw *HistogramLogWriter) OutputStartTime(msec int64) (err error) {
+	return lw.writeLines(appendStartTime(nil, msec))
+}
+
+func appendStartTime(dst []byte, msec int64) []byte {
 	secs := msec / 1000
 	nsecs := (msec % 1000) * int64(time.Millisecond) // 1 ms = 1e6 ns
 	// Format in UTC so the log line is stable regardless of the process's local
 	// timezone (TZ). Without .UTC() the ISO timestamp — and any test asserting it —
 	// depends on the machine's timezone. (issue #61)
 	isoStr := time.Unix(secs, nsecs).UTC().Format(time.RFC3339)
-	_, err = fmt.Fprintf(lw.log, "#[StartTime: %d (seconds since epoch), %s]\n", secs, isoStr)
-	return
+	return fmt.Appendf(dst, "#[StartTime: %d (seconds since epoch), %s]\n", secs, isoStr)
 }
 
 // OutputBaseTime logs a base time in the log.
 // Base time is represented as seconds since epoch with up to 3 decimal places.
 // Line starts with the leading text '#[BaseTime:'
 func (lw *HistogramLogWriter) OutputBaseTime(msec int64) (err error) {
-	secs := msec / 1000
+	return lw.writeLines(appendBaseTime(nil, msec))
+}
+
+func appendBaseTime(dst []byte, msec int64) []byte {
 	// Capital "BaseTime" — matches the reader's (case-sensitive) regex and the
 	// Java HdrHistogram convention. The lowercase form was silently unreadable,
-	// so a base time written here was lost on read-back.
-	_, err = fmt.Fprintf(lw.log, "#[BaseTime: %d (seconds since epoch)]\n", secs)
-	return
+	// so a base time written here was lost on read-back. The milliseconds are
+	// kept, as the Java writer does, since every interval timestamp is read
+	// back relative to them.
+	return fmt.Appendf(dst, "#[BaseTime: %.3f (seconds since epoch)]\n", float64(msec)/1000.0)
 }
 
 // OutputComment logs a comment to the log.
 // A comment is any line that leads with '#' that is not matched by the BaseTime or StartTime formats.
 // Comments are ignored when parsed.
 func (lw *HistogramLogWriter) OutputComment(comment string) (err error) {
-	_, err = fmt.Fprintf(lw.log, "#%s\n", comment)
-	return
+	return lw.writeLines(fmt.Appendf(nil, "#%s\n", comment))
 }
 
 // OutputLegend outputs a legend line to the log.
 // Human-readable column headers. Ignored when parsed.
 func (lw *HistogramLogWriter) OutputLegend() (err error) {
-	_, err = lw.log.Write([]byte("\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n"))
-	return
+	return lw.writeLines(appendLegend(nil))
+}
+
+func appendLegend(dst []byte) []byte {
+	return append(dst, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n"...)
 }
 
 // OutputLogFormatVersion outputs a log format version to the log.
 func (lw *HistogramLogWriter) OutputLogFormatVersion() (err error) {
-	return lw.OutputComment(fmt.Sprintf("[Histogram log format version %s]", HISTOGRAM_LOG_FORMAT_VERSION))
+	return lw.writeLines(appendLogFormatVersion(nil))
+}
+
+func appendLogFormatVersion(dst []byte) []byte {
+	return fmt.Appendf(dst, "#[Histogram log format version %s]\n", HISTOGRAM_LOG_FORMAT_VERSION)
+}
+
+// OutputLogHeader outputs the canonical log header: the log format version,
+// the start time, the base time and the legend, in that order and in a single
+// write. It also sets the writer's base time to baseTime, so the intervals that
+// follow are logged relative to it. Both times are in msec since the epoch.
+func (lw *HistogramLogWriter) OutputLogHeader(startTime, baseTime int64) (err error) {
+	header := appendLogFormatVersion(nil)
+	header = appendStartTime(header, startTime)
+	header = appendBaseTime(header, baseTime)
+	header = appendLegend(header)
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if err = lw.writeLinesLocked(header); err == nil {
+		lw.baseTime = baseTime
+	}
+	return
 }
@@ -2,8 +2,13 @@ package hdrhistogram
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -178,3 +183,292 @@ func TestHistogramLogReader_tagged_log(t *testing.T) {
 	}
 	assert.Equal(t, 42, count)
 }
+
+// readGzipPrefix decompresses as much of a possibly unfinished gzip stream as
+// has been flushed.
+func readGzipPrefix(t *testing.T, compressed []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	_, err = io.Copy(&out, zr)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	return out.String()
+}
+
+func TestGzipHistogramLogWriter(t *testing.T) {
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	var plain, compressed bytes.Buffer
+	for _, writer := range []*HistogramLogWriter{NewHistogramLogWriter(&plain), NewGzipHistogramLogWriter(&compressed, 0)} {
+		assert.Nil(t, writer.OutputLogFormatVersion())
+		assert.Nil(t, writer.OutputLegend())
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, writer.OutputIntervalHistogram(h))
+		}
+		// with a zero flush interval every complete line is already readable
+		if writer.gz != nil {
+			assert.Equal(t, plain.String(), readGzipPrefix(t, compressed.Bytes()))
+		}
+		assert.Nil(t, writer.Close())
+	}
+	assert.Equal(t, []byte{0x1f, 0x8b}, compressed.Bytes()[:2])
+
+	want := drainAllIntervals(t, NewHistogramLogReader(&plain))
+	got := drainAllIntervals(t, NewHistogramLogReader(&compressed))
+	assert.Equal(t, 3, len(got))
+	assert.Equal(t, want, got)
+}
+
+func TestGzipHistogramLogWriter_flushInterval(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := NewGzipHistogramLogWriter(&compressed, time.Minute)
+	now := time.Unix(1000, 0)
+	writer.gz.now = func() time.Time { return now }
+	writer.gz.lastFlush = now
+
+	assert.Nil(t, writer.OutputComment("first"))
+	assert.Equal(t, "", readGzipPrefix(t, compressed.Bytes()))
+	now = now.Add(time.Minute)
+	assert.Nil(t, writer.OutputComment("second"))
+	assert.Equal(t, "#first\n#second\n", readGzipPrefix(t, compressed.Bytes()))
+	assert.Nil(t, writer.OutputComment("third"))
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "#first\n#second\n#third\n", readGzipPrefix(t, compressed.Bytes()))
+	assert.Nil(t, writer.Close())
+
+	// Flush and Close are no-ops for an uncompressed log
+	plain := NewHistogramLogWriter(io.Discard)
+	assert.Nil(t, plain.Flush())
+	assert.Nil(t, plain.Close())
+}
+
+func TestGzipHistogramLogWriter_noTimedFlush(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := NewGzipHistogramLogWriter(&compressed, -1)
+	assert.Nil(t, writer.OutputComment("first"))
+	assert.Nil(t, writer.gz.timer)
+	assert.Equal(t, "", readGzipPrefix(t, compressed.Bytes()))
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "#first\n", readGzipPrefix(t, compressed.Bytes()))
+	assert.Nil(t, writer.OutputComment("second"))
+	assert.Nil(t, writer.Close())
+	zr, err := gzip.NewReader(&compressed)
+	assert.Nil(t, err)
+	all, err := io.ReadAll(zr)
+	assert.Nil(t, err)
+	assert.Equal(t, "#first\n#second\n", string(all))
+}
+
+func TestGzipHistogramLogWriter_timedFlush(t *testing.T) {
+	rec := &writeRecorder{}
+	writer := NewGzipHistogramLogWriter(rec, 10*time.Millisecond)
+	assert.Nil(t, writer.OutputLogHeader(1000000, 1000000))
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	h.SetStartTimeMs(1001000)
+	h.SetEndTimeMs(1002000)
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+
+	// with no further lines, the timer flushes the interval, and the partly
+	// written log reads back up to the end of its stream so far
+	var got []*Histogram
+	assert.Eventually(t, func() bool {
+		reader := NewHistogramLogReader(bytes.NewReader(rec.bytes()))
+		interval, err := reader.NextIntervalHistogram()
+		if interval == nil {
+			return false
+		}
+		got = append(got, interval)
+		_, err = reader.NextIntervalHistogram()
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		return true
+	}, 5*time.Second, time.Millisecond)
+	assert.True(t, h.Equals(got[0]))
+	assert.Equal(t, h.StartTimeMs(), got[0].StartTimeMs())
+
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, writer.gz.timer)
+	assert.Equal(t, 1, len(drainAllIntervals(t, NewHistogramLogReader(bytes.NewReader(rec.bytes())))))
+}
+
+// writeRecorder records each Write it receives.
+type writeRecorder struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// bytes returns everything written so far.
+func (w *writeRecorder) bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Join(w.writes, nil)
+}
+
+func TestHistogramLogWriter_concurrent(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		rec := &writeRecorder{}
+		writer := NewHistogramLogWriter(rec)
+		if buffered {
+			// smaller than a line, so lines must still not be split
+			writer = NewBufferedHistogramLogWriter(rec, 64)
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				h := New(1, 10000, 3)
+				h.SetTag(fmt.Sprint("T", g))
+				for i := 0; i < 50; i++ {
+					assert.Nil(t, h.RecordValue(int64(i+g)))
+					assert.Nil(t, writer.OutputIntervalHistogram(h))
+				}
+			}(g)
+		}
+		wg.Wait()
+		assert.Nil(t, writer.Close())
+		assert.Equal(t, errLogWriterClosed, writer.OutputComment("late"))
+
+		var all bytes.Buffer
+		for _, w := range rec.writes {
+			assert.Equal(t, byte('\n'), w[len(w)-1], "writes end on a line boundary")
+			all.Write(w)
+		}
+		perTag := map[string]int{}
+		for _, h := range drainAllIntervals(t, NewHistogramLogReader(&all)) {
+			perTag[h.Tag()]++
+		}
+		assert.Equal(t, 8, len(perTag))
+		for _, n := range perTag {
+			assert.Equal(t, 50, n)
+		}
+	}
+}
+
+func TestHistogramLogWriter_buffered(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewBufferedHistogramLogWriter(&b, 0)
+	assert.Nil(t, writer.OutputComment("buffered"))
+	assert.Equal(t, 0, b.Len())
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "#buffered\n", b.String())
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, writer.Close())
+}
+
+func TestHistogramLogWriter_OutputLogHeader(t *testing.T) {
+	rec := &writeRecorder{}
+	writer := NewHistogramLogWriter(rec)
+	assert.Nil(t, writer.OutputLogHeader(1441812279000, 1441812270000))
+	assert.Equal(t, 1, len(rec.writes))
+	assert.Equal(t, int64(1441812270000), writer.BaseTime())
+	assert.Equal(t, "#[Histogram log format version 1.3]\n"+
+		"#[StartTime: 1441812279 (seconds since epoch), 2015-09-09T15:24:39Z]\n"+
+		"#[BaseTime: 1441812270.000 (seconds since epoch)]\n"+
+		"\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n",
+		string(rec.writes[0]))
+
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	h.SetStartTimeMs(1441812280000)
+	h.SetEndTimeMs(1441812281000)
+	assert.Nil(t, writer.OutputIntervalHistogram(h))
+	assert.True(t, bytes.HasPrefix(rec.writes[1], []byte("10.000000,1.000000,")))
+
+	var all bytes.Buffer
+	for _, w := range rec.writes {
+		all.Write(w)
+	}
+	reader := NewHistogramLogReader(&all)
+	got := drainAllIntervals(t, reader)
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, h.StartTimeMs(), got[0].StartTimeMs())
+	assert.Equal(t, h.EndTimeMs(), got[0].EndTimeMs())
+	header := reader.Header()
+	assert.Equal(t, "1.3", header.FormatVersion)
+	assert.Equal(t, 1441812279.0, header.StartTimeSec)
+	assert.Equal(t, 1441812270.0, header.BaseTimeSec)
+}
+
+func TestHistogramLogWriter_OutputLogHeaderSubSecondBaseTime(t *testing.T) {
+	var b bytes.Buffer
+	writer := NewHistogramLogWriter(&b)
+	assert.Nil(t, writer.OutputLogHeader(1700000000500, 1700000000500))
+	assert.Contains(t, b.String(), "#[BaseTime: 1700000000.500 (seconds since epoch)]\n")
+	h := New(1, 1000, 3)
+	assert.Nil(t, h.RecordValue(42))
+	for _, startMs := range []int64{1700000001000, 1700000002123} {
+		h.SetStartTimeMs(startMs)
+		h.SetEndTimeMs(startMs + 1000)
+		assert.Nil(t, writer.OutputIntervalHistogram(h))
+	}
+
+	got := drainAllIntervals(t, NewHistogramLogReader(&b))
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, int64(1700000001000), got[0].StartTimeMs())
+	assert.Equal(t, int64(1700000002123), got[1].StartTimeMs())
+	assert.Equal(t, int64(1700000003123), got[1].EndTimeMs())
+}
+
+func TestHistogramLogOptions_MaxValueUnitRatio(t *testing.T) {
+	var nilOptions *HistogramLogOptions
+	assert.Equal(t, MsToNsRatio, nilOptions.MaxValueUnitRatio())
+	assert.Equal(t, MsToNsRatio, (&HistogramLogOptions{}).MaxValueUnitRatio())
+	assert.Equal(t, MsToNsRatio, DefaultHistogramLogOptions().MaxValueUnitRatio())
+	assert.Equal(t, 1e6, (&HistogramLogOptions{ValueUnit: time.Microsecond, MaxValueUnit: time.Second}).MaxValueUnitRatio())
+	assert.Equal(t, 1.0, (&HistogramLogOptions{ValueUnit: 1, MaxValueUnit: 1}).MaxValueUnitRatio())
+}
+
+func TestHistogramLogWriter_OutputIntervalHistogramWithLogOptions(t *testing.T) {
+	h := New(1, 100000000, 3)
+	assert.Nil(t, h.RecordValue(2500000))
+	h.SetStartTimeMs(1441812280000)
+	h.SetEndTimeMs(1441812281000)
+
+	// the zero value and the defaults log exactly what OutputIntervalHistogram does
+	var want, zero, defaults bytes.Buffer
+	assert.Nil(t, NewHistogramLogWriter(&want).OutputIntervalHistogram(h))
+	assert.Nil(t, NewHistogramLogWriter(&zero).OutputIntervalHistogramWithLogOptions(h, &HistogramLogOptions{}))
+	assert.Nil(t, NewHistogramLogWriter(&defaults).OutputIntervalHistogramWithLogOptions(h, DefaultHistogramLogOptions()))
+	assert.Equal(t, want.String(), zero.String())
+	assert.Equal(t, want.String(), defaults.String())
+
+	start := time.UnixMilli(1441812290125)
+	end := start.Add(1500 * time.Millisecond)
+	options := &HistogramLogOptions{
+		StartTime:    start,
+		EndTime:      end,
+		ValueUnit:    time.Microsecond,
+		MaxValueUnit: time.Second,
+	}
+	for _, baseTime := range []int64{0, 1441812270000} {
+		var b bytes.Buffer
+		writer := NewHistogramLogWriter(&b)
+		assert.Nil(t, writer.OutputLogHeader(1441812270000, baseTime))
+		assert.Nil(t, writer.OutputIntervalHistogramWithLogOptions(h, options))
+
+		var entries []*LogEntry
+		for entry, err := range NewHistogramLogReader(&b).Entries() {
+			assert.Nil(t, err)
+			entries = append(entries, entry)
+		}
+		assert.Equal(t, 1, len(entries))
+		entry := entries[0]
+		assert.Equal(t, start, entry.StartTime())
+		assert.Equal(t, end, entry.EndTime())
+		assert.Equal(t, start.UnixMilli(), entry.Histogram.StartTimeMs())
+		assert.Equal(t, end.UnixMilli(), entry.Histogram.EndTimeMs())
+		assert.InDelta(t, 1.5, entry.IntervalLengthSec, 1e-9)
+		// 2.5s recorded in usec, reported in seconds
+		assert.InDelta(t, 2.5, entry.IntervalMax, 2.5e-3)
+		assert.Equal(t, float64(h.Max())/1e6, entry.IntervalMax)
+		assert.True(t, h.Equals(entry.Histogram))
+	}
+}
@@ -0,0 +1,242 @@
+package hdrhistogram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// PercentilesFormat is an output format of WritePercentiles.
+type PercentilesFormat int
+
+const (
+	// PercentilesText is the layout of the Java implementation's
+	// outputPercentileDistribution, as found in .hgrm files.
+	PercentilesText PercentilesFormat = iota
+	// PercentilesCSV is the layout of outputPercentileDistribution with its
+	// CSV option, as written by HistogramLogProcessor -csv.
+	PercentilesCSV
+	// PercentilesJSON is a JSON object holding the rows and the summary of
+	// the histogram.
+	PercentilesJSON
+)
+
+// unitSymbol returns the symbol of a unit of time values are output in, such
+// as ms.
+func unitSymbol(unit time.Duration) string {
+	switch unit {
+	case time.Nanosecond:
+		return "ns"
+	case time.Microsecond:
+		return "µs"
+	case time.Millisecond:
+		return "ms"
+	case time.Second:
+		return "s"
+	}
+	return unit.String()
+}
+
+// PercentilesOptions configures WritePercentiles. Zero fields use their
+// defaults.
+type PercentilesOptions struct {
+	// Format is the output format, PercentilesText by default.
+	Format PercentilesFormat
+	// Percentiles lists the percentiles to output, in the range [0, 100]. If
+	// empty, the percentiles are stepped towards 100% with
+	// TicksPerHalfDistance steps per half distance, 5 by default, as done by
+	// CumulativeDistributionWithTicks.
+	Percentiles          []float64
+	TicksPerHalfDistance int32
+	// RecordedUnit and OutputUnit convert values from the unit they were
+	// recorded in, time.Nanosecond by default as for HistogramLogOptions, to
+	// that they are output in, which labels the value column. Without an OutputUnit, values are divided by
+	// ValueUnitRatio, 1 by default, and are not labelled.
+	RecordedUnit   time.Duration
+	OutputUnit     time.Duration
+	ValueUnitRatio float64
+	// Precision is the number of decimals of text and CSV values. Zero uses
+	// the histogram's significant figures, as the Java implementation does,
+	// and a negative Precision writes whole numbers.
+	Precision int
+}
+
+// A PercentileRow is a row of WritePercentiles.
+type PercentileRow struct {
+	// Percentile is the percentile of the row, in the range [0, 100].
+	Percentile float64 `json:"percentile"`
+	// Value is the value at Percentile, in the output unit.
+	Value float64 `json:"value"`
+	// TotalCount is the number of values recorded at or below Value.
+	TotalCount int64 `json:"totalCount"`
+}
+
+// InversePercentile returns 1/(1-percentile), the x coordinate of the row in
+// the usual HdrHistogram plots, which is infinite at 100%.
+func (r PercentileRow) InversePercentile() float64 {
+	return 1.0 / (1.0 - r.Percentile/100.0)
+}
+
+// percentilesJSON is the object written by PercentilesJSON.
+type percentilesJSON struct {
+	Unit         string          `json:"unit,omitempty"`
+	Percentiles  []PercentileRow `json:"percentiles"`
+	Mean         float64         `json:"mean"`
+	StdDeviation float64         `json:"stdDeviation"`
+	Max          float64         `json:"max"`
+	TotalCount   int64           `json:"totalCount"`
+	Buckets      int32           `json:"buckets"`
+	SubBuckets   int32           `json:"subBuckets"`
+}
+
+// resolve returns options with the defaults filled in, and the ratio values
+// are divided by.
+func (options *PercentilesOptions) resolve() (o PercentilesOptions, ratio float64, err error) {
+	if options != nil {
+		o = *options
+	}
+	if o.TicksPerHalfDistance == 0 {
+		o.TicksPerHalfDistance = 5
+	}
+	if o.RecordedUnit == 0 {
+		o.RecordedUnit = time.Nanosecond
+	}
+	if o.ValueUnitRatio == 0 {
+		o.ValueUnitRatio = 1
+	}
+	switch {
+	case o.Format < PercentilesText || o.Format > PercentilesJSON:
+		return o, 0, fmt.Errorf("unknown percentiles format %d", o.Format)
+	case o.TicksPerHalfDistance < 0 || o.RecordedUnit < 0 || o.OutputUnit < 0 || o.ValueUnitRatio < 0:
+		return o, 0, errors.New("percentile ticks, units and value unit ratio must be positive")
+	}
+	for _, p := range o.Percentiles {
+		if p < 0 || p > 100 {
+			return o, 0, fmt.Errorf("percentile %v out of range [0, 100]", p)
+		}
+	}
+	ratio = o.ValueUnitRatio
+	if o.OutputUnit != 0 {
+		ratio = float64(o.OutputUnit) / float64(o.RecordedUnit)
+	}
+	return o, ratio, nil
+}
+
+// PercentileRows returns the rows WritePercentiles writes, without writing
+// them. An empty histogram has no rows.
+func (h *Histogram) PercentileRows(options *PercentilesOptions) ([]PercentileRow, error) {
+	o, ratio, err := options.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return h.percentileRows(&o, ratio), nil
+}
+
+func (h *Histogram) percentileRows(o *PercentilesOptions, ratio float64) []PercentileRow {
+	if h.totalCount == 0 {
+		return nil
+	}
+	var rows []PercentileRow
+	if len(o.Percentiles) == 0 {
+		for _, bracket := range h.CumulativeDistributionWithTicks(o.TicksPerHalfDistance) {
+			rows = append(rows, PercentileRow{bracket.Quantile, float64(bracket.ValueAt) / ratio, bracket.Count})
+		}
+		return rows
+	}
+	for _, p := range o.Percentiles {
+		v := h.ValueAtPercentile(p)
+		rows = append(rows, PercentileRow{p, float64(v) / ratio, h.countAtOrBelow(v)})
+	}
+	return rows
+}
+
+// countAtOrBelow returns the number of recorded values equivalent to or below
+// v.
+func (h *Histogram) countAtOrBelow(v int64) (count int64) {
+	i := h.iterator()
+	for i.next() && i.valueFromIdx <= v {
+		count = i.countToIdx
+	}
+	return
+}
+
+// WritePercentiles writes the percentile distribution of h in the configured
+// format, returning the rows written so callers don't need to parse them
+// back. It generalises OutputPercentileDistribution, which it matches with
+// the default options, with explicit percentile lists, CSV and JSON formats,
+// value units and precision.
+//
+// The text and CSV formats have a row per percentile of the value, the
+// percentile as a fraction, the total count at or below the value and
+// 1/(1-percentile), left out of the 100% row. The text format ends with a
+// footer of the mean, standard deviation, max, total count and bucket
+// geometry, which the JSON format holds as fields, next to its rows with the
+// values at full precision.
+func (h *Histogram) WritePercentiles(w io.Writer, options *PercentilesOptions) ([]PercentileRow, error) {
+	o, ratio, err := options.resolve()
+	if err != nil {
+		return nil, err
+	}
+	rows := h.percentileRows(&o, ratio)
+	unit := ""
+	if o.OutputUnit != 0 {
+		unit = unitSymbol(o.OutputUnit)
+	}
+
+	if o.Format == PercentilesJSON {
+		b, err := json.Marshal(&percentilesJSON{
+			Unit:         unit,
+			Percentiles:  append([]PercentileRow{}, rows...),
+			Mean:         h.Mean() / ratio,
+			StdDeviation: h.StdDev() / ratio,
+			Max:          float64(h.Max()) / ratio,
+			TotalCount:   h.TotalCount(),
+			Buckets:      h.bucketCount,
+			SubBuckets:   h.subBucketCount,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return rows, err
+	}
+
+	precision := o.Precision
+	switch {
+	case precision == 0:
+		precision = int(h.significantFigures)
+	case precision < 0:
+		precision = 0
+	}
+	valueHeader := "Value"
+	if unit != "" {
+		valueHeader += " (" + unit + ")"
+	}
+	var b []byte
+	var row, lastRow string
+	if o.Format == PercentilesCSV {
+		b = fmt.Appendf(b, "%q,\"Percentile\",\"TotalCount\",\"1/(1-Percentile)\"\n", valueHeader)
+		row = fmt.Sprintf("%%.%df,%%.12f,%%d,%%.2f\n", precision)
+		lastRow = fmt.Sprintf("%%.%df,%%.12f,%%d,Infinity\n", precision)
+	} else {
+		b = fmt.Appendf(b, "%12s %14s %10s %14s\n\n", valueHeader, "Percentile", "TotalCount", "1/(1-Percentile)")
+		row = fmt.Sprintf("%%12.%df %%2.12f %%10d %%14.2f\n", precision)
+		lastRow = fmt.Sprintf("%%12.%df %%2.12f %%10d\n", precision)
+	}
+	for _, r := range rows {
+		if r.Percentile != 100.0 {
+			b = fmt.Appendf(b, row, r.Value, r.Percentile/100.0, r.TotalCount, r.InversePercentile())
+		} else {
+			b = fmt.Appendf(b, lastRow, r.Value, r.Percentile/100.0, r.TotalCount)
+		}
+	}
+	if o.Format == PercentilesText {
+		b = fmt.Appendf(b, "#[Mean    = %12.*f, StdDeviation   = %12.*f]\n", precision, h.Mean()/ratio, precision, h.StdDev()/ratio)
+		b = fmt.Appendf(b, "#[Max     = %12.*f, Total count    = %12d]\n", precision, float64(h.Max())/ratio, h.TotalCount())
+		b = fmt.Appendf(b, "#[Buckets = %12d, SubBuckets     = %12d]\n", h.bucketCount, h.subBucketCount)
+	}
+	_, err = w.Write(b)
+	return rows, err
+}
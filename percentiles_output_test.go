@@ -0,0 +1,111 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitSymbol(t *testing.T) {
+	assert.Equal(t, "ns", unitSymbol(time.Nanosecond))
+	assert.Equal(t, "µs", unitSymbol(time.Microsecond))
+	assert.Equal(t, "ms", unitSymbol(time.Millisecond))
+	assert.Equal(t, "s", unitSymbol(time.Second))
+	assert.Equal(t, "1m0s", unitSymbol(time.Minute))
+}
+
+func TestWritePercentiles_matchesOutputPercentileDistribution(t *testing.T) {
+	h := latencies(t, 1)
+	for _, csv := range []bool{false, true} {
+		var want, got bytes.Buffer
+		assert.Nil(t, h.OutputPercentileDistribution(&want, 5, 1000, csv))
+		options := &PercentilesOptions{ValueUnitRatio: 1000}
+		if csv {
+			options.Format = PercentilesCSV
+		}
+		rows, err := h.WritePercentiles(&got, options)
+		assert.Nil(t, err)
+		assert.Equal(t, want.String(), got.String())
+		assert.Equal(t, 100.0, rows[len(rows)-1].Percentile)
+		assert.Equal(t, h.TotalCount(), rows[len(rows)-1].TotalCount)
+	}
+}
+
+func TestWritePercentiles_explicitPercentiles(t *testing.T) {
+	var b bytes.Buffer
+	rows, err := latencies(t, 1).WritePercentiles(&b, &PercentilesOptions{
+		Percentiles:  []float64{50, 99.9, 100},
+		RecordedUnit: time.Microsecond,
+		OutputUnit:   time.Millisecond,
+		Precision:    2,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []PercentileRow{
+		{Percentile: 50, Value: 1, TotalCount: 5050},
+		{Percentile: 99.9, Value: 1.099, TotalCount: 10000},
+		{Percentile: 100, Value: 1000.447, TotalCount: 10010},
+	}, rows)
+	assert.InDelta(t, 1000.0, rows[1].InversePercentile(), 1e-6)
+	assert.True(t, math.IsInf(rows[2].InversePercentile(), 1))
+	lines := strings.Split(b.String(), "\n")
+	assert.Equal(t, "  Value (ms)     Percentile TotalCount 1/(1-Percentile)", lines[0])
+	assert.Equal(t, "        1.00 0.500000000000       5050           2.00", lines[2])
+	assert.Equal(t, "     1000.45 1.000000000000      10010", lines[4])
+	assert.Equal(t, "#[Max     =      1000.45, Total count    =        10010]", lines[6])
+
+	b.Reset()
+	_, err = latencies(t, 1).WritePercentiles(&b, &PercentilesOptions{
+		Format:       PercentilesCSV,
+		Percentiles:  []float64{50},
+		RecordedUnit: time.Microsecond,
+		OutputUnit:   time.Millisecond,
+		Precision:    -1,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "\"Value (ms)\",\"Percentile\",\"TotalCount\",\"1/(1-Percentile)\"\n1,0.500000000000,5050,2.00\n", b.String())
+}
+
+func TestWritePercentiles_JSON(t *testing.T) {
+	var b bytes.Buffer
+	h := latencies(t, 1)
+	rows, err := h.WritePercentiles(&b, &PercentilesOptions{
+		Format:       PercentilesJSON,
+		Percentiles:  []float64{50, 100},
+		RecordedUnit: time.Microsecond,
+		OutputUnit:   time.Millisecond,
+	})
+	assert.Nil(t, err)
+	var out percentilesJSON
+	assert.Nil(t, json.Unmarshal(b.Bytes(), &out))
+	assert.Equal(t, "ms", out.Unit)
+	assert.Equal(t, rows, out.Percentiles)
+	assert.Equal(t, float64(h.Max())/1000, out.Max)
+	assert.Equal(t, h.TotalCount(), out.TotalCount)
+
+	b.Reset()
+	rows, err = New(1, 1000, 3).WritePercentiles(&b, &PercentilesOptions{Format: PercentilesJSON})
+	assert.Nil(t, err)
+	assert.Nil(t, rows)
+	assert.Contains(t, b.String(), `"percentiles":[]`)
+}
+
+func TestPercentileRows(t *testing.T) {
+	h := latencies(t, 1)
+	rows, err := h.PercentileRows(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(h.CumulativeDistributionWithTicks(5)), len(rows))
+
+	for _, options := range []*PercentilesOptions{
+		{Format: PercentilesJSON + 1},
+		{Percentiles: []float64{101}},
+		{ValueUnitRatio: -1},
+	} {
+		_, err := h.PercentileRows(options)
+		assert.NotNil(t, err)
+	}
+}
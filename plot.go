@@ -0,0 +1,324 @@
+package hdrhistogram
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PlotSeries is a histogram drawn by the plots, named Label in their legend.
+type PlotSeries struct {
+	Label     string
+	Histogram *Histogram
+}
+
+// PlotSLOPoint is a target of a service level objective: values at Percentile
+// must not exceed Value, in the plot's output unit.
+type PlotSLOPoint struct {
+	Percentile float64
+	Value      float64
+}
+
+// PlotSLO is a service level objective drawn by WritePercentilePlotSVG as a
+// dashed step line, at the Value of every point up to its Percentile. A
+// distribution meets the objective if its curve stays below the line.
+type PlotSLO struct {
+	Label  string
+	Points []PlotSLOPoint
+}
+
+// PercentilePlotOptions configures WritePercentilePlotSVG. Zero fields use
+// their defaults.
+type PercentilePlotOptions struct {
+	// Title is drawn above the plot.
+	Title string
+	// Width and Height are the size of the plot in pixels, 800 by 500 by
+	// default.
+	Width, Height int
+	// ValueUnitRatio is the ratio values are divided by, 1 by default, and
+	// ValueUnit their unit once divided, such as "ms", used in the axis label.
+	ValueUnitRatio float64
+	ValueUnit      string
+	// TicksPerHalfDistance is the number of points the curves have per half
+	// distance to 100%, as used by CumulativeDistributionWithTicks, 5 by
+	// default.
+	TicksPerHalfDistance int32
+	// SLOs are drawn over the distributions.
+	SLOs []PlotSLO
+}
+
+// plotColors are the colours of the series, reused once all are taken.
+var plotColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+// The margins around the plot area, leaving room for the title, axis labels
+// and legend.
+const (
+	plotMarginLeft   = 70
+	plotMarginRight  = 30
+	plotMarginTop    = 40
+	plotMarginBottom = 50
+)
+
+// WritePercentilePlotSVG writes a standalone SVG image of the percentile
+// distributions of series, in the usual layout of HdrHistogram plots: the x
+// axis is the percentile on an inverted log scale of 1/(1-percentile), so
+// that 90%, 99%, 99.9% and so on are evenly spaced, and the y axis the value
+// at that percentile. The x axis reaches the highest percentile any of the
+// distributions resolves, and the y axis their highest value and that of the
+// SLOs.
+func WritePercentilePlotSVG(w io.Writer, series []PlotSeries, options *PercentilePlotOptions) error {
+	p, err := newPercentilePlot(series, options)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	p.writeSVG(b)
+	// The buffered writer keeps the first write error.
+	return b.Flush()
+}
+
+// WritePercentilePlotHTML writes the plot of WritePercentilePlotSVG wrapped
+// in a standalone HTML page, which can be opened offline in any browser.
+func WritePercentilePlotHTML(w io.Writer, series []PlotSeries, options *PercentilePlotOptions) error {
+	p, err := newPercentilePlot(series, options)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	writeHTMLPage(b, p.options.Title, p.writeSVG)
+	return b.Flush()
+}
+
+// writeHTMLPage writes an HTML page titled title, with a body written by
+// writeBody.
+func writeHTMLPage(b *bufio.Writer, title string, writeBody func(b *bufio.Writer)) {
+	if title == "" {
+		title = "HdrHistogram"
+	}
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(b, "<title>%s</title>\n", html.EscapeString(title))
+	b.WriteString("<style>body { margin: 2em; text-align: center; font-family: sans-serif; }</style>\n</head>\n<body>\n")
+	writeBody(b)
+	b.WriteString("</body>\n</html>\n")
+}
+
+// plotPoint is a point of a curve, x in decades of 1/(1-percentile) and y in
+// the output unit.
+type plotPoint struct {
+	x, y float64
+}
+
+type percentilePlot struct {
+	options PercentilePlotOptions
+	labels  []string
+	curves  [][]plotPoint
+	// nines is the number of decades of the x axis, 2 for up to 99%.
+	nines        int
+	yMax, yStep  float64
+	yDecimals    int
+	plotW, plotH float64
+}
+
+func newPercentilePlot(series []PlotSeries, options *PercentilePlotOptions) (*percentilePlot, error) {
+	if len(series) == 0 {
+		return nil, errors.New("no histograms to plot")
+	}
+	p := &percentilePlot{}
+	if options != nil {
+		p.options = *options
+	}
+	o := &p.options
+	if o.Width == 0 {
+		o.Width = 800
+	}
+	if o.Height == 0 {
+		o.Height = 500
+	}
+	if o.ValueUnitRatio == 0 {
+		o.ValueUnitRatio = 1
+	}
+	if o.TicksPerHalfDistance == 0 {
+		o.TicksPerHalfDistance = 5
+	}
+	p.plotW = float64(o.Width - plotMarginLeft - plotMarginRight)
+	p.plotH = float64(o.Height - plotMarginTop - plotMarginBottom)
+	if p.plotW <= 0 || p.plotH <= 0 || o.ValueUnitRatio < 0 || o.TicksPerHalfDistance < 0 {
+		return nil, errors.New("plot size, value unit ratio and ticks must be positive")
+	}
+
+	maxX, maxY := 2.0, 0.0
+	for _, s := range series {
+		if s.Histogram == nil {
+			return nil, fmt.Errorf("series %q has no histogram", s.Label)
+		}
+		var curve []plotPoint
+		if s.Histogram.TotalCount() > 0 {
+			for _, bracket := range s.Histogram.CumulativeDistributionWithTicks(o.TicksPerHalfDistance) {
+				// 100% has no place on the axis, it is drawn at its end.
+				x := math.Inf(1)
+				if bracket.Quantile < 100 {
+					x = percentileX(bracket.Quantile)
+					maxX = max(maxX, x)
+				}
+				y := float64(bracket.ValueAt) / o.ValueUnitRatio
+				maxY = max(maxY, y)
+				curve = append(curve, plotPoint{x, y})
+			}
+		}
+		p.labels = append(p.labels, s.Label)
+		p.curves = append(p.curves, curve)
+	}
+	for _, slo := range o.SLOs {
+		for _, point := range slo.Points {
+			if point.Percentile < 0 || point.Percentile >= 100 {
+				return nil, fmt.Errorf("SLO %q percentile %v out of range [0, 100)", slo.Label, point.Percentile)
+			}
+			maxX = max(maxX, percentileX(point.Percentile))
+			maxY = max(maxY, point.Value)
+		}
+	}
+	// Rounding guards against percentiles such as 99.9 landing a hair past
+	// their decade.
+	p.nines = int(math.Ceil(maxX - 1e-9))
+	p.yStep = niceStep(maxY / 5)
+	p.yMax = math.Ceil(maxY/p.yStep) * p.yStep
+	if p.yMax == 0 {
+		p.yMax = p.yStep
+	}
+	p.yDecimals = max(0, -int(math.Floor(math.Log10(p.yStep))))
+	return p, nil
+}
+
+// percentileX returns the position of percentile on the x axis, in decades of
+// 1/(1-percentile).
+func percentileX(percentile float64) float64 {
+	return math.Log10(100 / (100 - percentile))
+}
+
+// percentileLabel returns the label of the decade nines of the x axis, such
+// as 99.9% for 3.
+func percentileLabel(nines int) string {
+	switch nines {
+	case 0:
+		return "0%"
+	case 1:
+		return "90%"
+	case 2:
+		return "99%"
+	}
+	return "99." + strings.Repeat("9", nines-2) + "%"
+}
+
+// niceStep returns the smallest of 1, 2 or 5 times a power of ten at least
+// raw, used as the distance between axis ticks.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, step := range []float64{1, 2, 5} {
+		if step*magnitude >= raw {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// sx and sy map a point to the coordinates of the image.
+func (p *percentilePlot) sx(x float64) float64 {
+	return plotMarginLeft + min(x, float64(p.nines))/float64(p.nines)*p.plotW
+}
+
+func (p *percentilePlot) sy(y float64) float64 {
+	return plotMarginTop + p.plotH - y/p.yMax*p.plotH
+}
+
+func (p *percentilePlot) writeSVG(b *bufio.Writer) {
+	o := &p.options
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n",
+		o.Width, o.Height, o.Width, o.Height)
+	b.WriteString("<rect width=\"100%\" height=\"100%\" fill=\"white\"/>\n")
+	if o.Title != "" {
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"24\" text-anchor=\"middle\" font-size=\"16\">%s</text>\n", o.Width/2, html.EscapeString(o.Title))
+	}
+
+	// The grid and axis labels.
+	b.WriteString("<g stroke=\"#ddd\">\n")
+	for i := 0; i <= p.nines; i++ {
+		x := p.sx(float64(i))
+		fmt.Fprintf(b, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%.1f\"/>\n", x, plotMarginTop, x, plotMarginTop+p.plotH)
+	}
+	for y := 0.0; y <= p.yMax+p.yStep/2; y += p.yStep {
+		fmt.Fprintf(b, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\"/>\n", plotMarginLeft, p.sy(y), plotMarginLeft+p.plotW, p.sy(y))
+	}
+	b.WriteString("</g>\n<g fill=\"#333\">\n")
+	for i := 0; i <= p.nines; i++ {
+		fmt.Fprintf(b, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%s</text>\n", p.sx(float64(i)), plotMarginTop+p.plotH+18, percentileLabel(i))
+	}
+	for y := 0.0; y <= p.yMax+p.yStep/2; y += p.yStep {
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", plotMarginLeft-6, p.sy(y)+4, strconv.FormatFloat(y, 'f', p.yDecimals, 64))
+	}
+	fmt.Fprintf(b, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">Percentile</text>\n", plotMarginLeft+p.plotW/2, o.Height-10)
+	yLabel := "Value"
+	if o.ValueUnit != "" {
+		yLabel += " (" + o.ValueUnit + ")"
+	}
+	fmt.Fprintf(b, "<text transform=\"translate(16 %.1f) rotate(-90)\" text-anchor=\"middle\">%s</text>\n", plotMarginTop+p.plotH/2, html.EscapeString(yLabel))
+	b.WriteString("</g>\n")
+
+	// The curves, then the SLOs over them.
+	for i, curve := range p.curves {
+		if len(curve) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"1.5\" points=\"", plotColors[i%len(plotColors)])
+		for j, point := range curve {
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(b, "%.1f,%.1f", p.sx(point.x), p.sy(point.y))
+		}
+		b.WriteString("\"/>\n")
+	}
+	for _, slo := range o.SLOs {
+		if len(slo.Points) == 0 {
+			continue
+		}
+		points := append([]PlotSLOPoint(nil), slo.Points...)
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Percentile < points[j].Percentile
+		})
+		b.WriteString("<polyline fill=\"none\" stroke=\"#000\" stroke-width=\"1.5\" stroke-dasharray=\"6 4\" points=\"")
+		from := 0.0
+		for j, point := range points {
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			to := percentileX(point.Percentile)
+			fmt.Fprintf(b, "%.1f,%.1f %.1f,%.1f", p.sx(from), p.sy(point.Value), p.sx(to), p.sy(point.Value))
+			from = to
+		}
+		b.WriteString("\"/>\n")
+	}
+
+	// The legend, in the top left corner where curves are lowest.
+	y := plotMarginTop + 16
+	b.WriteString("<g fill=\"#333\">\n")
+	for i, label := range p.labels {
+		fmt.Fprintf(b, "<rect x=\"%d\" y=\"%d\" width=\"14\" height=\"3\" fill=\"%s\"/>\n", plotMarginLeft+12, y-5, plotColors[i%len(plotColors)])
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"%d\">%s</text>\n", plotMarginLeft+32, y, html.EscapeString(label))
+		y += 16
+	}
+	for _, slo := range o.SLOs {
+		fmt.Fprintf(b, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#000\" stroke-width=\"1.5\" stroke-dasharray=\"4 2\"/>\n", plotMarginLeft+12, y-4, plotMarginLeft+26, y-4)
+		fmt.Fprintf(b, "<text x=\"%d\" y=\"%d\">%s</text>\n", plotMarginLeft+32, y, html.EscapeString(slo.Label))
+		y += 16
+	}
+	b.WriteString("</g>\n</svg>\n")
+}
@@ -0,0 +1,100 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// svgElements parses an SVG image, failing the test if it isn't well formed,
+// and returns its elements by name.
+func svgElements(t *testing.T, svg string) map[string][]xml.StartElement {
+	t.Helper()
+	elements := make(map[string][]xml.StartElement)
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return elements
+		}
+		if !assert.Nil(t, err) {
+			return elements
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			elements[start.Name.Local] = append(elements[start.Name.Local], start)
+		}
+	}
+}
+
+func svgAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func TestPercentilePlot(t *testing.T) {
+	fast, slow := New(1, 1000000, 3), New(1, 1000000, 3)
+	for i := int64(1); i <= 10000; i++ {
+		assert.Nil(t, fast.RecordValue(i))
+		assert.Nil(t, slow.RecordValue(i*10))
+	}
+	series := []PlotSeries{{"fast", fast}, {"slow <p99>", slow}, {"idle", New(1, 1000, 3)}}
+	var b bytes.Buffer
+	err := WritePercentilePlotSVG(&b, series, &PercentilePlotOptions{
+		Title:          "Latency & throughput",
+		ValueUnitRatio: 1000,
+		ValueUnit:      "ms",
+		SLOs:           []PlotSLO{{"SLO", []PlotSLOPoint{{99.9, 120}, {90, 50}}}},
+	})
+	assert.Nil(t, err)
+	svg := b.String()
+	elements := svgElements(t, svg)
+	assert.Equal(t, "800", svgAttr(elements["svg"][0], "width"))
+	// a curve per non-empty series, and the SLO
+	assert.Equal(t, 3, len(elements["polyline"]))
+	assert.Equal(t, "none", svgAttr(elements["polyline"][0], "fill"))
+	assert.NotEqual(t, svgAttr(elements["polyline"][0], "stroke"), svgAttr(elements["polyline"][1], "stroke"))
+	assert.Equal(t, "6 4", svgAttr(elements["polyline"][2], "stroke-dasharray"))
+	assert.Contains(t, svg, "Latency &amp; throughput")
+	assert.Contains(t, svg, "slow &lt;p99&gt;")
+	assert.Contains(t, svg, ">idle<")
+	assert.Contains(t, svg, "Value (ms)")
+	// 10000 values resolve past 99.99%, and the slow curve reaches 100ms
+	for _, label := range []string{">0%<", ">90%<", ">99%<", ">99.9%<", ">99.99%<", ">99.999%<", ">100<"} {
+		assert.Contains(t, svg, label)
+	}
+	assert.NotContains(t, svg, ">99.9999%<")
+
+	b.Reset()
+	assert.Nil(t, WritePercentilePlotHTML(&b, series, nil))
+	page := b.String()
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, "<title>HdrHistogram</title>")
+	assert.Contains(t, page, "<svg xmlns=\"http://www.w3.org/2000/svg\"")
+	assert.True(t, strings.HasSuffix(page, "</html>\n"))
+}
+
+func TestPercentilePlot_errors(t *testing.T) {
+	h := New(1, 1000, 3)
+	var b bytes.Buffer
+	assert.NotNil(t, WritePercentilePlotSVG(&b, nil, nil))
+	assert.NotNil(t, WritePercentilePlotSVG(&b, []PlotSeries{{"nil", nil}}, nil))
+	assert.NotNil(t, WritePercentilePlotSVG(&b, []PlotSeries{{"h", h}}, &PercentilePlotOptions{Width: 50}))
+	assert.NotNil(t, WritePercentilePlotHTML(&b, []PlotSeries{{"h", h}}, &PercentilePlotOptions{
+		SLOs: []PlotSLO{{"SLO", []PlotSLOPoint{{100, 1}}}},
+	}))
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestNiceStep(t *testing.T) {
+	for raw, step := range map[float64]float64{0: 1, 0.03: 0.05, 1: 1, 1.2: 2, 3: 5, 7: 10, 2000: 2000, 2001: 5000} {
+		assert.InDelta(t, step, niceStep(raw), 1e-12, raw)
+	}
+}
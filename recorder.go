@@ -0,0 +1,98 @@
+package hdrhistogram
+
+import "sync"
+
+// An IntervalSource hands out the values recorded over successive intervals.
+type IntervalSource interface {
+	// IntervalHistogram returns a histogram of the values recorded since the
+	// previous call. The histogram may be reused, so it is only valid until
+	// the next call.
+	IntervalHistogram() *Histogram
+}
+
+// A Recorder records values from any number of goroutines while interval
+// histograms are taken from it. It keeps two histograms and swaps them on
+// every IntervalHistogram call, so taking an interval never allocates and
+// never blocks recording for longer than the swap.
+type Recorder struct {
+	mu       sync.Mutex
+	active   *Histogram
+	inactive *Histogram
+}
+
+// NewRecorder returns a new Recorder whose histograms are created with the
+// given parameters, as by New.
+func NewRecorder(lowestDiscernibleValue, highestTrackableValue int64, numberOfSignificantValueDigits int) *Recorder {
+	return &Recorder{
+		active:   New(lowestDiscernibleValue, highestTrackableValue, numberOfSignificantValueDigits),
+		inactive: New(lowestDiscernibleValue, highestTrackableValue, numberOfSignificantValueDigits),
+	}
+}
+
+// RecordValue records the given value, returning an error if the value is out
+// of range.
+func (r *Recorder) RecordValue(v int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active.RecordValue(v)
+}
+
+// RecordCorrectedValue records the given value, correcting for stalls in the
+// recording process, as Histogram.RecordCorrectedValue does.
+func (r *Recorder) RecordCorrectedValue(v, expectedInterval int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active.RecordCorrectedValue(v, expectedInterval)
+}
+
+// RecordValues records n occurrences of the given value, returning an error if
+// the value is out of range.
+func (r *Recorder) RecordValues(v, n int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active.RecordValues(v, n)
+}
+
+// IntervalHistogram returns a histogram of the values recorded since the
+// previous call, and starts a new interval. The histogram is only valid until
+// the next call, which resets it and records the following interval into it.
+// Copy it, or Merge it into a histogram of your own, to keep it longer.
+func (r *Recorder) IntervalHistogram() *Histogram {
+	r.inactive.Reset()
+	r.mu.Lock()
+	r.active, r.inactive = r.inactive, r.active
+	r.mu.Unlock()
+	return r.inactive
+}
+
+// windowedIntervalSource takes intervals from a WindowedHistogram.
+type windowedIntervalSource struct {
+	w        *WindowedHistogram
+	mu       sync.Locker
+	interval *Histogram
+}
+
+// NewWindowedIntervalSource returns an IntervalSource that hands out the
+// current section of w as each interval, rotating the window to start the
+// next one. WindowedHistogram is not safe for concurrent use, so when values
+// are recorded into w from other goroutines, mu must be the lock they hold
+// while doing so; it is held while the section is copied and rotated.
+func NewWindowedIntervalSource(w *WindowedHistogram, mu sync.Locker) IntervalSource {
+	return &windowedIntervalSource{w: w, mu: mu}
+}
+
+func (s *windowedIntervalSource) IntervalHistogram() *Histogram {
+	if s.mu != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	current := s.w.Current
+	if s.interval == nil {
+		s.interval = New(current.LowestTrackableValue(), current.HighestTrackableValue(), int(current.SignificantFigures()))
+	}
+	// The section is copied, as a window of one section resets it on Rotate.
+	s.interval.Reset()
+	s.interval.Merge(current)
+	s.w.Rotate()
+	return s.interval
+}
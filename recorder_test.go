@@ -0,0 +1,83 @@
+package hdrhistogram
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder_IntervalHistogram(t *testing.T) {
+	r := NewRecorder(1, 1000, 3)
+	assert.Nil(t, r.RecordValue(10))
+	assert.Nil(t, r.RecordValues(20, 2))
+	assert.Nil(t, r.RecordCorrectedValue(100, 40))
+	assert.NotNil(t, r.RecordValue(100000))
+
+	first := r.IntervalHistogram()
+	assert.Equal(t, int64(5), first.TotalCount())
+	assert.Equal(t, int64(100), first.Max())
+
+	assert.Nil(t, r.RecordValue(7))
+	second := r.IntervalHistogram()
+	assert.Equal(t, int64(1), second.TotalCount())
+	assert.Equal(t, int64(7), second.Max())
+
+	// the next call resets the previous interval and records into it
+	assert.NotSame(t, first, second)
+	assert.Equal(t, int64(0), first.TotalCount())
+	assert.Nil(t, r.RecordValue(8))
+	assert.Equal(t, int64(1), first.TotalCount())
+	third := r.IntervalHistogram()
+	assert.Same(t, first, third)
+	assert.Equal(t, int64(8), third.Max())
+	assert.Equal(t, int64(0), second.TotalCount())
+	assert.Equal(t, int64(0), r.IntervalHistogram().TotalCount())
+}
+
+func TestRecorder_concurrent(t *testing.T) {
+	r := NewRecorder(1, 1000, 3)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				assert.Nil(t, r.RecordValue(int64(i%100+1)))
+			}
+		}()
+	}
+	done := make(chan struct{})
+	var total int64
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			total += r.IntervalHistogram().TotalCount()
+		}
+	}()
+	wg.Wait()
+	<-done
+	total += r.IntervalHistogram().TotalCount()
+	assert.Equal(t, int64(4000), total)
+}
+
+func TestWindowedIntervalSource(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		w := NewWindowed(n, 1, 1000, 3)
+		var mu sync.Mutex
+		source := NewWindowedIntervalSource(w, &mu)
+		assert.Nil(t, w.Current.RecordValue(5))
+		assert.Nil(t, w.Current.RecordValue(6))
+		interval := source.IntervalHistogram()
+		assert.Equal(t, int64(2), interval.TotalCount(), "window of %d", n)
+		assert.Equal(t, int64(0), w.Current.TotalCount())
+
+		assert.Nil(t, w.Current.RecordValue(9))
+		interval = source.IntervalHistogram()
+		assert.Equal(t, int64(1), interval.TotalCount())
+		assert.Equal(t, int64(9), interval.Max())
+		if n == 3 {
+			assert.Equal(t, int64(3), w.Merge().TotalCount())
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package hdrhistogram
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// TerminalColor selects whether the terminal renderers use colour.
+type TerminalColor int
+
+const (
+	// ColorAuto uses colour when writing to a terminal, unless the NO_COLOR
+	// environment variable is set or TERM is dumb.
+	ColorAuto TerminalColor = iota
+	// ColorAlways always uses colour.
+	ColorAlways
+	// ColorNever never uses colour.
+	ColorNever
+)
+
+// TerminalOptions configures the terminal renderers. Zero fields use their
+// defaults.
+type TerminalOptions struct {
+	// Width is the width of the output in characters, 80 by default, and
+	// Height that of heatmaps written by Heatmap.WriteANSI in lines, 24 by
+	// default.
+	Width  int
+	Height int
+	// Color selects whether ANSI colours are used.
+	Color TerminalColor
+	// ASCII draws with ASCII characters only, rather than Unicode blocks.
+	ASCII bool
+	// ValueUnitRatio is the ratio values are divided by, 1 by default, and
+	// ValueUnit their unit once divided, such as "ms".
+	ValueUnitRatio float64
+	ValueUnit      string
+	// RowsPerDoubling is the number of rows of bar charts per doubling of
+	// value, 1 by default.
+	RowsPerDoubling int
+}
+
+// The ANSI escape sequences of the terminal renderers.
+const (
+	ansiReset = "\x1b[0m"
+	ansiDim   = "\x1b[2m"
+	ansiFirst = "\x1b[36m"
+	ansiOther = "\x1b[35m"
+)
+
+// Unicode blocks of growing height and width, and their ASCII stand-ins.
+var (
+	sparkLevels        = []rune("▁▂▃▄▅▆▇█")
+	asciiSparkLevels   = []rune("_.,-=+*#")
+	barEighths         = []rune(" ▏▎▍▌▋▊▉")
+	heatmapShades      = []rune(" ░▒▓█")
+	asciiHeatmapShades = []rune(" .:+#")
+)
+
+// terminal holds the resolved options of a terminal renderer.
+type terminal struct {
+	TerminalOptions
+	color bool
+}
+
+func newTerminal(w io.Writer, options *TerminalOptions) (*terminal, error) {
+	t := &terminal{}
+	if options != nil {
+		t.TerminalOptions = *options
+	}
+	if t.Width == 0 {
+		t.Width = 80
+	}
+	if t.ValueUnitRatio == 0 {
+		t.ValueUnitRatio = 1
+	}
+	if t.Height == 0 {
+		t.Height = 24
+	}
+	if t.RowsPerDoubling == 0 {
+		t.RowsPerDoubling = 1
+	}
+	if t.Width < 40 || t.Height < 0 || t.ValueUnitRatio < 0 || t.RowsPerDoubling < 0 {
+		return nil, errors.New("terminal width must be at least 40, and height, value unit ratio and rows positive")
+	}
+	switch t.Color {
+	case ColorAlways:
+		t.color = true
+	case ColorAuto:
+		t.color = isTerminal(w)
+	}
+	return t, nil
+}
+
+// isTerminal reports whether w is a terminal colours can be used on.
+func isTerminal(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint returns s in the given colour, if colours are used.
+func (t *terminal) paint(s, color string) string {
+	if !t.color || s == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// value formats a value in the output unit.
+func (t *terminal) value(v float64) string {
+	return formatPlotValue(v / t.ValueUnitRatio)
+}
+
+// unitLabel returns the label of the value column.
+func (t *terminal) unitLabel() string {
+	if t.ValueUnit == "" {
+		return "value"
+	}
+	return "value (" + t.ValueUnit + ")"
+}
+
+// bar returns a bar of count on a log scale, up to width characters long for
+// maxCount, growing to the right, or to the left with leftward.
+func (t *terminal) bar(count, maxCount int64, width int, leftward bool) string {
+	if count == 0 {
+		return ""
+	}
+	// A count of one still gets a sliver.
+	eighths := max(1, int(math.Round(math.Log1p(float64(count))/math.Log1p(float64(maxCount))*float64(width*8))))
+	full, part := eighths/8, eighths%8
+	var b strings.Builder
+	switch {
+	case t.ASCII:
+		b.WriteString(strings.Repeat("#", full))
+		if part >= 4 {
+			b.WriteByte('#')
+		}
+	case leftward:
+		// Unicode has no left growing eighths, only a right half block.
+		if part >= 4 {
+			b.WriteRune('▐')
+		}
+		b.WriteString(strings.Repeat("█", full))
+	default:
+		b.WriteString(strings.Repeat("█", full))
+		if part > 0 {
+			b.WriteRune(barEighths[part])
+		}
+	}
+	return b.String()
+}
+
+// WriteBarChart writes the distribution of h to w as a horizontal bar chart
+// for terminals, a bar per log spaced range of values from the lowest to the
+// highest recorded, with bar lengths on a log scale of their count so the
+// sparse tail remains visible next to the bulk of the values.
+func (h *Histogram) WriteBarChart(w io.Writer, options *TerminalOptions) error {
+	t, err := newTerminal(w, options)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	minRow, counts := logRowCounts(h, t.RowsPerDoubling)
+	if counts == nil {
+		b.WriteString("no values\n")
+		return b.Flush()
+	}
+	maxCount := int64(0)
+	for _, count := range counts {
+		maxCount = max(maxCount, count)
+	}
+	const valueW, countW = 9, 10
+	barW := t.Width - 2*valueW - countW - 6
+	fmt.Fprintf(b, "%s\n", t.paint(fmt.Sprintf("%*s%*s", 2*valueW+4, t.unitLabel(), countW, "count"), ansiDim))
+	for i, count := range counts {
+		row := minRow + i
+		from := t.value(logRowValue(row, t.RowsPerDoubling))
+		to := t.value(logRowValue(row+1, t.RowsPerDoubling))
+		line := fmt.Sprintf("%*s .. %-*s%*d  %s", valueW, from, valueW, to, countW, count, t.paint(t.bar(count, maxCount, barW, false), ansiFirst))
+		b.WriteString(strings.TrimRight(line, " "))
+		b.WriteByte('\n')
+	}
+	return b.Flush()
+}
+
+// WriteSparkline writes the percentile distribution of h to w as a line for
+// terminals: a sparkline of the values from the 0th percentile to the highest
+// resolved, on the same inverted log percentile scale as
+// WritePercentilePlotSVG and a log scale of values, followed by the value
+// range.
+func (h *Histogram) WriteSparkline(w io.Writer, options *TerminalOptions) error {
+	t, err := newTerminal(w, options)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	if h.TotalCount() == 0 {
+		b.WriteString("no values\n")
+		return b.Flush()
+	}
+	b.WriteString(t.sparkline(h))
+	b.WriteByte('\n')
+	return b.Flush()
+}
+
+// sparkline returns the sparkline of WriteSparkline, without a line end.
+func (t *terminal) sparkline(h *Histogram) string {
+	// The highest percentile resolved, as in the percentile plots.
+	nines := 2
+	for _, bracket := range h.CumulativeDistributionWithTicks(1) {
+		if bracket.Quantile < 100 {
+			nines = max(nines, int(math.Ceil(percentileX(bracket.Quantile)-1e-9)))
+		}
+	}
+	lo, hi := float64(max(h.Min(), 1)), float64(max(h.Max(), 1))
+	unit := ""
+	if t.ValueUnit != "" {
+		unit = " " + t.ValueUnit
+	}
+	prefix := "0% "
+	suffix := fmt.Sprintf(" %s  %s..%s%s", percentileLabel(nines), t.value(float64(h.Min())), t.value(float64(h.Max())), unit)
+	width := max(1, t.Width-len(prefix)-len([]rune(suffix)))
+	levels := sparkLevels
+	if t.ASCII {
+		levels = asciiSparkLevels
+	}
+	var spark strings.Builder
+	for i := 0; i < width; i++ {
+		x := (float64(i) + 0.5) / float64(width) * float64(nines)
+		v := float64(max(h.ValueAtPercentile(100-100/math.Pow(10, x)), 1))
+		level := 0
+		if hi > lo {
+			level = int(math.Round((math.Log(v) - math.Log(lo)) / (math.Log(hi) - math.Log(lo)) * float64(len(levels)-1)))
+		}
+		spark.WriteRune(levels[min(max(level, 0), len(levels)-1)])
+	}
+	return t.paint(prefix, ansiDim) + t.paint(spark.String(), ansiFirst) + t.paint(suffix, ansiDim)
+}
+
+// WriteComparison writes a side by side comparison of the distributions of a
+// and b to w for terminals: their bar charts back to back, a growing to the
+// left and b to the right of their shared value ranges, then a table of their
+// percentiles with the change from a to b.
+func WriteComparison(w io.Writer, a, b PlotSeries, options *TerminalOptions) error {
+	t, err := newTerminal(w, options)
+	if err != nil {
+		return err
+	}
+	if a.Histogram == nil || b.Histogram == nil {
+		return errors.New("comparison needs two histograms")
+	}
+	out := bufio.NewWriter(w)
+	minA, countsA := logRowCounts(a.Histogram, t.RowsPerDoubling)
+	minB, countsB := logRowCounts(b.Histogram, t.RowsPerDoubling)
+	lo, hi := math.MaxInt, math.MinInt
+	maxCount := int64(0)
+	for _, rows := range []struct {
+		min    int
+		counts []int64
+	}{{minA, countsA}, {minB, countsB}} {
+		if rows.counts == nil {
+			continue
+		}
+		lo, hi = min(lo, rows.min), max(hi, rows.min+len(rows.counts)-1)
+		for _, count := range rows.counts {
+			maxCount = max(maxCount, count)
+		}
+	}
+	countAt := func(minRow int, counts []int64, row int) int64 {
+		if i := row - minRow; counts != nil && i >= 0 && i < len(counts) {
+			return counts[i]
+		}
+		return 0
+	}
+
+	const valueW, countW = 9, 8
+	barW := (t.Width - valueW - 2*countW - 6) / 2
+	fmt.Fprintf(out, "%s %s  %s\n",
+		t.paint(fmt.Sprintf("%*s", barW+countW+1, a.Label), ansiFirst),
+		t.paint(fmt.Sprintf("%*s", valueW, t.ValueUnit), ansiDim),
+		t.paint(b.Label, ansiOther))
+	for row := lo; row <= hi; row++ {
+		countA, countB := countAt(minA, countsA, row), countAt(minB, countsB, row)
+		// The left bar is padded before painting, so the escape sequences
+		// don't upset the alignment.
+		leftBar := t.bar(countA, maxCount, barW, true)
+		padding := strings.Repeat(" ", barW-utf8.RuneCountInString(leftBar))
+		line := fmt.Sprintf("%s%s %*d %*s  %-*d %s",
+			padding, t.paint(leftBar, ansiFirst), countW, countA,
+			valueW, t.value(logRowValue(row, t.RowsPerDoubling)),
+			countW, countB, t.paint(t.bar(countB, maxCount, barW, false), ansiOther))
+		out.WriteString(strings.TrimRight(line, " "))
+		out.WriteByte('\n')
+	}
+
+	const columnW = 12
+	fmt.Fprintf(out, "\n%s %s %s %s\n",
+		t.paint(fmt.Sprintf("%*s", columnW, "percentile"), ansiDim),
+		t.paint(fmt.Sprintf("%*s", columnW, a.Label), ansiFirst),
+		t.paint(fmt.Sprintf("%*s", columnW, b.Label), ansiOther),
+		t.paint(fmt.Sprintf("%*s", columnW, "change"), ansiDim))
+	for _, percentile := range []float64{50, 90, 99, 99.9, 99.99, 100} {
+		va, vb := a.Histogram.ValueAtPercentile(percentile), b.Histogram.ValueAtPercentile(percentile)
+		change := "n/a"
+		if va != 0 {
+			change = fmt.Sprintf("%+.1f%%", float64(vb-va)/float64(va)*100)
+		}
+		label := fmt.Sprintf("%g%%", percentile)
+		if percentile == 100 {
+			label = "max"
+		}
+		fmt.Fprintf(out, "%*s %*s %*s %*s\n", columnW, label, columnW, t.value(float64(va)), columnW, t.value(float64(vb)), columnW, change)
+	}
+	return out.Flush()
+}
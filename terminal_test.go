@@ -0,0 +1,98 @@
+package hdrhistogram
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// latencies returns a histogram of 10000 values around 1ms and 10 around 1s,
+// in microseconds.
+func latencies(t *testing.T, scale int64) *Histogram {
+	h := New(1, 10000000, 3)
+	for i := int64(0); i < 10000; i++ {
+		assert.Nil(t, h.RecordValue(scale*(900+i%200)))
+	}
+	assert.Nil(t, h.RecordValues(scale*1000000, 10))
+	return h
+}
+
+func TestWriteBarChart(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, latencies(t, 1).WriteBarChart(&b, &TerminalOptions{Width: 60, ValueUnitRatio: 1000, ValueUnit: "ms"}))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert.Equal(t, "            value (ms)     count", lines[0])
+	// a row per doubling from 512us to 1s
+	assert.Equal(t, 12, len(lines))
+	assert.Equal(t, "    0.512 .. 1.02           6200  ██████████████████████████", lines[1])
+	assert.Equal(t, "     1.02 .. 2.05           3800  ████████████████████████▌", lines[2])
+	assert.Equal(t, "     16.4 .. 32.8              0", lines[6])
+	assert.Equal(t, "      524 .. 1049             10  ███████▏", lines[11])
+	for _, line := range lines {
+		assert.LessOrEqual(t, utf8.RuneCountInString(line), 60)
+		assert.NotContains(t, line, "\x1b")
+	}
+
+	b.Reset()
+	assert.Nil(t, latencies(t, 1).WriteBarChart(&b, &TerminalOptions{ASCII: true, Color: ColorAlways, RowsPerDoubling: 2}))
+	assert.Contains(t, b.String(), ansiFirst+"#")
+	assert.NotContains(t, b.String(), "█")
+
+	b.Reset()
+	assert.Nil(t, New(1, 1000, 3).WriteBarChart(&b, nil))
+	assert.Equal(t, "no values\n", b.String())
+	assert.NotNil(t, New(1, 1000, 3).WriteBarChart(&b, &TerminalOptions{Width: 20}))
+}
+
+func TestWriteSparkline(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, latencies(t, 1).WriteSparkline(&b, &TerminalOptions{Width: 50, ValueUnitRatio: 1000, ValueUnit: "ms"}))
+	line := strings.TrimSuffix(b.String(), "\n")
+	assert.Equal(t, 50, utf8.RuneCountInString(line))
+	assert.True(t, strings.HasPrefix(line, "0% ▁"))
+	// the 10 slow values in 10010 show from 99.9%
+	assert.True(t, strings.HasSuffix(line, "█ 99.99%  0.900..1000 ms"), line)
+
+	b.Reset()
+	assert.Nil(t, latencies(t, 1).WriteSparkline(&b, &TerminalOptions{ASCII: true}))
+	assert.True(t, strings.HasPrefix(b.String(), "0% _"))
+
+	b.Reset()
+	assert.Nil(t, New(1, 1000, 3).WriteSparkline(&b, nil))
+	assert.Equal(t, "no values\n", b.String())
+}
+
+func TestWriteComparison(t *testing.T) {
+	var b bytes.Buffer
+	before, after := PlotSeries{"before", latencies(t, 1)}, PlotSeries{"after", latencies(t, 2)}
+	assert.Nil(t, WriteComparison(&b, before, after, &TerminalOptions{Width: 71, ValueUnitRatio: 1000, ValueUnit: "ms"}))
+	out := b.String()
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, "                       before        ms  after", lines[0])
+	for _, line := range lines {
+		assert.LessOrEqual(t, utf8.RuneCountInString(line), 70)
+	}
+	// rows from before's lowest to after's highest value
+	assert.Equal(t, "████████████████████     6200     0.512  0", lines[1])
+	assert.Equal(t, " ▐██████████████████     3800      1.02  6200     ████████████████████", lines[2])
+	assert.Equal(t, "              ▐█████       10       524  0", lines[11])
+	assert.Equal(t, "                            0      1049  10       █████▌", lines[12])
+	assert.Contains(t, out, "\n  percentile       before        after       change\n")
+	assert.Contains(t, out, "\n         50%         1.00         2.00      +100.0%\n")
+	assert.Contains(t, out, "\n         max         1000         2001      +100.0%\n")
+
+	assert.NotNil(t, WriteComparison(&b, before, PlotSeries{Label: "none"}, nil))
+}
+
+func TestIsTerminal(t *testing.T) {
+	assert.False(t, isTerminal(&bytes.Buffer{}))
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.False(t, isTerminal(f))
+}
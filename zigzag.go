@@ -86,48 +86,18 @@ func zig_zag_decode_i64(buf []byte) (signedValue int64, n int, err error) {
 // It does this in a way that "zig-zags" back and forth through the positive and negative integers,
 // so that -1 is encoded as 1, 1 is encoded as 2, -2 is encoded as 3, and so on.
 func zig_zag_encode_i64(signedValue int64) (buffer []byte) {
-	buffer = make([]byte, 0)
+	return zig_zag_append_i64(make([]byte, 0, 9), signedValue)
+}
+
+// Appends a int64_t value to the given buffer in LEB128 ZigZag encoded format and
+// returns the extended buffer.
+// Up to 8 bytes carry 7 bits each plus a continuation bit; a 9th byte, when needed,
+// carries the remaining 8 bits in full (LEB128-64b9B).
+func zig_zag_append_i64(buffer []byte, signedValue int64) []byte {
 	var value = uint64((signedValue << 1) ^ (signedValue >> 63))
-	if value>>7 == 0 {
-		buffer = append(buffer, byte(value))
-	} else {
-		buffer = append(buffer, byte((value&0x7F)|0x80))
-		if value>>14 == 0 {
-			buffer = append(buffer, byte(value>>7))
-		} else {
-			buffer = append(buffer, byte((value>>7)|0x80))
-			if value>>21 == 0 {
-				buffer = append(buffer, byte(value>>14))
-			} else {
-				buffer = append(buffer, byte((value>>14)|0x80))
-				if value>>28 == 0 {
-					buffer = append(buffer, byte(value>>21))
-				} else {
-					buffer = append(buffer, byte((value>>21)|0x80))
-					if value>>35 == 0 {
-						buffer = append(buffer, byte(value>>28))
-					} else {
-						buffer = append(buffer, byte((value>>28)|0x80))
-						if value>>42 == 0 {
-							buffer = append(buffer, byte(value>>35))
-						} else {
-							buffer = append(buffer, byte((value>>35)|0x80))
-							if value>>49 == 0 {
-								buffer = append(buffer, byte(value>>42))
-							} else {
-								buffer = append(buffer, byte((value>>42)|0x80))
-								if value>>56 == 0 {
-									buffer = append(buffer, byte(value>>49))
-								} else {
-									buffer = append(buffer, byte((value>>49)|0x80))
-									buffer = append(buffer, byte(value>>56))
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+	for i := 0; i < 8 && value>>7 != 0; i++ {
+		buffer = append(buffer, byte(value&0x7F)|0x80)
+		value >>= 7
 	}
-	return
+	return append(buffer, byte(value))
 }
@@ -79,3 +79,22 @@ func Test_zig_zag_encode_i64(t *testing.T) {
 		})
 	}
 }
+
+func Test_zig_zag_append_i64(t *testing.T) {
+	values := []int64{0, 1, -1, 63, -64, 64, 8191, -8192, 1 << 40, math.MaxInt64, math.MinInt64}
+	buf := []byte{0xff}
+	for _, v := range values {
+		buf = zig_zag_append_i64(buf, v)
+	}
+	if buf[0] != 0xff {
+		t.Fatalf("zig_zag_append_i64() overwrote the existing prefix")
+	}
+	pos := 1
+	for _, want := range values {
+		got, n, err := zig_zag_decode_i64(buf[pos:])
+		if err != nil || got != want {
+			t.Fatalf("zig_zag_decode_i64() = %v, %v, want %v", got, err, want)
+		}
+		pos += n
+	}
+}